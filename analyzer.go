@@ -0,0 +1,517 @@
+package sunlight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/monicachew/certificatetransparency"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An Analyzer summarizes the certs in CT log entries, accumulating issuer
+// reputations and examples of each violation along the way. ProcessEntry is
+// safe to call concurrently, so it can be handed directly to
+// EntriesFile.Map.
+type Analyzer struct {
+	// Every entry processed is counted in exactly one of these. They're
+	// updated atomically, so only read them once processing is done.
+	Summarized  uint64
+	ParseErrors uint64
+	Filtered    uint64
+	// Entries that arrived after processing was cancelled.
+	Skipped uint64
+	// Entries left out of the sample.
+	Unsampled uint64
+	// Certs from an issuer in ExcludedIssuers.
+	Excluded uint64
+	// Entries outside [MinIndex, MaxIndex).
+	OutOfRange uint64
+	// Violating certs that the sink failed to record.
+	WriteErrors uint64
+	// Summarized certs that weren't counted in any issuer reputation, since
+	// the one they belonged to had already been evicted.
+	EvictedUpdates uint64
+
+	// If set, gets a line with the index and error of each entry that
+	// couldn't be parsed.
+	ErrorLog     io.Writer
+	errorLogLock sync.Mutex
+	// If set, gets errors recording violating certs.
+	Log *Logger
+	// If set, entries that are RFC 9162 (CT v2) TransItems are parsed as such
+	// instead of being counted as parse errors.
+	AcceptV2 bool
+	// If in (0, 1), each entry is processed with this probability and the
+	// rest are only counted in Unsampled. Which entries are picked depends
+	// only on SampleSeed and their indices, so a run can be reproduced.
+	SampleRate float64
+	SampleSeed int64
+	// Only entries with indices in [MinIndex, MaxIndex) are processed, and
+	// the rest are only counted in OutOfRange. A MaxIndex of 0 means there's
+	// no upper limit. This lets a log be split into ranges for separate runs.
+	MinIndex uint64
+	MaxIndex uint64
+	// Certs issued before this are filtered out. NewAnalyzer sets it to
+	// DefaultNotBeforeCutoff, and the zero time includes every cert.
+	NotBeforeCutoff time.Time
+	// If set, certs that have already expired aren't filtered out.
+	IncludeExpired bool
+	// Certs that expired before this are filtered out. If zero, it's the time
+	// each entry is processed, so setting it lets a later run reproduce the
+	// output of an earlier one.
+	ExpiryReference time.Time
+	// Certs whose issuer DN (as given by DistinguishedNameToString) contains
+	// any of these are only counted in Excluded. This keeps test and staging
+	// CAs out of an analysis of production certs.
+	ExcludedIssuers []string
+	// Root CA maps, as read by ReadRootCAMap, keyed on the name of the root
+	// program (such as Mozilla or Apple) they're from. If set, each summary's
+	// RootPrograms records which of them its chain reaches.
+	RootPrograms map[string]map[string]bool
+	// If set, the issuers and subjects of leaf certs are recorded by public
+	// key, for KeyReuse.
+	TrackKeyReuse bool
+	// If non-zero, at most this many issuer reputations are held in memory.
+	// Once there are more, the oldest tenth (by month) are handed to Evict,
+	// unfinished, and forgotten, along with the examples of any issuer that
+	// has no reputations left. This bounds memory on huge runs, but an
+	// evicted reputation can't be reopened: certs that would have updated it
+	// are only counted in EvictedUpdates. Since CT logs are roughly in the
+	// order certs were logged, few should be.
+	MaxIssuers int
+	// Gets each evicted reputation, and the examples and when they were last
+	// seen for its issuer if they were evicted too (otherwise they're nil).
+	// It's called with the Analyzer's locks held, so it mustn't call back
+	// into the Analyzer.
+	Evict func(issuer *IssuerReputation,
+		examples map[string]*x509.Certificate, lastSeen map[string]uint64)
+
+	ranker    Ranker
+	rootCAMap map[string]bool
+	config    *RuleConfig
+	sink      Sink
+
+	// The lowest index of any skipped entry, which is where a cancelled run
+	// should resume. Only meaningful if Skipped is non-zero.
+	FirstSkipped   uint64
+	firstSkippedOK bool
+	skippedLock    sync.Mutex
+
+	// The validity periods of summarized leaf certs.
+	Validity ValidityHistogram
+
+	// Issuer reputations, keyed on issuer, issuer fingerprint and month.
+	Issuers     map[string]*IssuerReputation
+	issuersLock sync.RWMutex
+	// The keys of evicted issuer reputations.
+	evicted map[string]bool
+
+	// For each issuer and violation, the most recently logged example cert
+	// and when it was logged.
+	ExampleMap         map[string]map[string]*x509.Certificate
+	ExampleMapLastSeen map[string]map[string]uint64
+	exampleMapLock     sync.Mutex
+
+	// The issuers and subjects of leaf certs, keyed on the SHA-256 hash of
+	// their SubjectPublicKeyInfo.
+	keyIssuers   map[[32]byte]map[string]bool
+	keySubjects  map[[32]byte]map[string]bool
+	keyReuseLock sync.Mutex
+}
+
+// A public key that certs from several issuers were issued for.
+type ReusedKey struct {
+	// The base64 SHA-256 hash of the key's SubjectPublicKeyInfo.
+	SPKISha256 string
+	Issuers    []string
+	Subjects   []string
+}
+
+// By default, certs issued before 2013 are filtered out.
+var DefaultNotBeforeCutoff = time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Each cert that violates the baseline requirements is written to sink,
+// possibly concurrently, unless it's nil. If writing fails, the error is
+// logged and counted in WriteErrors, and processing carries on. The Analyzer
+// doesn't close sink.
+func NewAnalyzer(ranker Ranker, rootCAMap map[string]bool,
+	config *RuleConfig, sink Sink) *Analyzer {
+	return &Analyzer{
+		ranker:             ranker,
+		rootCAMap:          rootCAMap,
+		config:             config,
+		sink:               sink,
+		NotBeforeCutoff:    DefaultNotBeforeCutoff,
+		Issuers:            make(map[string]*IssuerReputation),
+		ExampleMap:         make(map[string]map[string]*x509.Certificate),
+		ExampleMapLastSeen: make(map[string]map[string]uint64),
+	}
+}
+
+// Parses the cert in a CT log entry and the chain it was logged with. Certs in
+// the chain that can't be parsed are left out of it rather than causing an
+// error.
+func ParseEntry(ent *certificatetransparency.EntryAndPosition) (leaf *x509.Certificate,
+	chain []*x509.Certificate, err error) {
+	leaf, err = ParseCertificate(ent.Entry.X509Cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain = make([]*x509.Certificate, 0, len(ent.Entry.ExtraCerts))
+	for _, certBytes := range ent.Entry.ExtraCerts {
+		cert, err := ParseCertificate(certBytes)
+		if err != nil {
+			continue
+		}
+		chain = append(chain, cert)
+	}
+	return leaf, chain, nil
+}
+
+func (a *Analyzer) parseError(ent *certificatetransparency.EntryAndPosition, err error) {
+	atomic.AddUint64(&a.ParseErrors, 1)
+	if a.ErrorLog == nil {
+		return
+	}
+	index := "unknown"
+	if ent != nil {
+		index = fmt.Sprintf("%d", ent.Index)
+	}
+	a.errorLogLock.Lock()
+	fmt.Fprintf(a.ErrorLog, "%s\t%s\n", index, err)
+	a.errorLogLock.Unlock()
+}
+
+// Returns true if cert, logged at timestamp, should replace the current
+// example of violation for issuer. The latest logged cert wins, with ties
+// broken on the cert's encoding so that the result doesn't depend on the
+// order entries are processed in. The caller must hold exampleMapLock.
+func (a *Analyzer) newerExample(issuer string, violation string,
+	cert *x509.Certificate, timestamp uint64) bool {
+	current := a.ExampleMap[issuer][violation]
+	if current == nil {
+		return true
+	}
+	lastSeen := a.ExampleMapLastSeen[issuer][violation]
+	if timestamp != lastSeen {
+		return timestamp > lastSeen
+	}
+	return bytes.Compare(cert.Raw, current.Raw) > 0
+}
+
+// Evicts the oldest tenth of the issuer reputations if there are more than
+// MaxIssuers. The caller must hold issuersLock.
+func (a *Analyzer) evictIssuers() {
+	if a.MaxIssuers <= 0 || len(a.Issuers) <= a.MaxIssuers {
+		return
+	}
+	keys := make([]string, 0, len(a.Issuers))
+	for key := range a.Issuers {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		first, second := a.Issuers[keys[i]], a.Issuers[keys[j]]
+		if first.BeginTime != second.BeginTime {
+			return first.BeginTime < second.BeginTime
+		}
+		return keys[i] < keys[j]
+	})
+	keep := keys[len(keys)-(a.MaxIssuers-a.MaxIssuers/10):]
+	remaining := make(map[string]bool)
+	for _, key := range keep {
+		remaining[a.Issuers[key].Issuer] = true
+	}
+	if a.evicted == nil {
+		a.evicted = make(map[string]bool)
+	}
+
+	a.exampleMapLock.Lock()
+	defer a.exampleMapLock.Unlock()
+	for _, key := range keys[:len(keys)-len(keep)] {
+		issuer := a.Issuers[key]
+		delete(a.Issuers, key)
+		a.evicted[key] = true
+		var examples map[string]*x509.Certificate
+		var lastSeen map[string]uint64
+		if !remaining[issuer.Issuer] {
+			examples = a.ExampleMap[issuer.Issuer]
+			lastSeen = a.ExampleMapLastSeen[issuer.Issuer]
+			delete(a.ExampleMap, issuer.Issuer)
+			delete(a.ExampleMapLastSeen, issuer.Issuer)
+		}
+		if a.Evict != nil {
+			a.Evict(issuer, examples, lastSeen)
+		}
+	}
+}
+
+func (a *Analyzer) trackKey(cert *x509.Certificate) {
+	key := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	a.keyReuseLock.Lock()
+	defer a.keyReuseLock.Unlock()
+	if a.keyIssuers == nil {
+		a.keyIssuers = make(map[[32]byte]map[string]bool)
+		a.keySubjects = make(map[[32]byte]map[string]bool)
+	}
+	if a.keyIssuers[key] == nil {
+		a.keyIssuers[key] = make(map[string]bool)
+		a.keySubjects[key] = make(map[string]bool)
+	}
+	a.keyIssuers[key][DistinguishedNameToString(cert.Issuer)] = true
+	a.keySubjects[key][DistinguishedNameToString(cert.Subject)] = true
+}
+
+// Returns the keys of leaf certs issued by more than maxIssuers distinct
+// issuers, ordered by hash, if TrackKeyReuse was set while processing. CA
+// certs aren't tracked, since cross-signing legitimately gets one key
+// certified by several issuers. Only call it once processing is done.
+func (a *Analyzer) KeyReuse(maxIssuers int) []*ReusedKey {
+	reused := make([]*ReusedKey, 0)
+	for key, issuers := range a.keyIssuers {
+		if len(issuers) <= maxIssuers {
+			continue
+		}
+		reused = append(reused, &ReusedKey{
+			SPKISha256: base64.StdEncoding.EncodeToString(key[:]),
+			Issuers:    sortedKeys(issuers),
+			Subjects:   sortedKeys(a.keySubjects[key]),
+		})
+	}
+	sort.Slice(reused, func(i, j int) bool {
+		return reused[i].SPKISha256 < reused[j].SPKISha256
+	})
+	return reused
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns a callback for EntriesFile.Map that processes entries until ctx is
+// done, then skips the rest. Map has no way to stop early, so the remaining
+// entries are still read, but only counted.
+func (a *Analyzer) EntryCallback(ctx context.Context) func(*certificatetransparency.EntryAndPosition, error) {
+	return func(ent *certificatetransparency.EntryAndPosition, err error) {
+		if ctx.Err() != nil {
+			a.skip(ent)
+			return
+		}
+		a.ProcessEntry(ent, err)
+	}
+}
+
+func (a *Analyzer) skip(ent *certificatetransparency.EntryAndPosition) {
+	a.skippedLock.Lock()
+	defer a.skippedLock.Unlock()
+	if ent != nil && (!a.firstSkippedOK || ent.Index < a.FirstSkipped) {
+		a.FirstSkipped = ent.Index
+		a.firstSkippedOK = true
+	}
+	a.Skipped++
+}
+
+// Returns true if the entry at index is in [MinIndex, MaxIndex).
+func (a *Analyzer) inRange(index uint64) bool {
+	return index >= a.MinIndex && (a.MaxIndex == 0 || index < a.MaxIndex)
+}
+
+// Returns true if the entry at index is in the sample. Entries are processed
+// concurrently and in no particular order, so rather than drawing from one
+// shared generator, each entry's draw comes from a splitmix64 generator
+// seeded with SampleSeed and the index.
+func (a *Analyzer) sampled(index uint64) bool {
+	if a.SampleRate <= 0 || a.SampleRate >= 1 {
+		return true
+	}
+	z := mix64(uint64(a.SampleSeed) + (index+1)*0x9e3779b97f4a7c15)
+	return float64(z>>11)/(1<<53) < a.SampleRate
+}
+
+// Returns true if cert should be left out of the analysis, because it was
+// issued before NotBeforeCutoff or had already expired at now (unless
+// IncludeExpired is set).
+func (a *Analyzer) Filters(cert *x509.Certificate, now time.Time) bool {
+	return cert.NotBefore.Before(a.NotBeforeCutoff) ||
+		(!a.IncludeExpired && cert.NotAfter.Before(now))
+}
+
+// Returns true if cert's issuer contains any of ExcludedIssuers.
+func (a *Analyzer) Excludes(cert *x509.Certificate) bool {
+	if len(a.ExcludedIssuers) == 0 {
+		return false
+	}
+	issuer := DistinguishedNameToString(cert.Issuer)
+	for _, excluded := range a.ExcludedIssuers {
+		if strings.Contains(issuer, excluded) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads a list of issuer name substrings for ExcludedIssuers, one per line.
+// Blank lines and lines starting with # are ignored.
+func ReadExcludedIssuers(r io.Reader) ([]string, error) {
+	var excluded []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		excluded = append(excluded, line)
+	}
+	return excluded, scanner.Err()
+}
+
+func (a *Analyzer) ProcessEntry(ent *certificatetransparency.EntryAndPosition, err error) {
+	if ent != nil && !a.inRange(ent.Index) {
+		atomic.AddUint64(&a.OutOfRange, 1)
+		return
+	}
+	if ent != nil && !a.sampled(ent.Index) {
+		atomic.AddUint64(&a.Unsampled, 1)
+		return
+	}
+	if a.AcceptV2 && ent != nil && IsV2Entry(ent.Raw) {
+		entry, v2Err := ParseV2Entry(ent.Raw)
+		v2Ent := *ent
+		v2Ent.Entry = entry
+		ent, err = &v2Ent, v2Err
+	}
+	if err != nil {
+		a.parseError(ent, err)
+		return
+	}
+
+	cert, certList, err := ParseEntry(ent)
+	if err != nil {
+		a.parseError(ent, err)
+		return
+	}
+
+	if a.Excludes(cert) {
+		atomic.AddUint64(&a.Excluded, 1)
+		return
+	}
+	now := a.ExpiryReference
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if a.Filters(cert, now) {
+		atomic.AddUint64(&a.Filtered, 1)
+		return
+	}
+
+	if err := a.summarize(ent, cert, certList, ent.Entry.Timestamp); err != nil {
+		a.parseError(ent, err)
+	}
+}
+
+// Processes an entry for certs that weren't read from a log, such as a cert
+// file named on the command line, which has no index or log time. Whoever
+// named the cert wants it analyzed, so it isn't subject to MinIndex and
+// MaxIndex, sampling, or the NotBefore and expiry filters, and its issuer's
+// reputation is that of the month it was issued in. Unlike ProcessEntry, it
+// returns an error if the cert can't be summarized or its issuer is excluded,
+// as well as counting it.
+func (a *Analyzer) ProcessUnlogged(ent *certificatetransparency.EntryAndPosition) error {
+	cert, certList, err := ParseEntry(ent)
+	if err != nil {
+		a.parseError(ent, err)
+		return err
+	}
+	if a.Excludes(cert) {
+		atomic.AddUint64(&a.Excluded, 1)
+		return fmt.Errorf("issuer %s is excluded",
+			DistinguishedNameToString(cert.Issuer))
+	}
+	issued := uint64(0)
+	if cert.NotBefore.Unix() > 0 {
+		issued = uint64(cert.NotBefore.Unix()) * 1000
+	}
+	if err := a.summarize(ent, cert, certList, issued); err != nil {
+		a.parseError(ent, err)
+		return err
+	}
+	return nil
+}
+
+// Summarizes cert, parsed from ent along with its chain, and records it. Its
+// issuer's reputation and examples are those of the month containing seen.
+func (a *Analyzer) summarize(ent *certificatetransparency.EntryAndPosition,
+	cert *x509.Certificate, certList []*x509.Certificate, seen uint64) error {
+	precert := ent.Entry.Type == certificatetransparency.PreCertEntry
+	summary, err := CalculateCertSummary(cert, ent.Index, ent.Entry.Timestamp,
+		precert, a.ranker, certList, a.rootCAMap, a.config)
+	if err != nil {
+		return err
+	}
+	if len(a.RootPrograms) > 0 {
+		summary.RootPrograms = RootProgramMembership(certList, a.RootPrograms)
+	}
+	atomic.AddUint64(&a.Summarized, 1)
+	if !cert.IsCA {
+		a.Validity.Add(cert)
+		if a.TrackKeyReuse {
+			a.trackKey(cert)
+		}
+	}
+	certIssuerDN := DistinguishedNameToString(cert.Issuer)
+	key := reputationKey(certIssuerDN, summary.IssuerSha256Fingerprint, seen)
+	a.issuersLock.Lock()
+	if a.evicted[key] {
+		atomic.AddUint64(&a.EvictedUpdates, 1)
+	} else {
+		if a.Issuers[key] == nil {
+			a.Issuers[key] = NewIssuerReputation(cert.Issuer, seen)
+			a.Issuers[key].IssuerSha256Fingerprint = summary.IssuerSha256Fingerprint
+		}
+		// Update issuer reputation whether or not the cert violates baseline
+		// requirements.
+		a.Issuers[key].Update(summary)
+	}
+	a.issuersLock.Unlock()
+	if summary.ViolatesBR() {
+		if a.sink != nil {
+			if err := a.sink.Write(summary, cert); err != nil {
+				atomic.AddUint64(&a.WriteErrors, 1)
+				a.Log.Errorf("Failed to record entry %d: %s", ent.Index, err)
+			}
+		}
+
+		a.exampleMapLock.Lock()
+		if a.ExampleMap[certIssuerDN] == nil {
+			a.ExampleMap[certIssuerDN] = make(map[string]*x509.Certificate)
+			a.ExampleMapLastSeen[certIssuerDN] = make(map[string]uint64)
+		}
+		for violation, isViolation := range summary.Violations {
+			if isViolation && a.newerExample(certIssuerDN, violation, cert, seen) {
+				a.ExampleMap[certIssuerDN][violation] = cert
+				a.ExampleMapLastSeen[certIssuerDN][violation] = seen
+			}
+		}
+		a.exampleMapLock.Unlock()
+	}
+
+	if a.MaxIssuers > 0 {
+		a.issuersLock.Lock()
+		a.evictIssuers()
+		a.issuersLock.Unlock()
+	}
+	return nil
+}
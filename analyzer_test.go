@@ -0,0 +1,751 @@
+package sunlight
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/monicachew/certificatetransparency"
+	"math/big"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzerCountsMalformedEntries(t *testing.T) {
+	now := time.Now()
+	ts := uint64(now.Unix()) * 1000
+	good := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "good.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(1, 0, 0),
+		DNSNames:  []string{"good.example.com"},
+	})
+	expired := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "expired.example.com"},
+		NotBefore: now.AddDate(-2, 0, 0),
+		NotAfter:  now.AddDate(-1, 0, 0),
+		DNSNames:  []string{"expired.example.com"},
+	})
+	entries := []*certificatetransparency.EntryAndPosition{
+		{Index: 0, Entry: &certificatetransparency.Entry{Timestamp: ts, X509Cert: good.Raw}},
+		{Index: 1, Entry: &certificatetransparency.Entry{Timestamp: ts, X509Cert: []byte("corrupt")}},
+		{Index: 2, Entry: &certificatetransparency.Entry{Timestamp: ts, X509Cert: expired.Raw}},
+	}
+
+	var errorLog bytes.Buffer
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	analyzer.ErrorLog = &errorLog
+	for _, ent := range entries {
+		analyzer.ProcessEntry(ent, nil)
+	}
+	analyzer.ProcessEntry(nil, errors.New("truncated entry"))
+
+	if analyzer.Summarized != 1 {
+		t.Errorf("Expected 1 summarized entry, got %d", analyzer.Summarized)
+	}
+	if analyzer.ParseErrors != 2 {
+		t.Errorf("Expected 2 parse errors, got %d", analyzer.ParseErrors)
+	}
+	if analyzer.Filtered != 1 {
+		t.Errorf("Expected 1 filtered entry, got %d", analyzer.Filtered)
+	}
+	lines := strings.Split(strings.TrimSpace(errorLog.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "1\t") ||
+		!strings.HasPrefix(lines[1], "unknown\ttruncated entry") {
+		t.Errorf("Unexpected error log:\n%s", errorLog.String())
+	}
+}
+
+func TestAnalyzerLogsWriteErrors(t *testing.T) {
+	now := time.Now()
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "long.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(6, 0, 0),
+		DNSNames:  []string{"long.example.com"},
+	})
+	var log bytes.Buffer
+	analyzer := NewAnalyzer(nil, nil, nil,
+		SinkFunc(func(summary *CertSummary, cert *x509.Certificate) error {
+			return errors.New("disk full")
+		}))
+	analyzer.Log = NewLogger(&log, LOG_INFO)
+	analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+		Index: 5,
+		Entry: &certificatetransparency.Entry{
+			Timestamp: uint64(now.Unix()) * 1000,
+			X509Cert:  cert.Raw,
+		},
+	}, nil)
+
+	if analyzer.Summarized != 1 || analyzer.WriteErrors != 1 {
+		t.Errorf("Expected 1 summarized entry and 1 write error, got %d and %d",
+			analyzer.Summarized, analyzer.WriteErrors)
+	}
+	if !strings.Contains(log.String(), "ERROR: Failed to record entry 5: disk full") {
+		t.Errorf("Unexpected log:\n%s", log.String())
+	}
+}
+
+func TestAnalyzerKeepsNewestExample(t *testing.T) {
+	now := time.Now()
+	// The certs are self-signed, so they need the same subject to share an
+	// issuer.
+	var certs []*x509.Certificate
+	for _, name := range []string{"old.example.com", "new.example.com"} {
+		certs = append(certs, makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "example.com"},
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.AddDate(6, 0, 0),
+			DNSNames:  []string{name},
+		}))
+	}
+	ts := uint64(now.Unix()) * 1000
+	older := &certificatetransparency.EntryAndPosition{
+		Index: 0,
+		Entry: &certificatetransparency.Entry{Timestamp: ts - 1000, X509Cert: certs[0].Raw},
+	}
+	newer := &certificatetransparency.EntryAndPosition{
+		Index: 1,
+		Entry: &certificatetransparency.Entry{Timestamp: ts, X509Cert: certs[1].Raw},
+	}
+
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	// Process the newer entry first, as can happen when entries are handled
+	// concurrently.
+	analyzer.ProcessEntry(newer, nil)
+	analyzer.ProcessEntry(older, nil)
+
+	issuer := DistinguishedNameToString(certs[0].Issuer)
+	example := analyzer.ExampleMap[issuer][VALID_PERIOD_TOO_LONG]
+	if example == nil || example.DNSNames[0] != "new.example.com" {
+		t.Errorf("Expected the newer cert to be the example, got %v", example)
+	}
+	if analyzer.ExampleMapLastSeen[issuer][VALID_PERIOD_TOO_LONG] != ts {
+		t.Errorf("Expected the example to be last seen at %d", ts)
+	}
+}
+
+func TestAnalyzerStopsWhenCancelled(t *testing.T) {
+	now := time.Now()
+	ts := uint64(now.Unix()) * 1000
+	var entries []*certificatetransparency.EntryAndPosition
+	for i := 0; i < 4; i++ {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "long.example.com"},
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.AddDate(6, 0, 0),
+			DNSNames:  []string{"long.example.com"},
+		})
+		entries = append(entries, &certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{Timestamp: ts, X509Cert: cert.Raw},
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorded := 0
+	analyzer := NewAnalyzer(nil, nil, nil,
+		SinkFunc(func(summary *CertSummary, cert *x509.Certificate) error {
+			recorded++
+			if recorded == 2 {
+				cancel()
+			}
+			return nil
+		}))
+	callback := analyzer.EntryCallback(ctx)
+	for _, ent := range entries {
+		callback(ent, nil)
+	}
+
+	if recorded != 2 || analyzer.Summarized != 2 {
+		t.Errorf("Expected 2 entries to be processed, got %d", analyzer.Summarized)
+	}
+	if analyzer.Skipped != 2 || analyzer.FirstSkipped != 2 {
+		t.Errorf("Expected to skip 2 entries from index 2, got %d from %d",
+			analyzer.Skipped, analyzer.FirstSkipped)
+	}
+	// What was processed before the cancellation is kept.
+	if len(analyzer.Issuers) != 1 {
+		t.Fatalf("Expected 1 issuer, got %d", len(analyzer.Issuers))
+	}
+	for _, issuer := range analyzer.Issuers {
+		if issuer.RawCount != 2 {
+			t.Errorf("Expected the issuer to have 2 certs, got %d", issuer.RawCount)
+		}
+	}
+}
+
+func TestAnalyzerSeparatesIssuerKeys(t *testing.T) {
+	now := time.Now()
+	ts := uint64(now.Unix()) * 1000
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	// Two CA certs with the same name, as when a CA rotates its key.
+	for i := int64(1); i <= 2; i++ {
+		ca := makeCert(t, &x509.Certificate{
+			SerialNumber:          big.NewInt(i),
+			Subject:               pkix.Name{CommonName: "Rotating CA"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.AddDate(5, 0, 0),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		})
+		leaf := issueCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "leaf.example.com"},
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.AddDate(1, 0, 0),
+			DNSNames:  []string{"leaf.example.com"},
+		}, ca, &testKey.PublicKey)
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{
+				Timestamp:  ts,
+				X509Cert:   leaf.Raw,
+				ExtraCerts: [][]byte{ca.Raw},
+			},
+		}, nil)
+	}
+
+	if len(analyzer.Issuers) != 2 {
+		t.Fatalf("Expected 2 issuer reputations, got %d", len(analyzer.Issuers))
+	}
+	fingerprints := make(map[string]bool)
+	for _, issuer := range analyzer.Issuers {
+		if issuer.Issuer != "CN=Rotating CA" || issuer.RawCount != 1 {
+			t.Errorf("Unexpected issuer reputation %s with %d certs",
+				issuer.Issuer, issuer.RawCount)
+		}
+		fingerprints[issuer.IssuerSha256Fingerprint] = true
+	}
+	if len(fingerprints) != 2 || fingerprints[""] {
+		t.Errorf("Expected 2 different issuer fingerprints, got %v", fingerprints)
+	}
+}
+
+func TestAnalyzerRecordsRootPrograms(t *testing.T) {
+	now := time.Now()
+	ca := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Program Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	})
+	leaf := issueCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "leaf.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(6, 0, 0),
+		DNSNames:  []string{"leaf.example.com"},
+	}, ca, &testKey.PublicKey)
+	sink := &memorySink{}
+	analyzer := NewAnalyzer(nil, nil, nil, sink)
+	analyzer.RootPrograms = map[string]map[string]bool{
+		"Mozilla": {"CN=Program Root": true},
+		"Apple":   {"CN=Other Root": true},
+	}
+	analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+		Entry: &certificatetransparency.Entry{
+			Timestamp:  uint64(now.Unix()) * 1000,
+			X509Cert:   leaf.Raw,
+			ExtraCerts: [][]byte{ca.Raw},
+		},
+	}, nil)
+
+	expected := map[string]bool{"Mozilla": true, "Apple": false}
+	if len(sink.summaries) != 1 ||
+		!reflect.DeepEqual(sink.summaries[0].RootPrograms, expected) {
+		t.Fatalf("Expected root programs %v, got %v", expected, sink.summaries)
+	}
+	for _, issuer := range analyzer.Issuers {
+		if !reflect.DeepEqual(issuer.RootPrograms, expected) {
+			t.Errorf("Expected issuer root programs %v, got %v", expected,
+				issuer.RootPrograms)
+		}
+	}
+}
+
+func TestAnalyzerReportsKeyReuse(t *testing.T) {
+	now := time.Now()
+	ts := uint64(now.Unix()) * 1000
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	analyzer.TrackKeyReuse = true
+	for i, test := range []struct {
+		ca   string
+		name string
+		pub  interface{}
+	}{
+		{"First CA", "shared.example.com", &testKey.PublicKey},
+		{"Second CA", "unrelated.example.org", &testKey.PublicKey},
+		{"Second CA", "own.example.net", &otherKey.PublicKey},
+	} {
+		ca := makeCert(t, &x509.Certificate{
+			Subject:               pkix.Name{CommonName: test.ca},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.AddDate(5, 0, 0),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		})
+		leaf := issueCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: test.name},
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.AddDate(1, 0, 0),
+			DNSNames:  []string{test.name},
+		}, ca, test.pub)
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{Timestamp: ts, X509Cert: leaf.Raw},
+		}, nil)
+	}
+
+	reused := analyzer.KeyReuse(1)
+	if len(reused) != 1 {
+		t.Fatalf("Expected 1 reused key, got %d", len(reused))
+	}
+	if !reflect.DeepEqual(reused[0].Issuers, []string{"CN=First CA", "CN=Second CA"}) ||
+		!reflect.DeepEqual(reused[0].Subjects,
+			[]string{"CN=shared.example.com", "CN=unrelated.example.org"}) {
+		t.Errorf("Unexpected reused key %+v", reused[0])
+	}
+	if len(analyzer.KeyReuse(2)) != 0 {
+		t.Error("No key was used under more than 2 issuers")
+	}
+}
+
+func TestAnalyzerFilters(t *testing.T) {
+	now := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	certs := map[string]*x509.Certificate{
+		"old": {
+			NotBefore: time.Date(2012, 6, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:  time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+		"expired": {
+			NotBefore: time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:  time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+		"current": {
+			NotBefore: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:  time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range []struct {
+		cutoff         time.Time
+		includeExpired bool
+		filtered       map[string]bool
+	}{
+		{DefaultNotBeforeCutoff, false,
+			map[string]bool{"old": true, "expired": true, "current": false}},
+		{time.Time{}, false,
+			map[string]bool{"old": false, "expired": true, "current": false}},
+		{DefaultNotBeforeCutoff, true,
+			map[string]bool{"old": true, "expired": false, "current": false}},
+		{time.Time{}, true,
+			map[string]bool{"old": false, "expired": false, "current": false}},
+		{time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), true,
+			map[string]bool{"old": true, "expired": true, "current": false}},
+	} {
+		analyzer := NewAnalyzer(nil, nil, nil, nil)
+		analyzer.NotBeforeCutoff = test.cutoff
+		analyzer.IncludeExpired = test.includeExpired
+		for name, cert := range certs {
+			if analyzer.Filters(cert, now) != test.filtered[name] {
+				t.Errorf("Cutoff %s, include expired %t: expected %s cert filtered %t",
+					test.cutoff, test.includeExpired, name, test.filtered[name])
+			}
+		}
+	}
+}
+
+// Whether a cert had expired is judged at ExpiryReference if it's set, so a
+// run's output doesn't depend on when it's made.
+func TestAnalyzerExpiryReference(t *testing.T) {
+	notBefore := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "expiring.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"expiring.example.com"},
+	})
+	for _, test := range []struct {
+		reference time.Time
+		filtered  bool
+	}{
+		{time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC), true},
+		// The cert has expired by now.
+		{time.Time{}, true},
+	} {
+		analyzer := NewAnalyzer(nil, nil, nil, nil)
+		analyzer.ExpiryReference = test.reference
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(notBefore.Unix()) * 1000,
+				X509Cert:  cert.Raw,
+			},
+		}, nil)
+		if (analyzer.Filtered == 1) != test.filtered ||
+			(analyzer.Summarized == 1) == test.filtered {
+			t.Errorf("Reference %s: expected filtered %t, got %d filtered and %d "+
+				"summarized", test.reference, test.filtered, analyzer.Filtered,
+				analyzer.Summarized)
+		}
+	}
+}
+
+// A cert that wasn't logged is analyzed whatever the index range, sample and
+// filters, in the month it was issued.
+func TestAnalyzerProcessesUnloggedCerts(t *testing.T) {
+	notBefore := time.Date(2012, 6, 15, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "unlogged.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"unlogged.example.com"},
+	})
+	ent := &certificatetransparency.EntryAndPosition{
+		Entry: &certificatetransparency.Entry{X509Cert: cert.Raw},
+	}
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	// The cert was issued before the default cutoff and has expired.
+	analyzer.MinIndex = 10
+	analyzer.SampleRate = 1e-9
+	if err := analyzer.ProcessUnlogged(ent); err != nil {
+		t.Fatal("could not process unlogged cert", err)
+	}
+	if analyzer.Summarized != 1 || len(analyzer.Issuers) != 1 {
+		t.Fatalf("Expected the cert to be summarized, got %d summarized and %d "+
+			"filtered", analyzer.Summarized, analyzer.Filtered)
+	}
+	for _, issuer := range analyzer.Issuers {
+		if issuer.BeginTime != TruncateMonth(uint64(notBefore.Unix())*1000) {
+			t.Errorf("Expected the reputation for June 2012, got %s",
+				TimestampToTime(issuer.BeginTime))
+		}
+	}
+
+	analyzer.ExcludedIssuers = []string{"unlogged.example.com"}
+	if err := analyzer.ProcessUnlogged(ent); err == nil || analyzer.Excluded != 1 {
+		t.Errorf("Expected an error for an excluded issuer (%v)", err)
+	}
+	garbled := &certificatetransparency.EntryAndPosition{
+		Entry: &certificatetransparency.Entry{X509Cert: []byte("not a cert")},
+	}
+	if err := analyzer.ProcessUnlogged(garbled); err == nil ||
+		analyzer.ParseErrors != 1 {
+		t.Errorf("Expected an error for a cert that can't be parsed (%v)", err)
+	}
+}
+
+func TestAnalyzerExcludesIssuers(t *testing.T) {
+	now := time.Now()
+	ts := uint64(now.Unix()) * 1000
+	staging := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Fake LE Intermediate X1"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(6, 0, 0),
+		DNSNames:  []string{"staging.example.com"},
+	})
+	production := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Production CA"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(6, 0, 0),
+		DNSNames:  []string{"production.example.com"},
+	})
+
+	excluded, err := ReadExcludedIssuers(strings.NewReader(
+		"# Let's Encrypt staging\n\nFake LE Intermediate\n"))
+	if err != nil || len(excluded) != 1 {
+		t.Fatalf("Unexpected excluded issuers %q, %v", excluded, err)
+	}
+	var written []*CertSummary
+	analyzer := NewAnalyzer(nil, nil, nil,
+		SinkFunc(func(summary *CertSummary, cert *x509.Certificate) error {
+			written = append(written, summary)
+			return nil
+		}))
+	analyzer.ExcludedIssuers = excluded
+	for i, cert := range []*x509.Certificate{staging, production} {
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{Timestamp: ts, X509Cert: cert.Raw},
+		}, nil)
+	}
+
+	if analyzer.Excluded != 1 || analyzer.Summarized != 1 {
+		t.Errorf("Expected 1 excluded and 1 summarized entry, got %d and %d",
+			analyzer.Excluded, analyzer.Summarized)
+	}
+	if len(written) != 1 || written[0].CN != "Production CA" {
+		t.Errorf("Expected only the production cert to be written, got %v", written)
+	}
+	for _, issuer := range analyzer.Issuers {
+		if strings.Contains(issuer.Issuer, "Fake LE") {
+			t.Errorf("Excluded issuer %s has a reputation", issuer.Issuer)
+		}
+	}
+}
+
+func TestAnalyzerEvictsIssuers(t *testing.T) {
+	notBefore := time.Date(2014, time.January, 1, 0, 0, 0, 0, time.UTC)
+	// Each cert is self-signed, so has an issuer of its own, and violates
+	// VALID_PERIOD_TOO_LONG so that its issuer has an example.
+	var entries []*certificatetransparency.EntryAndPosition
+	for month := 0; month < 12; month++ {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: fmt.Sprintf("CA %d", month)},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(6, 0, 0),
+			DNSNames:  []string{"example.com"},
+		})
+		logged := notBefore.AddDate(0, month, 0)
+		entries = append(entries, &certificatetransparency.EntryAndPosition{
+			Index: uint64(month),
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(logged.Unix()) * 1000,
+				X509Cert:  cert.Raw,
+			},
+		})
+	}
+
+	var evicted []*IssuerReputation
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	analyzer.IncludeExpired = true
+	analyzer.MaxIssuers = 10
+	analyzer.Evict = func(issuer *IssuerReputation,
+		examples map[string]*x509.Certificate, lastSeen map[string]uint64) {
+		if examples[VALID_PERIOD_TOO_LONG] == nil {
+			t.Errorf("Expected the examples for %s", issuer.Issuer)
+		}
+		evicted = append(evicted, issuer)
+	}
+	for _, ent := range entries {
+		analyzer.ProcessEntry(ent, nil)
+	}
+	// Going over the cap evicts the oldest tenth, leaving 9.
+	if len(analyzer.Issuers) != 10 || len(evicted) != 2 ||
+		evicted[0].Issuer != "CN=CA 0" || evicted[1].Issuer != "CN=CA 1" {
+		t.Fatalf("Expected the two oldest of 12 issuers to be evicted, kept %d "+
+			"and evicted %v", len(analyzer.Issuers), evicted)
+	}
+	if analyzer.ExampleMap["CN=CA 0"] != nil {
+		t.Error("Expected the evicted issuer's examples to be forgotten")
+	}
+
+	// An evicted reputation isn't reopened.
+	analyzer.ProcessEntry(entries[0], nil)
+	if analyzer.EvictedUpdates != 1 || len(evicted) != 2 || evicted[0].RawCount != 1 {
+		t.Errorf("Expected an update too late for an evicted issuer, got %d",
+			analyzer.EvictedUpdates)
+	}
+}
+
+func TestAnalyzerSamplesEntries(t *testing.T) {
+	now := time.Now()
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "sampled.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(1, 0, 0),
+		DNSNames:  []string{"sampled.example.com"},
+	})
+	const total = 20000
+	run := func() *Analyzer {
+		analyzer := NewAnalyzer(nil, nil, nil, nil)
+		analyzer.SampleRate = 0.0001
+		analyzer.SampleSeed = 42
+		for i := uint64(0); i < total; i++ {
+			analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+				Index: i,
+				Entry: &certificatetransparency.Entry{
+					Timestamp: uint64(now.Unix()) * 1000,
+					X509Cert:  cert.Raw,
+				},
+			}, nil)
+		}
+		return analyzer
+	}
+
+	first := run()
+	if first.Summarized+first.Unsampled != total {
+		t.Errorf("Expected %d entries to be counted, got %d summarized and %d unsampled",
+			total, first.Summarized, first.Unsampled)
+	}
+	if first.Summarized == 0 || first.Summarized > 20 {
+		t.Errorf("Expected a handful of entries in the sample, got %d", first.Summarized)
+	}
+	if second := run(); second.Summarized != first.Summarized {
+		t.Errorf("Expected the same seed to give the same sample, got %d and %d",
+			first.Summarized, second.Summarized)
+	}
+}
+
+func TestAnalyzerProcessesIndexRange(t *testing.T) {
+	now := time.Now()
+	// Valid for too long, so each processed entry reaches the sink.
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "range.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(6, 0, 0),
+		DNSNames:  []string{"range.example.com"},
+	})
+	sink := &memorySink{}
+	analyzer := NewAnalyzer(nil, nil, nil, sink)
+	analyzer.MinIndex = 3
+	analyzer.MaxIndex = 7
+	for i := uint64(0); i < 10; i++ {
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: i,
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(now.Unix()) * 1000,
+				X509Cert:  cert.Raw,
+			},
+		}, nil)
+	}
+	if analyzer.Summarized != 4 || analyzer.OutOfRange != 6 {
+		t.Errorf("Expected 4 entries summarized and 6 out of range, got %d and %d",
+			analyzer.Summarized, analyzer.OutOfRange)
+	}
+	var indices []uint64
+	for _, summary := range sink.summaries {
+		indices = append(indices, summary.LogIndex)
+	}
+	if !reflect.DeepEqual(indices, []uint64{3, 4, 5, 6}) {
+		t.Errorf("Expected only entries 3 to 6 to be processed, got %v", indices)
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	now := time.Now()
+	leaf := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "leaf.example.com"},
+		NotBefore: now,
+		NotAfter:  now.AddDate(1, 0, 0),
+		DNSNames:  []string{"leaf.example.com"},
+	})
+	intermediate := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	})
+	ent := &certificatetransparency.EntryAndPosition{
+		Entry: &certificatetransparency.Entry{
+			X509Cert:   leaf.Raw,
+			ExtraCerts: [][]byte{intermediate.Raw, []byte("corrupt")},
+		},
+	}
+
+	parsedLeaf, chain, err := ParseEntry(ent)
+	if err != nil {
+		t.Fatal("could not parse entry", err)
+	}
+	if !bytes.Equal(parsedLeaf.Raw, leaf.Raw) {
+		t.Error("Parsed leaf doesn't match the logged cert")
+	}
+	if len(chain) != 1 || !bytes.Equal(chain[0].Raw, intermediate.Raw) {
+		t.Errorf("Expected the chain to hold just the intermediate, got %d certs",
+			len(chain))
+	}
+
+	ent.Entry.X509Cert = []byte("corrupt")
+	if _, _, err := ParseEntry(ent); err == nil {
+		t.Error("Expected an error for a corrupt leaf cert")
+	}
+}
+
+// Feeds entries logging the corpus certs with the root through an Analyzer,
+// cycling through the corpus and a few timestamps so that several issuer
+// reputations are updated.
+func BenchmarkAnalyzerThroughput(b *testing.B) {
+	certs := readCorpus(b)
+	root := certs[0]
+	months := []time.Time{
+		time.Date(2014, time.June, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2014, time.July, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2014, time.August, 1, 0, 0, 0, 0, time.UTC),
+	}
+	entries := make([]*certificatetransparency.EntryAndPosition, 0,
+		len(certs)*len(months))
+	for _, month := range months {
+		for _, cert := range certs {
+			entries = append(entries, &certificatetransparency.EntryAndPosition{
+				Entry: &certificatetransparency.Entry{
+					Timestamp:  uint64(month.Unix()) * 1000,
+					X509Cert:   cert.Raw,
+					ExtraCerts: [][]byte{root.Raw},
+				},
+			})
+		}
+	}
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	analyzer.IncludeExpired = true
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ent := *entries[i%len(entries)]
+		ent.Index = uint64(i)
+		analyzer.ProcessEntry(&ent, nil)
+	}
+	b.StopTimer()
+	if analyzer.Summarized != uint64(b.N) {
+		b.Errorf("Expected %d summarized certs, got %d", b.N, analyzer.Summarized)
+	}
+}
+
+// Returns how much the live heap grew while an Analyzer processed count
+// entries logging the same cert.
+func heapGrowth(t *testing.T, cert *x509.Certificate, count int) int64 {
+	logged := uint64(cert.NotBefore.Unix()) * 1000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	analyzer.IncludeExpired = true
+	for i := 0; i < count; i++ {
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{
+				Timestamp: logged,
+				X509Cert:  cert.Raw,
+			},
+		}, nil)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if analyzer.Summarized != uint64(count) {
+		t.Fatalf("Expected %d summarized certs, got %d", count, analyzer.Summarized)
+	}
+	runtime.KeepAlive(analyzer)
+	return int64(after.HeapAlloc) - int64(before.HeapAlloc)
+}
+
+// Nothing about an entry outlives processing it, beyond what's counted in
+// reputations and the newest examples, so memory doesn't grow with the number
+// of entries.
+func TestAnalyzerMemoryIsBounded(t *testing.T) {
+	notBefore := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "memory.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(6, 0, 0),
+		DNSNames:  []string{"memory.example.com"},
+	})
+	few := heapGrowth(t, cert, 100)
+	many := heapGrowth(t, cert, 5000)
+	// Holding on to each parsed cert would take several MB.
+	if many-few > 512*1024 {
+		t.Errorf("Expected memory not to grow with entries, but 100 took %d "+
+			"bytes and 5000 took %d", few, many)
+	}
+}
@@ -0,0 +1,47 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// Returns cert PEM-encoded as a CERTIFICATE block, or an empty string if cert
+// is nil.
+func CertToPEM(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// Parses the certs in a file, which may be PEM (any number of CERTIFICATE
+// blocks, ignoring other blocks and text between them) or DER (any number of
+// certs concatenated). Input without any PEM blocks is taken to be DER.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		if bytes.Equal(rest, data) {
+			return x509.ParseCertificates(data)
+		}
+		return nil, errors.New("no CERTIFICATE blocks in PEM input")
+	}
+	return certs, nil
+}
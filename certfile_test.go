@@ -0,0 +1,91 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestParseCertificates(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	var certs []*x509.Certificate
+	for _, name := range []string{"leaf.example.com", "Example CA"} {
+		certs = append(certs, makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: name},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(6, 0, 0),
+			DNSNames:  []string{"leaf.example.com"},
+		}))
+	}
+	pemBytes := []byte("leaf:\n")
+	pemBytes = append(pemBytes, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: certs[0].Raw})...)
+	pemBytes = append(pemBytes, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: certs[1].Raw})...)
+	derBytes := append(append([]byte{}, certs[0].Raw...), certs[1].Raw...)
+
+	var summaries [][]byte
+	for _, input := range [][]byte{pemBytes, certs[0].Raw, derBytes} {
+		parsed, err := ParseCertificates(input)
+		if err != nil || len(parsed) == 0 {
+			t.Fatalf("Failed to parse certs: %v", err)
+		}
+		summary, err := CalculateCertSummary(parsed[0], 0, 0, false, nil,
+			nil, nil, nil)
+		if err != nil {
+			t.Fatal("could not summarize cert", err)
+		}
+		marshalled, _ := json.Marshal(summary)
+		summaries = append(summaries, marshalled)
+	}
+	for _, summary := range summaries[1:] {
+		if string(summary) != string(summaries[0]) {
+			t.Errorf("Summaries differ:\n%s\n%s", summaries[0], summary)
+		}
+	}
+
+	for name, input := range map[string][]byte{"PEM": pemBytes, "DER": derBytes} {
+		parsed, _ := ParseCertificates(input)
+		if len(parsed) != 2 || parsed[1].Subject.CommonName != "Example CA" {
+			t.Errorf("%s: expected the leaf and its issuer, got %d certs",
+				name, len(parsed))
+		}
+	}
+
+	keyOnly := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte{0}})
+	if _, err := ParseCertificates(keyOnly); err == nil {
+		t.Error("Expected an error for PEM without certs")
+	}
+	if _, err := ParseCertificates([]byte("garbage")); err == nil {
+		t.Error("Expected an error for input that's neither PEM nor DER")
+	}
+}
+
+func TestCertToPEM(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "pem.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"pem.example.com"},
+	})
+	var expected bytes.Buffer
+	if err := pem.Encode(&expected, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+		t.Fatal("could not encode cert", err)
+	}
+	encoded := CertToPEM(cert)
+	if encoded != expected.String() {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected.String(), encoded)
+	}
+	parsed, err := ParseCertificates([]byte(encoded))
+	if err != nil || len(parsed) != 1 || !parsed[0].Equal(cert) {
+		t.Errorf("PEM doesn't parse to the cert: %v", err)
+	}
+	if CertToPEM(nil) != "" {
+		t.Error("Expected no PEM for a nil cert")
+	}
+}
@@ -0,0 +1,393 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// A Check looks for a violation in a cert, given the chain it was logged with
+// (which starts with its issuer, and may be empty). config is never nil.
+// Checks are run concurrently, so Evaluate must be safe for concurrent use.
+type Check interface {
+	// The name the violation is recorded under in CertSummary.Violations,
+	// and can be enabled by in RuleConfig.Checks.
+	Name() string
+	// Returns true if cert has the violation.
+	Evaluate(cert *x509.Certificate, chain []*x509.Certificate,
+		config *RuleConfig) bool
+}
+
+type funcCheck struct {
+	name     string
+	evaluate func(cert *x509.Certificate, chain []*x509.Certificate,
+		config *RuleConfig) bool
+}
+
+// Returns a Check with the given name that calls evaluate.
+func NewCheck(name string, evaluate func(cert *x509.Certificate,
+	chain []*x509.Certificate, config *RuleConfig) bool) Check {
+	return &funcCheck{name, evaluate}
+}
+
+func (c *funcCheck) Name() string { return c.name }
+
+func (c *funcCheck) Evaluate(cert *x509.Certificate, chain []*x509.Certificate,
+	config *RuleConfig) bool {
+	return c.evaluate(cert, chain, config)
+}
+
+var checksLock sync.RWMutex
+
+// The checks CalculateCertSummary runs, in order. Built-in checks that need
+// more than the cert and its chain, such as when the cert was logged, are run
+// by CalculateCertSummary itself.
+var registeredChecks = builtinChecks()
+
+// The names of the checks added by RegisterCheck, in the order they were
+// added.
+var extraViolationNames []string
+
+// Adds a check for CalculateCertSummary to run on every cert, for violations
+// this package doesn't know about. It's meant to be called from an init
+// function, and panics if a violation with the same name already exists.
+func RegisterCheck(check Check) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+	for _, name := range violationNamesLocked() {
+		if name == check.Name() {
+			panic(fmt.Sprintf("sunlight: check %s registered twice", name))
+		}
+	}
+	registeredChecks = append(registeredChecks, check)
+	extraViolationNames = append(extraViolationNames, check.Name())
+}
+
+// Returns the registered checks.
+func checks() []Check {
+	checksLock.RLock()
+	defer checksLock.RUnlock()
+	return registeredChecks
+}
+
+// Returns the names of the built-in violations followed by those of any
+// registered checks.
+func violationNames() []string {
+	checksLock.RLock()
+	defer checksLock.RUnlock()
+	return violationNamesLocked()
+}
+
+func violationNamesLocked() []string {
+	if len(extraViolationNames) == 0 {
+		return ViolationNames
+	}
+	names := make([]string, 0, len(ViolationNames)+len(extraViolationNames))
+	return append(append(names, ViolationNames...), extraViolationNames...)
+}
+
+func builtinChecks() []Check {
+	return []Check{
+		// Version is 1 or 2 for these, so a summary tells them apart.
+		NewCheck(DEPRECATED_VERSION, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return cert.Version != 3
+		}),
+
+		// RFC 5280 section 4.1.2.9: only v3 certs may have extensions. A v1
+		// or v2 cert with them is malformed, and its extensions (like any
+		// SANs or basic constraints) are ignored by clients that parse by
+		// version.
+		NewCheck(EXTENSIONS_BEFORE_V3, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return cert.Version < 3 && hasExtensionsField(cert)
+		}),
+
+		// BR 9.4.1: Validity period is longer than 5 years.  This
+		// should be restricted to certs that don't have CA:True
+		NewCheck(VALID_PERIOD_TOO_LONG, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return cert.NotAfter.After(cert.NotBefore.AddDate(5, 0, 7)) &&
+				(!cert.BasicConstraintsValid ||
+					(cert.BasicConstraintsValid && !cert.IsCA))
+		}),
+
+		// Leaf certs must have a subjectAltName with at least one DNS name or
+		// IP address.
+		NewCheck(NO_SAN_EXTENSION, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return !cert.IsCA && len(cert.DNSNames) == 0 &&
+				len(cert.IPAddresses) == 0
+		}),
+
+		// SignatureAlgorithm is SHA1
+		NewCheck(DEPRECATED_SIGNATURE_ALGORITHM, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return isSHA1(cert.SignatureAlgorithm)
+		}),
+
+		// MD5 collisions have been used to forge a CA cert, so these count
+		// for more than SHA-1 ones.
+		NewCheck(BROKEN_SIGNATURE_ALGORITHM, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return isMD(cert.SignatureAlgorithm)
+		}),
+
+		// RFC 5280 section 4.1.1.2: the outer signatureAlgorithm must be the
+		// same as the TBSCertificate's signature field.
+		NewCheck(ALGORITHM_MISMATCH, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return algorithmsDiffer(cert)
+		}),
+
+		// Legacy SHA-1 certs are far less serious than those issued after the
+		// sunset, so these are counted separately.
+		NewCheck(SHA1_AFTER_SUNSET, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			cutoff := config.SHA1Cutoff
+			if cutoff.IsZero() {
+				cutoff = DefaultSHA1Cutoff
+			}
+			return isSHA1(cert.SignatureAlgorithm) && cert.NotBefore.After(cutoff)
+		}),
+
+		// The AKI should identify the key of the cert that issued this one.
+		NewCheck(KEY_IDENTIFIER_MISMATCH, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return len(chain) > 0 && len(cert.AuthorityKeyId) > 0 &&
+				len(chain[0].SubjectKeyId) > 0 &&
+				!bytes.Equal(cert.AuthorityKeyId, chain[0].SubjectKeyId)
+		}),
+
+		// A cert can't be valid after any of the certs it chains to expire.
+		NewCheck(LEAF_OUTLIVES_ISSUER, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			for _, ancestor := range chain {
+				if cert.NotAfter.After(ancestor.NotAfter) {
+					return true
+				}
+			}
+			return false
+		}),
+
+		// BR 7.1.6.4: leaf certs must assert a policy identifier, such as one
+		// of the CA/B Forum's reserved DV, OV or EV policies.
+		NewCheck(MISSING_CERT_POLICY, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return !cert.IsCA && len(cert.PolicyIdentifiers) == 0
+		}),
+
+		// RFC 5280 section 4.2.1.1: only self-issued certs may leave out the
+		// AKI, which clients use to find the issuing cert when building a
+		// chain.
+		NewCheck(MISSING_AKI, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return len(cert.AuthorityKeyId) == 0 &&
+				!bytes.Equal(cert.RawSubject, cert.RawIssuer)
+		}),
+
+		// The signature algorithm isn't one we recognize, so
+		// SignatureAlgorithm is recorded as 0.
+		NewCheck(UNKNOWN_SIGNATURE_ALGORITHM, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return cert.SignatureAlgorithm == x509.UnknownSignatureAlgorithm
+		}),
+
+		NewCheck(ILLEGAL_DNS_CHARACTER, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			for _, name := range cert.DNSNames {
+				if !isLDHName(name) {
+					return true
+				}
+			}
+			return false
+		}),
+
+		NewCheck(PUBLIC_SUFFIX_SAN, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			for _, name := range cert.DNSNames {
+				if isPublicSuffix(name) {
+					return true
+				}
+			}
+			return false
+		}),
+
+		NewCheck(RESERVED_TLD, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			level := ValidationLevel(cert)
+			for _, name := range cert.DNSNames {
+				if isReservedName(name, level) {
+					return true
+				}
+			}
+			return false
+		}),
+
+		// Not against the BRs, but a wildcard alongside IP addresses is
+		// usually a misconfiguration.
+		NewCheck(MIXED_WILDCARD_AND_IP, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			if len(cert.IPAddresses) == 0 {
+				return false
+			}
+			for _, name := range cert.DNSNames {
+				if strings.HasPrefix(name, "*.") {
+					return true
+				}
+			}
+			return false
+		}),
+
+		// With neither a subject nor any SANs, nothing says who the cert is
+		// for (RFC 5280 section 4.1.2.6).
+		NewCheck(EMPTY_SUBJECT_NO_SAN, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return len(cert.Subject.Names) == 0 && len(cert.DNSNames) == 0 &&
+				len(cert.IPAddresses) == 0 && len(cert.EmailAddresses) == 0 &&
+				len(cert.URIs) == 0
+		}),
+
+		// Clients disagree on which of several CNs is the one that counts, so
+		// only the last is checked against the SAN.
+		NewCheck(MULTIPLE_CN, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return countCommonNames(cert.Subject) > 1
+		}),
+
+		NewCheck(MISSING_CN_IN_SAN, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return missingCNInSAN(cert, config)
+		}),
+
+		// BR 7.1.2.3: leaf certs should say where to check their revocation
+		// status with OCSP.
+		NewCheck(MISSING_OCSP, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return !cert.IsCA && len(cert.OCSPServer) == 0
+		}),
+
+		// Not required of leaf certs, but some policies want a CRL as well
+		// as or instead of OCSP.
+		NewCheck(MISSING_CRL, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return !cert.IsCA && len(cert.CRLDistributionPoints) == 0
+		}),
+
+		// Exponents above 3 aren't known to be weak, but almost every RSA key
+		// uses 65537, and many guidelines require it. EXP_TOO_SMALL is the
+		// hard failure.
+		NewCheck(NONSTANDARD_RSA_EXPONENT, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			key, ok := cert.PublicKey.(*rsa.PublicKey)
+			return ok && key.E != 65537
+		}),
+
+		NewCheck(NAME_CONSTRAINT_VIOLATION, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return violatesNameConstraints(cert, chain)
+		}),
+
+		NewCheck(MISPLACED_WILDCARD, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			for _, name := range cert.DNSNames {
+				if isMisplacedWildcard(name) {
+					return true
+				}
+			}
+			return false
+		}),
+
+		// BR 7.1.4.2.1: a TLS server cert's SANs may only be DNS names and
+		// IP addresses.
+		NewCheck(FORBIDDEN_SAN_TYPE, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			if cert.IsCA || !isServerAuth(cert) {
+				return false
+			}
+			return len(cert.EmailAddresses) > 0 || len(cert.URIs) > 0 ||
+				hasOtherNameSAN(cert)
+		}),
+
+		// UTCTime years 50 to 99 are 1950 to 1999, so a date from 2050 on
+		// wrongly encoded as a UTCTime ends up a century early. No CT-era cert
+		// can have been valid before 1970. The analyzer filters out expired
+		// certs unless IncludeExpired is set, which these appear to be.
+		NewCheck(MALFORMED_VALIDITY_DATES, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			return !isPlausibleYear(cert.NotBefore.Year()) ||
+				!isPlausibleYear(cert.NotAfter.Year())
+		}),
+
+		// Go leaves PublicKey nil if it doesn't know the key's algorithm. This
+		// is distinct from a valid non-RSA key, which also has no KeySize.
+		// Certs only get keys Go can verify signatures with, so X25519 keys
+		// are left nil too, though Go can parse them from the SPKI.
+		NewCheck(UNPARSEABLE_KEY, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			key := cert.PublicKey
+			if key == nil {
+				key, _ = x509.ParsePKIXPublicKey(cert.RawSubjectPublicKeyInfo)
+			}
+			switch key.(type) {
+			case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey,
+				*dsa.PublicKey:
+				return false
+			}
+			return true
+		}),
+
+		// Only RSA keys can encipher, and RSA and Ed25519 keys can't be used
+		// for key agreement, so asserting those usages for such keys makes
+		// no sense.
+		NewCheck(KEY_USAGE_MISMATCH, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			encipherment := x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment
+			switch cert.PublicKey.(type) {
+			case *rsa.PublicKey:
+				return cert.KeyUsage&x509.KeyUsageKeyAgreement != 0
+			case *ecdsa.PublicKey:
+				return cert.KeyUsage&encipherment != 0
+			case ed25519.PublicKey:
+				return cert.KeyUsage&(encipherment|x509.KeyUsageKeyAgreement) != 0
+			}
+			return false
+		}),
+
+		// A subject organizationName attribute is what makes a cert OV, so one
+		// that's blank claims the subject was validated without naming who
+		// it is (BR 7.1.4.2.2).
+		NewCheck(EMPTY_ORGANIZATION, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			if ValidationLevel(cert) == VALIDATION_DV {
+				return false
+			}
+			for _, organization := range cert.Subject.Organization {
+				if strings.TrimSpace(organization) == "" {
+					return true
+				}
+			}
+			return false
+		}),
+
+		// BR 7.1.4.2.1: an IP address must be an iPAddress SAN, not a
+		// dNSName, which clients won't match against addresses.
+		NewCheck(IP_AS_DNS_NAME, func(cert *x509.Certificate,
+			chain []*x509.Certificate, config *RuleConfig) bool {
+			for _, name := range cert.DNSNames {
+				if net.ParseIP(name) != nil {
+					return true
+				}
+			}
+			return false
+		}),
+	}
+}
@@ -0,0 +1,76 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterCheck(t *testing.T) {
+	// Registered checks stay registered, so put things back for the other
+	// tests.
+	saved, savedNames := registeredChecks, extraViolationNames
+	defer func() {
+		registeredChecks, extraViolationNames = saved, savedNames
+	}()
+
+	RegisterCheck(NewCheck("ExampleCN", func(cert *x509.Certificate,
+		chain []*x509.Certificate, config *RuleConfig) bool {
+		return strings.HasSuffix(cert.Subject.CommonName, ".example")
+	}))
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for cn, violated := range map[string]bool{
+		"www.example":     true,
+		"www.example.com": false,
+	} {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: cn},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{cn},
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		found, ok := summary.Violations["ExampleCN"]
+		if !ok || found != violated {
+			t.Errorf("%s: expected ExampleCN %t, got %v", cn, violated,
+				summary.Violations)
+		}
+		// Built-in checks still run.
+		if summary.Violations[MISSING_CN_IN_SAN] {
+			t.Errorf("%s: unexpected MissingCNInSan", cn)
+		}
+	}
+
+	// The check can be enabled by name, like the built-in ones.
+	checks, err := ParseChecks("ExampleCN")
+	if err != nil {
+		t.Fatal("could not parse registered check", err)
+	}
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "www.example"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(6, 0, 0),
+		DNSNames:  []string{"www.example"},
+	})
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil,
+		&RuleConfig{Checks: checks})
+	if len(summary.Violations) != 1 || !summary.Violations["ExampleCN"] {
+		t.Errorf("Expected only ExampleCN, got %v", summary.Violations)
+	}
+	details := summary.ViolationDetails()
+	if len(details) != 1 || details[0].Name != "ExampleCN" {
+		t.Errorf("Unexpected details %v", details)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a duplicate name to panic")
+		}
+	}()
+	RegisterCheck(NewCheck(KEY_TOO_SHORT, func(cert *x509.Certificate,
+		chain []*x509.Certificate, config *RuleConfig) bool {
+		return false
+	}))
+}
@@ -0,0 +1,121 @@
+package sunlight
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// An issuer's normalized score in two runs, as compared by CompareIssuers.
+// Over all the months an issuer was seen in, its normalized score is the
+// average of its monthly ones, weighted by their NormalizedCounts. Before is
+// NaN if the issuer wasn't in the first run, or had no certs for domains in
+// Alexa in it, and After likewise for the second run.
+type IssuerScoreChange struct {
+	Issuer                  string
+	IssuerSha256Fingerprint string
+	Before                  float32
+	After                   float32
+}
+
+func (change IssuerScoreChange) MarshalJSON() ([]byte, error) {
+	type plainChange IssuerScoreChange
+	return json.Marshal(struct {
+		plainChange
+		Before *float32
+		After  *float32
+	}{plainChange(change), finiteScore(change.Before),
+		finiteScore(change.After)})
+}
+
+// The differences between two runs' issuer reputations.
+type IssuerComparison struct {
+	// Issuers in both runs whose normalized scores differ by more than the
+	// threshold, from the largest change to the smallest.
+	Changed []*IssuerScoreChange
+	// Issuers only in the second run, and only in the first, ordered by
+	// issuer.
+	Appeared    []*IssuerScoreChange
+	Disappeared []*IssuerScoreChange
+}
+
+// Compares the finished issuer reputations of two runs, such as successive
+// runs over the same logs, for monitoring how issuers change over time.
+// Issuers are matched on their names and fingerprints, and the reputations
+// needn't cover the same months.
+func CompareIssuers(before []*IssuerReputation, after []*IssuerReputation,
+	threshold float32) *IssuerComparison {
+	beforeScores := combinedScores(before)
+	afterScores := combinedScores(after)
+	comparison := &IssuerComparison{
+		Changed:     make([]*IssuerScoreChange, 0),
+		Appeared:    make([]*IssuerScoreChange, 0),
+		Disappeared: make([]*IssuerScoreChange, 0),
+	}
+	nan := float32(math.NaN())
+	for key, beforeScore := range beforeScores {
+		afterScore, ok := afterScores[key]
+		if !ok {
+			comparison.Disappeared = append(comparison.Disappeared,
+				&IssuerScoreChange{key.issuer, key.fingerprint, beforeScore, nan})
+			continue
+		}
+		// A score that's undefined in either run can't be said to have
+		// changed.
+		change := float32(math.Abs(float64(afterScore - beforeScore)))
+		if change > threshold {
+			comparison.Changed = append(comparison.Changed,
+				&IssuerScoreChange{key.issuer, key.fingerprint, beforeScore, afterScore})
+		}
+	}
+	for key, afterScore := range afterScores {
+		if _, ok := beforeScores[key]; !ok {
+			comparison.Appeared = append(comparison.Appeared,
+				&IssuerScoreChange{key.issuer, key.fingerprint, nan, afterScore})
+		}
+	}
+	sort.Slice(comparison.Changed, func(i, j int) bool {
+		a, b := comparison.Changed[i], comparison.Changed[j]
+		aChange := math.Abs(float64(a.After - a.Before))
+		bChange := math.Abs(float64(b.After - b.Before))
+		if aChange != bChange {
+			return aChange > bChange
+		}
+		return scoreChangeLess(a, b)
+	})
+	sortScoreChanges(comparison.Appeared)
+	sortScoreChanges(comparison.Disappeared)
+	return comparison
+}
+
+// Returns the normalized score of each issuer over all of its reputations.
+func combinedScores(issuers []*IssuerReputation) map[issuerKey]float32 {
+	sums := make(map[issuerKey]float64)
+	counts := make(map[issuerKey]uint64)
+	for _, issuer := range issuers {
+		key := issuerKey{issuer.Issuer, issuer.IssuerSha256Fingerprint}
+		counts[key] += issuer.NormalizedCount
+		if issuer.NormalizedCount > 0 {
+			sums[key] += float64(issuer.NormalizedScore) *
+				float64(issuer.NormalizedCount)
+		}
+	}
+	scores := make(map[issuerKey]float32, len(counts))
+	for key, count := range counts {
+		scores[key] = float32(sums[key] / float64(count))
+	}
+	return scores
+}
+
+func scoreChangeLess(a *IssuerScoreChange, b *IssuerScoreChange) bool {
+	if a.Issuer != b.Issuer {
+		return a.Issuer < b.Issuer
+	}
+	return a.IssuerSha256Fingerprint < b.IssuerSha256Fingerprint
+}
+
+func sortScoreChanges(changes []*IssuerScoreChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return scoreChangeLess(changes[i], changes[j])
+	})
+}
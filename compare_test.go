@@ -0,0 +1,60 @@
+package sunlight
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCompareIssuers(t *testing.T) {
+	before := []*IssuerReputation{
+		{Issuer: "CN=Steady CA", NormalizedScore: 0.9, NormalizedCount: 10},
+		{Issuer: "CN=Worse CA", NormalizedScore: 0.9, NormalizedCount: 10},
+		// Over both months, 0.8 * 30 + 0.4 * 10 / 40 = 0.7
+		{Issuer: "CN=Better CA", NormalizedScore: 0.8, NormalizedCount: 30},
+		{Issuer: "CN=Better CA", NormalizedScore: 0.4, NormalizedCount: 10,
+			BeginTime: 1},
+		{Issuer: "CN=Gone CA", NormalizedScore: 0.5, NormalizedCount: 1},
+		{Issuer: "CN=Unranked CA", NormalizedScore: float32(math.NaN())},
+	}
+	after := []*IssuerReputation{
+		{Issuer: "CN=Steady CA", NormalizedScore: 0.88, NormalizedCount: 10},
+		{Issuer: "CN=Worse CA", NormalizedScore: 0.5, NormalizedCount: 20},
+		{Issuer: "CN=Better CA", NormalizedScore: 0.9, NormalizedCount: 5},
+		{Issuer: "CN=New CA", NormalizedScore: 1, NormalizedCount: 2},
+		{Issuer: "CN=New CA", IssuerSha256Fingerprint: "ab",
+			NormalizedScore: float32(math.NaN())},
+		{Issuer: "CN=Unranked CA", NormalizedScore: 0.1, NormalizedCount: 3},
+	}
+
+	comparison := CompareIssuers(before, after, 0.05)
+	if len(comparison.Changed) != 2 ||
+		comparison.Changed[0].Issuer != "CN=Worse CA" ||
+		comparison.Changed[0].Before != 0.9 || comparison.Changed[0].After != 0.5 ||
+		comparison.Changed[1].Issuer != "CN=Better CA" ||
+		math.Abs(float64(comparison.Changed[1].Before-0.7)) > 1e-6 {
+		t.Errorf("Unexpected changed issuers %+v", comparison.Changed)
+	}
+	if len(comparison.Appeared) != 2 ||
+		comparison.Appeared[0].Issuer != "CN=New CA" ||
+		comparison.Appeared[0].IssuerSha256Fingerprint != "" ||
+		comparison.Appeared[0].After != 1 ||
+		comparison.Appeared[1].IssuerSha256Fingerprint != "ab" {
+		t.Errorf("Unexpected new issuers %+v", comparison.Appeared)
+	}
+	if len(comparison.Disappeared) != 1 ||
+		comparison.Disappeared[0].Issuer != "CN=Gone CA" {
+		t.Errorf("Unexpected disappeared issuers %+v", comparison.Disappeared)
+	}
+
+	// Scores that aren't in a run are marshalled as null.
+	marshalled, err := json.Marshal(comparison.Disappeared[0])
+	if err != nil {
+		t.Fatal("could not marshal change", err)
+	}
+	if !strings.Contains(string(marshalled), `"Before":0.5`) ||
+		!strings.Contains(string(marshalled), `"After":null`) {
+		t.Errorf("Unexpected JSON %s", marshalled)
+	}
+}
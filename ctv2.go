@@ -0,0 +1,87 @@
+package sunlight
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/monicachew/certificatetransparency"
+)
+
+// VersionedTransType values from RFC 9162 section 4.4.
+const (
+	x509EntryV2    = 1
+	precertEntryV2 = 2
+)
+
+// Returns true if raw looks like an RFC 9162 (CT v2) TransItem holding a cert
+// or precert rather than an RFC 6962 MerkleTreeLeaf, which starts with a
+// version and leaf type of 0.
+func IsV2Entry(raw []byte) bool {
+	if len(raw) < 2 {
+		return false
+	}
+	transType := binary.BigEndian.Uint16(raw)
+	return transType == x509EntryV2 || transType == precertEntryV2
+}
+
+// Parses an RFC 9162 TransItem holding an x509_entry_v2 or precert_entry_v2.
+// These only contain the TBSCertificate, so the returned entry's X509Cert is
+// that wrapped in a certificate with an empty signature, and its fingerprint
+// won't match that of the cert as issued. There is no chain.
+func ParseV2Entry(raw []byte) (*certificatetransparency.Entry, error) {
+	if !IsV2Entry(raw) {
+		return nil, errors.New("not a v2 x509 or precert entry")
+	}
+	entry := &certificatetransparency.Entry{Type: certificatetransparency.X509Entry}
+	if binary.BigEndian.Uint16(raw) == precertEntryV2 {
+		entry.Type = certificatetransparency.PreCertEntry
+	}
+	data := raw[2:]
+	if len(data) < 8 {
+		return nil, errors.New("truncated v2 entry timestamp")
+	}
+	entry.Timestamp = binary.BigEndian.Uint64(data)
+	issuerKeyHash, data, err := readOpaque(data[8:], 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(issuerKeyHash) < 32 {
+		return nil, fmt.Errorf("v2 entry issuer key hash too short (%d bytes)",
+			len(issuerKeyHash))
+	}
+	tbs, data, err := readOpaque(data, 3)
+	if err != nil {
+		return nil, err
+	}
+	_, data, err = readOpaque(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 0 {
+		return nil, errors.New("trailing data after v2 entry")
+	}
+	entry.X509Cert, err = wrapTBSCertificate(tbs)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Wraps a DER-encoded TBSCertificate in a Certificate with the signature
+// algorithm it names and an empty signature.
+func wrapTBSCertificate(der []byte) ([]byte, error) {
+	var tbs tbsCertificate
+	rest, err := asn1.Unmarshal(der, &tbs)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after TBSCertificate")
+	}
+	return asn1.Marshal(struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm asn1.RawValue
+		Signature          asn1.BitString
+	}{asn1.RawValue{FullBytes: der}, tbs.SignatureAlgorithm, asn1.BitString{}})
+}
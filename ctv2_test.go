@@ -0,0 +1,74 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"github.com/monicachew/certificatetransparency"
+	"testing"
+	"time"
+)
+
+// Encodes an RFC 9162 TransItem holding an x509_entry_v2 for tbs.
+func makeV2Entry(timestamp uint64, tbs []byte) []byte {
+	var item bytes.Buffer
+	binary.Write(&item, binary.BigEndian, uint16(x509EntryV2))
+	binary.Write(&item, binary.BigEndian, timestamp)
+	issuerKeyHash := sha256.Sum256([]byte("issuer"))
+	item.WriteByte(byte(len(issuerKeyHash)))
+	item.Write(issuerKeyHash[:])
+	item.Write([]byte{byte(len(tbs) >> 16), byte(len(tbs) >> 8), byte(len(tbs))})
+	item.Write(tbs)
+	binary.Write(&item, binary.BigEndian, uint16(0))
+	return item.Bytes()
+}
+
+func TestParseV2Entry(t *testing.T) {
+	now := time.Now()
+	ts := uint64(now.Unix()) * 1000
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "v2.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(6, 0, 0),
+		DNSNames:  []string{"v2.example.com"},
+	})
+	raw := makeV2Entry(ts, cert.RawTBSCertificate)
+
+	entry, err := ParseV2Entry(raw)
+	if err != nil {
+		t.Fatal("could not parse v2 entry", err)
+	}
+	if entry.Timestamp != ts || entry.Type != certificatetransparency.X509Entry {
+		t.Errorf("Unexpected timestamp %d or type %d", entry.Timestamp, entry.Type)
+	}
+	parsed, err := x509.ParseCertificate(entry.X509Cert)
+	if err != nil {
+		t.Fatal("could not parse cert from v2 entry", err)
+	}
+	if !bytes.Equal(parsed.RawTBSCertificate, cert.RawTBSCertificate) {
+		t.Error("Cert from v2 entry has a different TBSCertificate")
+	}
+	if _, err := ParseV2Entry(raw[:len(raw)-1]); err == nil {
+		t.Error("Expected an error for a truncated v2 entry")
+	}
+
+	// The RFC 6962 parser rejects v2 entries, which are only analyzed when
+	// they're accepted.
+	ent := &certificatetransparency.EntryAndPosition{Index: 4, Raw: raw}
+	v1Err := errors.New("unknown leaf version")
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	analyzer.ProcessEntry(ent, v1Err)
+	if analyzer.ParseErrors != 1 {
+		t.Errorf("Expected a parse error without AcceptV2, got %d", analyzer.ParseErrors)
+	}
+	analyzer = NewAnalyzer(nil, nil, nil, nil)
+	analyzer.AcceptV2 = true
+	analyzer.ProcessEntry(ent, v1Err)
+	if analyzer.Summarized != 1 {
+		t.Errorf("Expected the v2 entry to be summarized, got %d parse errors",
+			analyzer.ParseErrors)
+	}
+}
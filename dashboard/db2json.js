@@ -9,7 +9,42 @@ var scorePrefixes = [
   "deprecatedSignatureAlgorithm",
   "missingCNinSAN",
   "keyTooShort",
-  "expTooSmall"
+  "expTooSmall",
+  "futureNotBefore",
+  "weakRSAModulus",
+  "sctSignatureInvalid",
+  "noSANExtension",
+  "unknownSignatureAlgorithm",
+  "poisonOnFinalCert",
+  "keyIdentifierMismatch",
+  "leafOutlivesIssuer",
+  "illegalDNSCharacter",
+  "mixedWildcardAndIP",
+  "emptySubjectNoSAN",
+  "pathLenExceeded",
+  "publicSuffixSAN",
+  "extensionsBeforeV3",
+  "missingAKI",
+  "multipleCN",
+  "missingCertPolicy",
+  "sha1InChain",
+  "reservedTLD",
+  "lateLogging",
+  "nonstandardRSAExponent",
+  "missingOCSP",
+  "missingCRL",
+  "nameConstraintViolation",
+  "misplacedWildcard",
+  "forbiddenSANType",
+  "unparseableKey",
+  "sha1AfterSunset",
+  "malformedValidityDates",
+  "keyUsageMismatch",
+  "insufficientSCTs",
+  "emptyOrganization",
+  "brokenSignatureAlgorithm",
+  "algorithmMismatch",
+  "ipAsDNSName"
 ];
 
 try {
@@ -98,11 +133,17 @@ function initIssuerData(path) {
   }
 }
 
+// The examples table has a row per issuer and violation. Gathers an issuer's
+// rows into a single object with <violation>Example and <violation>LastSeen
+// properties.
 function makeExamplesForIssuer(issuer, callback) {
-  var query = "SELECT * FROM examples where issuer=\"" + issuer + "\"";
+  var query = "SELECT violation, certPem, lastSeen FROM examples " +
+              "WHERE issuer=\"" + issuer + "\" ORDER BY violation";
   var examples;
   db.each(query, function(err, row) {
-    examples = row; // this should only happen once
+    examples = examples || { issuer: issuer };
+    examples[row.violation + "Example"] = row.certPem;
+    examples[row.violation + "LastSeen"] = row.lastSeen;
   }, function() {
     callback(examples);
   });
@@ -0,0 +1,256 @@
+package sunlight
+
+// How serious a violation is.
+const (
+	// Breaks a requirement, so the cert was misissued.
+	SEVERITY_ERROR = "Error"
+	// Not against the requirements, but likely a mistake or a bad practice.
+	// These are informational: they're recorded, but a cert with only these
+	// doesn't violate the BRs, and they don't count towards scores unless
+	// given a weight.
+	SEVERITY_WARNING = "Warning"
+)
+
+// Returns true if the violation with the given name is informational, with a
+// severity of SEVERITY_WARNING. Violations found by registered checks aren't.
+func IsInformational(name string) bool {
+	detail := violationDetails[name]
+	return detail != nil && detail.Severity == SEVERITY_WARNING
+}
+
+// A description of a violation, for showing to people.
+type ViolationDetail struct {
+	Name string
+	// The requirement that's violated, such as a section of the baseline
+	// requirements (as numbered when this was written) or of RFC 5280, or
+	// empty if there isn't one.
+	BRReference string
+	Description string
+	// SEVERITY_ERROR or SEVERITY_WARNING.
+	Severity string
+}
+
+// The details of each violation, keyed on name.
+var violationDetails = map[string]*ViolationDetail{
+	VALID_PERIOD_TOO_LONG: {
+		BRReference: "BR 9.4.1",
+		Description: "Validity period of a leaf cert is longer than 5 years.",
+		Severity:    SEVERITY_ERROR,
+	},
+	DEPRECATED_SIGNATURE_ALGORITHM: {
+		BRReference: "BR Appendix A",
+		Description: "Signed with SHA-1.",
+		Severity:    SEVERITY_ERROR,
+	},
+	BROKEN_SIGNATURE_ALGORITHM: {
+		BRReference: "BR 7.1.3",
+		Description: "Signed with MD2 or MD5.",
+		Severity:    SEVERITY_ERROR,
+	},
+	ALGORITHM_MISMATCH: {
+		BRReference: "RFC 5280 4.1.1.2",
+		Description: "Outer signature algorithm differs from the TBSCertificate's, which can mean the signature was replaced.",
+		Severity:    SEVERITY_ERROR,
+	},
+	DEPRECATED_VERSION: {
+		BRReference: "BR Appendix B",
+		Description: "Not an X.509 v3 cert.",
+		Severity:    SEVERITY_ERROR,
+	},
+	MISSING_CN_IN_SAN: {
+		BRReference: "BR 9.2.2",
+		Description: "Subject common name isn't one of the subject alternative names.",
+		Severity:    SEVERITY_ERROR,
+	},
+	KEY_TOO_SHORT: {
+		BRReference: "BR Appendix A",
+		Description: "RSA key is too short.",
+		Severity:    SEVERITY_ERROR,
+	},
+	EXP_TOO_SMALL: {
+		BRReference: "BR Appendix A",
+		Description: "RSA public exponent is 3 or less.",
+		Severity:    SEVERITY_ERROR,
+	},
+	FUTURE_NOT_BEFORE: {
+		Description: "Valid from further after it was logged than clock skew allows.",
+		Severity:    SEVERITY_WARNING,
+	},
+	WEAK_RSA_MODULUS: {
+		BRReference: "BR 6.1.1.3",
+		Description: "RSA modulus has a small factor or is a perfect square.",
+		Severity:    SEVERITY_ERROR,
+	},
+	SCT_SIGNATURE_INVALID: {
+		BRReference: "RFC 6962 3.3",
+		Description: "Embedded SCT couldn't be parsed or has a bad signature.",
+		Severity:    SEVERITY_ERROR,
+	},
+	NO_SAN_EXTENSION: {
+		BRReference: "BR 9.2.1",
+		Description: "Leaf cert has no DNS names or IP addresses in a subject alternative name extension.",
+		Severity:    SEVERITY_ERROR,
+	},
+	UNKNOWN_SIGNATURE_ALGORITHM: {
+		Description: "Signed with an unrecognized signature algorithm.",
+		Severity:    SEVERITY_WARNING,
+	},
+	POISON_ON_FINAL_CERT: {
+		BRReference: "RFC 6962 3.1",
+		Description: "Final cert carries the CT precertificate poison extension.",
+		Severity:    SEVERITY_ERROR,
+	},
+	KEY_IDENTIFIER_MISMATCH: {
+		BRReference: "RFC 5280 4.2.1.1",
+		Description: "Authority key identifier doesn't match the issuer's subject key identifier.",
+		Severity:    SEVERITY_WARNING,
+	},
+	LEAF_OUTLIVES_ISSUER: {
+		Description: "Valid after a cert in its chain expires.",
+		Severity:    SEVERITY_WARNING,
+	},
+	ILLEGAL_DNS_CHARACTER: {
+		BRReference: "RFC 5280 4.2.1.6",
+		Description: "DNS name has characters other than letters, digits and hyphens.",
+		Severity:    SEVERITY_ERROR,
+	},
+	MIXED_WILDCARD_AND_IP: {
+		Description: "Wildcard DNS name alongside IP addresses.",
+		Severity:    SEVERITY_WARNING,
+	},
+	EMPTY_SUBJECT_NO_SAN: {
+		BRReference: "RFC 5280 4.1.2.6",
+		Description: "Neither a subject nor any subject alternative names.",
+		Severity:    SEVERITY_ERROR,
+	},
+	PATHLEN_EXCEEDED: {
+		BRReference: "RFC 5280 4.2.1.9",
+		Description: "Chain has more intermediates than a CA's path length constraint allows.",
+		Severity:    SEVERITY_ERROR,
+	},
+	PUBLIC_SUFFIX_SAN: {
+		BRReference: "BR 11.1.1",
+		Description: "DNS name is a public suffix, which no one can validate control of.",
+		Severity:    SEVERITY_ERROR,
+	},
+	MISSING_AKI: {
+		BRReference: "RFC 5280 4.2.1.1",
+		Description: "Not self-issued, but has no authority key identifier.",
+		Severity:    SEVERITY_ERROR,
+	},
+	MULTIPLE_CN: {
+		BRReference: "BR 9.2.2",
+		Description: "Subject has more than one common name.",
+		Severity:    SEVERITY_WARNING,
+	},
+	MISSING_CERT_POLICY: {
+		BRReference: "BR 7.1.6.4",
+		Description: "Leaf cert asserts no certificate policy.",
+		Severity:    SEVERITY_ERROR,
+	},
+	SHA1_IN_CHAIN: {
+		BRReference: "BR 7.1.3",
+		Description: "An intermediate in the chain is signed with SHA-1.",
+		Severity:    SEVERITY_ERROR,
+	},
+	RESERVED_TLD: {
+		BRReference: "RFC 6761 6",
+		Description: "DNS name is under a special-use TLD, or is an onion name in a cert that isn't EV.",
+		Severity:    SEVERITY_ERROR,
+	},
+	LATE_LOGGING: {
+		Description: "Logged more than a day after its NotBefore, so probably backfilled.",
+		Severity:    SEVERITY_WARNING,
+	},
+	NONSTANDARD_RSA_EXPONENT: {
+		Description: "RSA public exponent isn't 65537.",
+		Severity:    SEVERITY_WARNING,
+	},
+	MISSING_OCSP: {
+		BRReference: "BR 7.1.2.3",
+		Description: "Leaf cert has no OCSP responder URL.",
+		Severity:    SEVERITY_WARNING,
+	},
+	MISSING_CRL: {
+		Description: "Leaf cert has no CRL distribution point.",
+		Severity:    SEVERITY_WARNING,
+	},
+	NAME_CONSTRAINT_VIOLATION: {
+		BRReference: "RFC 5280 4.2.1.10",
+		Description: "DNS name is outside the name constraints of a CA in the chain.",
+		Severity:    SEVERITY_ERROR,
+	},
+	MISPLACED_WILDCARD: {
+		BRReference: "BR 1.6.1",
+		Description: "DNS name has a wildcard other than as its whole leftmost label.",
+		Severity:    SEVERITY_ERROR,
+	},
+	FORBIDDEN_SAN_TYPE: {
+		BRReference: "BR 7.1.4.2.1",
+		Description: "TLS server cert has email, URI or otherName subject alternative names.",
+		Severity:    SEVERITY_ERROR,
+	},
+	UNPARSEABLE_KEY: {
+		BRReference: "BR 6.1.5",
+		Description: "Public key is of an unrecognized algorithm or couldn't be parsed.",
+		Severity:    SEVERITY_ERROR,
+	},
+	SHA1_AFTER_SUNSET: {
+		BRReference: "BR 7.1.3",
+		Description: "SHA-1 signature on a cert issued after SHA-1 was sunset.",
+		Severity:    SEVERITY_ERROR,
+	},
+	MALFORMED_VALIDITY_DATES: {
+		BRReference: "RFC 5280 4.1.2.5",
+		Description: "Validity date is before 1970 or after 9999, as when a date from 2050 on is encoded as a UTCTime.",
+		Severity:    SEVERITY_ERROR,
+	},
+	KEY_USAGE_MISMATCH: {
+		BRReference: "RFC 5280 4.2.1.3",
+		Description: "Key usage asserts something the public key can't do, like encipherment with an ECDSA key.",
+		Severity:    SEVERITY_ERROR,
+	},
+	INSUFFICIENT_SCTS: {
+		Description: "Cert embeds SCTs from fewer logs or log operators than Chrome's and Apple's CT policies require.",
+		Severity:    SEVERITY_WARNING,
+	},
+	EMPTY_ORGANIZATION: {
+		BRReference: "BR 7.1.4.2.2",
+		Description: "OV or EV cert's subject organizationName is empty or only whitespace.",
+		Severity:    SEVERITY_ERROR,
+	},
+	IP_AS_DNS_NAME: {
+		BRReference: "BR 7.1.4.2.1",
+		Description: "IP address is a DNS name SAN rather than an IP address SAN.",
+		Severity:    SEVERITY_ERROR,
+	},
+	EXTENSIONS_BEFORE_V3: {
+		BRReference: "RFC 5280 4.1.2.9",
+		Description: "v1 or v2 cert has extensions, which only v3 certs may have.",
+		Severity:    SEVERITY_ERROR,
+	},
+}
+
+func init() {
+	for name, detail := range violationDetails {
+		detail.Name = name
+	}
+}
+
+// Returns the details of the violations found in summary, in the order of
+// ViolationNames and then of any registered checks. Violations found by
+// registered checks only have a name.
+func (summary *CertSummary) ViolationDetails() []ViolationDetail {
+	details := make([]ViolationDetail, 0)
+	for _, name := range violationNames() {
+		if !summary.Violations[name] {
+			continue
+		}
+		if detail := violationDetails[name]; detail != nil {
+			details = append(details, *detail)
+		} else {
+			details = append(details, ViolationDetail{Name: name})
+		}
+	}
+	return details
+}
@@ -0,0 +1,82 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestViolationDetails(t *testing.T) {
+	for _, name := range ViolationNames {
+		detail := violationDetails[name]
+		if detail == nil || detail.Name != name || detail.Description == "" ||
+			(detail.Severity != SEVERITY_ERROR && detail.Severity != SEVERITY_WARNING) {
+			t.Errorf("Missing or incomplete details for %s: %+v", name, detail)
+		}
+	}
+
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	dvPolicy, _ := x509.OIDFromInts([]uint64{2, 23, 140, 1, 2, 1})
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "long.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(6, 0, 0),
+		DNSNames:  []string{"long.example.com"},
+		Policies:  []x509.OID{dvPolicy},
+	})
+	logged := uint64(notBefore.Unix()) * 1000
+	summary, _ := CalculateCertSummary(cert, 0, logged, false, nil, nil, nil, nil)
+	details := summary.ViolationDetails()
+	expected := ViolationDetail{
+		Name:        VALID_PERIOD_TOO_LONG,
+		BRReference: "BR 9.4.1",
+		Description: "Validity period of a leaf cert is longer than 5 years.",
+		Severity:    SEVERITY_ERROR,
+	}
+	// Without OCSP or CRL URLs, the cert also has informational violations,
+	// which come after it.
+	if len(details) != 3 || details[0] != expected ||
+		details[1].Name != MISSING_OCSP || details[2].Name != MISSING_CRL {
+		t.Errorf("Expected %+v and then MissingOCSP and MissingCRL, got %+v",
+			expected, details)
+	}
+}
+
+func TestInformationalViolations(t *testing.T) {
+	for _, name := range []string{MISSING_OCSP, MISSING_CRL, LATE_LOGGING,
+		NONSTANDARD_RSA_EXPONENT, MIXED_WILDCARD_AND_IP} {
+		if !IsInformational(name) {
+			t.Errorf("Expected %s to be informational", name)
+		}
+		if weight := (*RuleConfig)(nil).Weight(name); weight != 0 {
+			t.Errorf("Expected %s to have a default weight of 0, got %f", name,
+				weight)
+		}
+	}
+	if IsInformational(KEY_TOO_SHORT) || IsInformational("RegisteredCheck") {
+		t.Error("Expected errors and registered checks not to be informational")
+	}
+
+	informational := &CertSummary{Violations: map[string]bool{
+		MISSING_OCSP:  true,
+		MISSING_CRL:   true,
+		KEY_TOO_SHORT: false,
+	}}
+	if informational.ViolatesBR() {
+		t.Error("Expected only informational violations not to violate the BRs")
+	}
+	informational.Violations[KEY_TOO_SHORT] = true
+	if !informational.ViolatesBR() {
+		t.Error("Expected KeyTooShort to violate the BRs")
+	}
+
+	issuer := NewIssuerReputation(pkix.Name{CommonName: "Example CA"}, 0)
+	issuer.Update(&CertSummary{MaxReputation: -1,
+		Violations: map[string]bool{MISSING_OCSP: true, KEY_TOO_SHORT: false}})
+	issuer.Finish()
+	if issuer.ViolatingCount != 0 || issuer.RawScore != 1 {
+		t.Errorf("Expected an informational violation not to count, got %d "+
+			"violating and a raw score of %f", issuer.ViolatingCount, issuer.RawScore)
+	}
+}
@@ -0,0 +1,81 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"golang.org/x/net/publicsuffix"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The violating certs covering names under a registrable domain.
+type DomainGroup struct {
+	// The registrable domain (eTLD+1), such as example.co.uk.
+	Domain string
+	// The number of distinct violating certs with names under Domain.
+	Certs int
+	// The distinct issuers of those certs.
+	Issuers []string
+}
+
+// A Sink that groups violating certs by the registrable domains of their DNS
+// names, to find domains that keep turning up in misissuance, such as those of
+// shared hosting providers. A cert with names under several domains is counted
+// in each.
+type DomainReport struct {
+	lock    sync.Mutex
+	certs   map[string]map[string]bool
+	issuers map[string]map[string]bool
+}
+
+func NewDomainReport() *DomainReport {
+	return &DomainReport{
+		certs:   make(map[string]map[string]bool),
+		issuers: make(map[string]map[string]bool),
+	}
+}
+
+func (r *DomainReport) Write(summary *CertSummary, cert *x509.Certificate) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, name := range summary.DnsNames {
+		domain, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(name, "*."))
+		if err != nil {
+			continue
+		}
+		if r.certs[domain] == nil {
+			r.certs[domain] = make(map[string]bool)
+			r.issuers[domain] = make(map[string]bool)
+		}
+		r.certs[domain][summary.Sha256Fingerprint] = true
+		r.issuers[domain][summary.Issuer] = true
+	}
+	return nil
+}
+
+func (r *DomainReport) Close() error { return nil }
+
+// Returns the domains covered by more than minCerts violating certs, from the
+// most certs to the fewest.
+func (r *DomainReport) Groups(minCerts int) []*DomainGroup {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	groups := make([]*DomainGroup, 0)
+	for domain, certs := range r.certs {
+		if len(certs) <= minCerts {
+			continue
+		}
+		groups = append(groups, &DomainGroup{
+			Domain:  domain,
+			Certs:   len(certs),
+			Issuers: sortedKeys(r.issuers[domain]),
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Certs != groups[j].Certs {
+			return groups[i].Certs > groups[j].Certs
+		}
+		return groups[i].Domain < groups[j].Domain
+	})
+	return groups
+}
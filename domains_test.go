@@ -0,0 +1,38 @@
+package sunlight
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDomainReport(t *testing.T) {
+	report := NewDomainReport()
+	for _, summary := range []*CertSummary{
+		{Sha256Fingerprint: "a", Issuer: "CN=First CA",
+			DnsNames: []string{"www.example.co.uk", "example.co.uk"}},
+		{Sha256Fingerprint: "b", Issuer: "CN=Second CA",
+			DnsNames: []string{"*.shop.example.co.uk"}},
+		{Sha256Fingerprint: "c", Issuer: "CN=First CA",
+			DnsNames: []string{"mail.example.co.uk", "other.com"}},
+		// The same cert written twice counts once.
+		{Sha256Fingerprint: "c", Issuer: "CN=First CA",
+			DnsNames: []string{"mail.example.co.uk"}},
+		{Sha256Fingerprint: "d", Issuer: "CN=First CA",
+			DnsNames: []string{"co.uk"}},
+	} {
+		report.Write(summary, nil)
+	}
+
+	groups := report.Groups(0)
+	expected := []*DomainGroup{
+		{Domain: "example.co.uk", Certs: 3,
+			Issuers: []string{"CN=First CA", "CN=Second CA"}},
+		{Domain: "other.com", Certs: 1, Issuers: []string{"CN=First CA"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected %v, got %v", expected, groups)
+	}
+	if groups := report.Groups(1); len(groups) != 1 || groups[0].Domain != "example.co.uk" {
+		t.Errorf("Expected only example.co.uk with more than 1 cert, got %v", groups)
+	}
+}
@@ -0,0 +1,106 @@
+package sunlight
+
+import (
+	"github.com/monicachew/certificatetransparency"
+	"sort"
+	"sync"
+)
+
+// Passes the entries EntriesFile.Map hands it, concurrently, on to a callback
+// one at a time in log order, as soon as every entry before each one has
+// arrived. This makes processing reproducible. Map reads a file as a stream,
+// and its workers only get a little out of order, so this holds a handful of
+// entries at a time rather than all of them. If the entries don't start at
+// the index it was given, or some are missing, everything after the gap is
+// held until Flush.
+type EntryReorderer struct {
+	callback func(*certificatetransparency.EntryAndPosition, error)
+	next     uint64
+	pending  map[uint64]*certificatetransparency.EntryAndPosition
+	errs     map[uint64]error
+	// Errors for entries without a position, which are passed on last.
+	unpositioned []error
+	// The most entries held at once so far.
+	MaxPending int
+	lock       sync.Mutex
+}
+
+// Returns an EntryReorderer passing entries to callback in order of index,
+// starting from first.
+func NewEntryReorderer(first uint64,
+	callback func(*certificatetransparency.EntryAndPosition, error)) *EntryReorderer {
+	return &EntryReorderer{
+		callback: callback,
+		next:     first,
+		pending:  make(map[uint64]*certificatetransparency.EntryAndPosition),
+		errs:     make(map[uint64]error),
+	}
+}
+
+// A callback for EntriesFile.Map. It calls the reorderer's callback, with the
+// reorderer locked, for the entry and any held ones that follow it.
+func (r *EntryReorderer) Add(ent *certificatetransparency.EntryAndPosition, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if ent == nil {
+		r.unpositioned = append(r.unpositioned, err)
+		return
+	}
+	r.pending[ent.Index] = ent
+	r.errs[ent.Index] = err
+	if len(r.pending) > r.MaxPending {
+		r.MaxPending = len(r.pending)
+	}
+	for {
+		next, ok := r.pending[r.next]
+		if !ok {
+			return
+		}
+		err := r.errs[r.next]
+		delete(r.pending, r.next)
+		delete(r.errs, r.next)
+		r.next++
+		r.callback(next, err)
+	}
+}
+
+// Calls the callback with any entries still held, in order of index, then
+// with the errors of entries without a position. Call it once Map returns.
+func (r *EntryReorderer) Flush() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	indices := make([]uint64, 0, len(r.pending))
+	for index := range r.pending {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	for _, index := range indices {
+		r.callback(r.pending[index], r.errs[index])
+		delete(r.pending, index)
+		delete(r.errs, index)
+	}
+	for _, err := range r.unpositioned {
+		r.callback(nil, err)
+	}
+	r.unpositioned = nil
+}
+
+// Returns a callback for EntriesFile.Map that passes entries on to callback
+// with at most workers calls to it running at once, however many goroutines
+// Map uses. Calls beyond that wait their turn. This bounds how much work is
+// in flight, not the order entries are processed in, so it doesn't make
+// output reproducible; even with one worker, entries arrive in whatever order
+// Map's goroutines hand them over. If workers isn't positive, callback is
+// returned as it is.
+func LimitWorkers(callback func(*certificatetransparency.EntryAndPosition, error),
+	workers int) func(*certificatetransparency.EntryAndPosition, error) {
+	if workers <= 0 {
+		return callback
+	}
+	slots := make(chan struct{}, workers)
+	return func(ent *certificatetransparency.EntryAndPosition, err error) {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		callback(ent, err)
+	}
+}
@@ -0,0 +1,113 @@
+package sunlight
+
+import (
+	"errors"
+	"github.com/monicachew/certificatetransparency"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEntryReordererPassesEntriesOnInOrder(t *testing.T) {
+	var passed []uint64
+	var errs []error
+	reorderer := NewEntryReorderer(10,
+		func(ent *certificatetransparency.EntryAndPosition, err error) {
+			if ent == nil {
+				errs = append(errs, err)
+				return
+			}
+			passed = append(passed, ent.Index)
+		})
+	add := func(index uint64) {
+		reorderer.Add(&certificatetransparency.EntryAndPosition{Index: index}, nil)
+	}
+
+	// Each entry is passed on once all of those before it have been.
+	add(11)
+	add(10)
+	if len(passed) != 2 {
+		t.Fatalf("Expected entries 10 and 11 to be passed on, got %v", passed)
+	}
+	reorderer.Add(nil, errors.New("truncated entry"))
+	add(13)
+	add(12)
+	if len(passed) != 4 || passed[2] != 12 || passed[3] != 13 {
+		t.Fatalf("Expected entries 12 and 13 to follow, got %v", passed)
+	}
+
+	// Entries after a gap are held until Flush, which passes them on in
+	// order, then the errors of entries without a position.
+	add(16)
+	add(15)
+	if len(passed) != 4 {
+		t.Fatalf("Expected entries after the gap to be held, got %v", passed)
+	}
+	reorderer.Flush()
+	if len(passed) != 6 || passed[4] != 15 || passed[5] != 16 || len(errs) != 1 {
+		t.Errorf("Expected entries 15 and 16 and an error, got %v and %v",
+			passed, errs)
+	}
+	if reorderer.MaxPending != 2 {
+		t.Errorf("Expected at most 2 entries held, got %d", reorderer.MaxPending)
+	}
+}
+
+// Entries that are only a little out of order, as Map's workers hand them
+// over, are only held for a little while, however many there are.
+func TestEntryReordererHoldsFewEntries(t *testing.T) {
+	const window = 8
+	var passed uint64
+	reorderer := NewEntryReorderer(0,
+		func(ent *certificatetransparency.EntryAndPosition, err error) {
+			if ent.Index != passed {
+				t.Fatalf("Expected entry %d, got %d", passed, ent.Index)
+			}
+			passed++
+		})
+	for start := uint64(0); start < 10000; start += window {
+		for i := uint64(window); i > 0; i-- {
+			reorderer.Add(&certificatetransparency.EntryAndPosition{
+				Index: start + i - 1}, nil)
+		}
+	}
+	reorderer.Flush()
+	if passed != 10000 || reorderer.MaxPending != window {
+		t.Errorf("Expected 10000 entries with at most %d held, got %d and %d",
+			window, passed, reorderer.MaxPending)
+	}
+}
+
+func TestLimitWorkers(t *testing.T) {
+	const workers = 3
+	var running, maxRunning, calls int32
+	callback := LimitWorkers(func(ent *certificatetransparency.EntryAndPosition,
+		err error) {
+		now := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&calls, 1)
+		atomic.AddInt32(&running, -1)
+	}, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(index uint64) {
+			defer wg.Done()
+			callback(&certificatetransparency.EntryAndPosition{Index: index}, nil)
+		}(uint64(i))
+	}
+	wg.Wait()
+	if calls != 50 {
+		t.Errorf("Expected 50 calls, got %d", calls)
+	}
+	if maxRunning > workers {
+		t.Errorf("Expected at most %d calls at once, got %d", workers, maxRunning)
+	}
+}
@@ -0,0 +1,135 @@
+package sunlight
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// A HyperLogLog has 2^hllPrecision registers, for a standard error of
+	// about 1.04 / sqrt(2^hllPrecision), or 0.8%.
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+	// Up to this many distinct values are counted exactly, since most issuers
+	// are small and an exact count takes much less memory for them.
+	hllMaxExact = 1024
+)
+
+// Counts distinct strings in bounded memory. Values are counted exactly until
+// there are more than hllMaxExact of them, then estimated with HyperLogLog.
+// The zero value is an empty counter. It isn't safe for concurrent use.
+type hyperLogLog struct {
+	exact     map[uint64]bool
+	registers []uint8
+}
+
+// Mixes the bits of z with the splitmix64 finalizer.
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (h *hyperLogLog) Add(value string) {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	x := mix64(hash.Sum64())
+	if h.registers == nil {
+		if h.exact == nil {
+			h.exact = make(map[uint64]bool)
+		}
+		h.exact[x] = true
+		if len(h.exact) <= hllMaxExact {
+			return
+		}
+		h.registers = make([]uint8, hllRegisters)
+		for seen := range h.exact {
+			h.addHash(seen)
+		}
+		h.exact = nil
+		return
+	}
+	h.addHash(x)
+}
+
+// The top hllPrecision bits of x pick a register, which keeps the longest run
+// of leading zeros seen in the rest.
+func (h *hyperLogLog) addHash(x uint64) {
+	register := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[register] {
+		h.registers[register] = rank
+	}
+}
+
+// Returns the number of distinct values added, or an estimate of it.
+func (h *hyperLogLog) Count() uint64 {
+	if h.registers == nil {
+		return uint64(len(h.exact))
+	}
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// Small cardinalities are better estimated by linear counting.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func (h *hyperLogLog) clone() hyperLogLog {
+	var clone hyperLogLog
+	if h.exact != nil {
+		clone.exact = make(map[uint64]bool, len(h.exact))
+		for x := range h.exact {
+			clone.exact[x] = true
+		}
+	}
+	if h.registers != nil {
+		clone.registers = append([]uint8(nil), h.registers...)
+	}
+	return clone
+}
+
+// A hyperLogLog's contents, for saving it.
+type hyperLogLogState struct {
+	Exact     []uint64 `json:",omitempty"`
+	Registers []uint8  `json:",omitempty"`
+}
+
+func (h *hyperLogLog) state() hyperLogLogState {
+	state := hyperLogLogState{Registers: h.registers}
+	for x := range h.exact {
+		state.Exact = append(state.Exact, x)
+	}
+	sort.Slice(state.Exact, func(i, j int) bool {
+		return state.Exact[i] < state.Exact[j]
+	})
+	return state
+}
+
+func (h *hyperLogLog) restore(state hyperLogLogState) error {
+	if state.Registers != nil && len(state.Registers) != hllRegisters {
+		return fmt.Errorf("expected %d registers, got %d", hllRegisters,
+			len(state.Registers))
+	}
+	h.registers = state.Registers
+	h.exact = nil
+	if h.registers == nil && len(state.Exact) > 0 {
+		h.exact = make(map[uint64]bool)
+		for _, x := range state.Exact {
+			h.exact[x] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package sunlight
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 1, 1000, 5000, 100000} {
+		var h hyperLogLog
+		// Adding each value twice mustn't change the count.
+		for i := 0; i < 2*n; i++ {
+			h.Add(fmt.Sprintf("domain%d.example", i%n))
+		}
+		count := h.Count()
+		if n <= hllMaxExact {
+			if count != uint64(n) {
+				t.Errorf("Expected an exact count of %d, got %d", n, count)
+			}
+			continue
+		}
+		if h.exact != nil {
+			t.Errorf("Expected %d values to be estimated", n)
+		}
+		if math.Abs(float64(count)-float64(n)) > 0.03*float64(n) {
+			t.Errorf("Estimate %d is more than 3%% from %d", count, n)
+		}
+	}
+}
+
+func TestHyperLogLogState(t *testing.T) {
+	for _, n := range []int{10, 5000} {
+		var h, restored hyperLogLog
+		for i := 0; i < n; i++ {
+			h.Add(fmt.Sprintf("domain%d.example", i))
+		}
+		if err := restored.restore(h.state()); err != nil {
+			t.Fatal("could not restore state", err)
+		}
+		// Adding values already seen leaves the count as it was.
+		for i := 0; i < n; i++ {
+			restored.Add(fmt.Sprintf("domain%d.example", i))
+		}
+		if restored.Count() != h.Count() {
+			t.Errorf("Expected a restored count of %d, got %d", h.Count(),
+				restored.Count())
+		}
+	}
+	var h hyperLogLog
+	if err := h.restore(hyperLogLogState{Registers: []uint8{1}}); err == nil {
+		t.Error("Expected an error restoring the wrong number of registers")
+	}
+}
@@ -0,0 +1,126 @@
+// Package testcerts generates certs with particular properties for tests, so
+// that a test of a check can describe the cert it needs instead of
+// hand-crafting it.
+package testcerts
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The ECDSA P-256 key that Sign signs with, and that New uses unless a spec
+// asks for an RSA key.
+var Key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+// Generating RSA keys is slow, so each size is only generated once.
+var rsaKeys = make(map[int]*rsa.PrivateKey)
+var rsaKeysLock sync.Mutex
+
+// Returns an RSA key of the given size, the same one each time. Go
+// won't generate keys shorter than 1024 bits.
+func RSAKey(tb testing.TB, bits int) *rsa.PrivateKey {
+	rsaKeysLock.Lock()
+	defer rsaKeysLock.Unlock()
+	if rsaKeys[bits] == nil {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			tb.Fatalf("could not generate %d-bit RSA key: %s", bits, err)
+		}
+		rsaKeys[bits] = key
+	}
+	return rsaKeys[bits]
+}
+
+// Describes a cert for New to generate. The zero value is a self-signed
+// ECDSA leaf cert for no names, valid for a year from the start of 2014.
+type Spec struct {
+	Subject pkix.Name
+	// The size of the cert's RSA key, or 0 for Key.
+	KeyBits int
+	// NotBefore defaults to the start of 2014, and NotAfter to a year after
+	// NotBefore.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// 0 for Go's default for the key.
+	SignatureAlgorithm x509.SignatureAlgorithm
+	DNSNames           []string
+	IPAddresses        []net.IP
+	EmailAddresses     []string
+	Policies           []x509.OID
+	KeyUsage           x509.KeyUsage
+	IsCA               bool
+	// Added to the cert as they are, replacing any extension Go would
+	// otherwise generate with the same OID.
+	Extensions []pkix.Extension
+}
+
+// Returns the template New creates the cert from, for tests that need
+// something Spec doesn't cover.
+func (spec Spec) Template() *x509.Certificate {
+	notBefore := spec.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	notAfter := spec.NotAfter
+	if notAfter.IsZero() {
+		notAfter = notBefore.AddDate(1, 0, 0)
+	}
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               spec.Subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		SignatureAlgorithm:    spec.SignatureAlgorithm,
+		DNSNames:              spec.DNSNames,
+		IPAddresses:           spec.IPAddresses,
+		EmailAddresses:        spec.EmailAddresses,
+		Policies:              spec.Policies,
+		KeyUsage:              spec.KeyUsage,
+		IsCA:                  spec.IsCA,
+		BasicConstraintsValid: spec.IsCA,
+		ExtraExtensions:       spec.Extensions,
+	}
+}
+
+// Generates the cert spec describes, self-signed with its own key.
+func New(tb testing.TB, spec Spec) *x509.Certificate {
+	var key crypto.Signer = Key
+	if spec.KeyBits != 0 {
+		key = RSAKey(tb, spec.KeyBits)
+	}
+	template := spec.Template()
+	return create(tb, template, template, key.Public(), key)
+}
+
+// Creates a cert for the public key pub from template, issued by parent and
+// signed with Key. If template is parent, the cert is self-issued.
+func Sign(tb testing.TB, template *x509.Certificate, parent *x509.Certificate,
+	pub interface{}) *x509.Certificate {
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(1)
+	}
+	return create(tb, template, parent, pub, Key)
+}
+
+func create(tb testing.TB, template *x509.Certificate, parent *x509.Certificate,
+	pub interface{}, key crypto.Signer) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, key)
+	if err != nil {
+		tb.Fatal("could not create certificate", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal("could not parse certificate", err)
+	}
+	return cert
+}
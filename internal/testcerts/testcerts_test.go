@@ -0,0 +1,59 @@
+package testcerts
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestNewDefaults(t *testing.T) {
+	cert := New(t, Spec{})
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !cert.NotBefore.Equal(start) || !cert.NotAfter.Equal(start.AddDate(1, 0, 0)) {
+		t.Errorf("Expected a year from %s, got %s to %s", start, cert.NotBefore,
+			cert.NotAfter)
+	}
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || cert.IsCA {
+		t.Errorf("Expected an ECDSA leaf cert, got %T (IsCA %t)", cert.PublicKey,
+			cert.IsCA)
+	}
+	err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate,
+		cert.Signature)
+	if err != nil {
+		t.Errorf("Expected a self-signed cert: %s", err)
+	}
+}
+
+func TestNewWithSpec(t *testing.T) {
+	cert := New(t, Spec{
+		Subject:            pkix.Name{CommonName: "www.example.com"},
+		KeyBits:            1024,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		DNSNames:           []string{"www.example.com"},
+		IsCA:               true,
+	})
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || key.N.BitLen() != 1024 {
+		t.Fatalf("Expected a 1024-bit RSA key, got %T", cert.PublicKey)
+	}
+	if key.N.Cmp(RSAKey(t, 1024).N) != 0 {
+		t.Error("Expected the same RSA key for each cert of a size")
+	}
+	if cert.SignatureAlgorithm != x509.SHA256WithRSA || !cert.IsCA ||
+		cert.Subject.CommonName != "www.example.com" ||
+		len(cert.DNSNames) != 1 {
+		t.Errorf("Cert doesn't match its spec: %+v", cert)
+	}
+}
+
+func TestSign(t *testing.T) {
+	parent := New(t, Spec{Subject: pkix.Name{CommonName: "Test CA"}, IsCA: true})
+	cert := Sign(t, Spec{DNSNames: []string{"leaf.example.com"}}.Template(),
+		parent, &Key.PublicKey)
+	if err := cert.CheckSignatureFrom(parent); err != nil {
+		t.Errorf("Expected a cert signed by its parent: %s", err)
+	}
+}
@@ -0,0 +1,100 @@
+package sunlight
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// An unfinished issuer reputation as saved by SaveIssuers.
+type savedIssuer struct {
+	Reputation        *IssuerReputation
+	Domains           hyperLogLogState
+	ReputationSum     float64 `json:",omitempty"`
+	ReputationSquares float64 `json:",omitempty"`
+}
+
+// Returns the key of the reputation for the issuer with the given name and
+// fingerprint in the month containing timestamp.
+func reputationKey(issuer string, fingerprint string, timestamp uint64) string {
+	return fmt.Sprintf("%s:%s:%d", issuer, fingerprint, TruncateMonth(timestamp))
+}
+
+// Writes the issuer reputations accumulated so far to w as JSON, so that a
+// later run can carry on from them with LoadIssuers. Only call it once
+// processing is done, and before the reputations are finished.
+func (a *Analyzer) SaveIssuers(w io.Writer) error {
+	saved := make([]savedIssuer, 0, len(a.Issuers))
+	for _, issuer := range a.Issuers {
+		saved = append(saved, savedIssuer{issuer, issuer.domains.state(),
+			issuer.reputationSum, issuer.reputationSquares})
+	}
+	sort.Slice(saved, func(i, j int) bool {
+		return reputationLess(saved[i].Reputation, saved[j].Reputation)
+	})
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// Orders reputations by issuer, fingerprint and month.
+func reputationLess(a *IssuerReputation, b *IssuerReputation) bool {
+	if a.Issuer != b.Issuer {
+		return a.Issuer < b.Issuer
+	}
+	if a.IssuerSha256Fingerprint != b.IssuerSha256Fingerprint {
+		return a.IssuerSha256Fingerprint < b.IssuerSha256Fingerprint
+	}
+	return a.BeginTime < b.BeginTime
+}
+
+// Returns finished copies of the issuer reputations accumulated so far,
+// ordered by issuer, fingerprint and month, leaving the originals to carry
+// on being updated. Evicted reputations aren't included. Unlike SaveIssuers,
+// it's safe to call while entries are being processed, but not once the
+// reputations have been finished.
+func (a *Analyzer) IssuerSnapshot() []*IssuerReputation {
+	a.issuersLock.RLock()
+	snapshot := make([]*IssuerReputation, 0, len(a.Issuers))
+	for _, issuer := range a.Issuers {
+		snapshot = append(snapshot, issuer.clone())
+	}
+	a.issuersLock.RUnlock()
+	for _, issuer := range snapshot {
+		issuer.FinishWeighted(a.config)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return reputationLess(snapshot[i], snapshot[j])
+	})
+	return snapshot
+}
+
+// Reads issuer reputations written by SaveIssuers, so that processing adds to
+// them. Each stays in the month it was saved for, and certs logged in a later
+// month start a reputation of their own. Call it before processing any
+// entries.
+func (a *Analyzer) LoadIssuers(r io.Reader) error {
+	var saved []savedIssuer
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return err
+	}
+	for _, s := range saved {
+		issuer := s.Reputation
+		if issuer == nil {
+			return fmt.Errorf("saved issuer has no reputation")
+		}
+		if issuer.Scores == nil {
+			issuer.Scores = make(map[string]*IssuerReputationScore)
+		}
+		if err := issuer.domains.restore(s.Domains); err != nil {
+			return fmt.Errorf("bad registrable domains for %s: %s", issuer.Issuer, err)
+		}
+		issuer.reputationSum = s.ReputationSum
+		issuer.reputationSquares = s.ReputationSquares
+		key := reputationKey(issuer.Issuer, issuer.IssuerSha256Fingerprint,
+			issuer.BeginTime)
+		a.issuersLock.Lock()
+		a.Issuers[key] = issuer
+		a.issuersLock.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/monicachew/certificatetransparency"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadIssuers(t *testing.T) {
+	june := time.Date(2014, time.June, 12, 0, 0, 0, 0, time.UTC)
+	july := time.Date(2014, time.July, 1, 0, 0, 1, 0, time.UTC)
+	// Self-signed, so both certs have the same issuer.
+	var certs []*x509.Certificate
+	for _, name := range []string{"a.example.com", "b.example.org"} {
+		certs = append(certs, makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "Example CA"},
+			NotBefore: june,
+			NotAfter:  june.AddDate(6, 0, 0),
+			DNSNames:  []string{name},
+		}))
+	}
+	entry := func(cert *x509.Certificate, logged time.Time) *certificatetransparency.EntryAndPosition {
+		return &certificatetransparency.EntryAndPosition{
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(logged.Unix()) * 1000,
+				X509Cert:  cert.Raw,
+			},
+		}
+	}
+
+	// The first day's run.
+	first := NewAnalyzer(nil, nil, nil, nil)
+	first.IncludeExpired = true
+	first.ProcessEntry(entry(certs[0], june), nil)
+	var saved bytes.Buffer
+	if err := first.SaveIssuers(&saved); err != nil {
+		t.Fatal("could not save issuers", err)
+	}
+
+	// The next day's run carries on from it, with one cert logged in the
+	// same month and one in the next.
+	second := NewAnalyzer(nil, nil, nil, nil)
+	second.IncludeExpired = true
+	if err := second.LoadIssuers(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatal("could not load issuers", err)
+	}
+	second.ProcessEntry(entry(certs[1], june.AddDate(0, 0, 1)), nil)
+	second.ProcessEntry(entry(certs[0], july), nil)
+
+	if len(second.Issuers) != 2 {
+		t.Fatalf("Expected reputations for June and July, got %d",
+			len(second.Issuers))
+	}
+	for _, issuer := range second.Issuers {
+		issuer.Finish()
+		expectedCount, expectedDomains := uint64(1), uint64(1)
+		if issuer.BeginTime == TruncateMonth(uint64(june.Unix())*1000) {
+			expectedCount, expectedDomains = 2, 2
+		}
+		if issuer.RawCount != expectedCount ||
+			issuer.Scores[VALID_PERIOD_TOO_LONG].ViolatingCount != expectedCount ||
+			issuer.RegistrableDomains != expectedDomains {
+			t.Errorf("Expected %d certs for %d domains starting %d, got %+v",
+				expectedCount, expectedDomains, issuer.BeginTime, issuer)
+		}
+	}
+
+	if err := second.LoadIssuers(bytes.NewReader([]byte("{"))); err == nil {
+		t.Error("Expected an error loading truncated issuers")
+	}
+}
+
+func TestIssuerSnapshot(t *testing.T) {
+	june := time.Date(2014, time.June, 12, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Example CA"},
+		NotBefore: june,
+		NotAfter:  june.AddDate(6, 0, 0),
+		DNSNames:  []string{"a.example.com"},
+	})
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	analyzer.IncludeExpired = true
+	for i := 1; i <= 2; i++ {
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(june.Unix()) * 1000,
+				X509Cert:  cert.Raw,
+			},
+		}, nil)
+		snapshot := analyzer.IssuerSnapshot()
+		if len(snapshot) != 1 || snapshot[0].RawCount != uint64(i) {
+			t.Fatalf("Expected one reputation with %d certs, got %+v", i, snapshot)
+		}
+		// Every cert violates VALID_PERIOD_TOO_LONG, so a finished score is 0
+		// however many there are, as long as the original isn't finished too.
+		if score := snapshot[0].Score(VALID_PERIOD_TOO_LONG).RawScore; score != 0 {
+			t.Errorf("Expected a raw score of 0 after %d certs, got %f", i, score)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package sunlight
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/monicachew/certificatetransparency"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The most entries a LogClient asks for at once, unless told otherwise. Logs
+// may return fewer.
+const DEFAULT_LOG_BATCH_SIZE = 256
+
+// A client for the RFC 6962 HTTP API of a CT log, for following a log as it
+// grows rather than reading entries downloaded to a file.
+type LogClient struct {
+	// The log's URL, without the /ct/v1/ part.
+	URL    string
+	Client *http.Client
+	// The most entries to ask for at once. If 0, DEFAULT_LOG_BATCH_SIZE is
+	// used.
+	BatchSize uint64
+}
+
+func NewLogClient(url string) *LogClient {
+	return &LogClient{
+		URL:    strings.TrimSuffix(url, "/"),
+		Client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Gets path from the log and decodes its JSON response into v.
+func (c *LogClient) getJSON(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest("GET", c.URL+"/ct/v1/"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response to %s: %s", path, err)
+	}
+	return nil
+}
+
+// Returns the size of the log's tree, which is how many entries it has.
+func (c *LogClient) TreeSize(ctx context.Context) (uint64, error) {
+	var sth struct {
+		TreeSize uint64 `json:"tree_size"`
+	}
+	if err := c.getJSON(ctx, "get-sth", &sth); err != nil {
+		return 0, err
+	}
+	return sth.TreeSize, nil
+}
+
+// Returns entries from start up to, but not including, end, or as many of
+// them from start as the log returns at once. An entry that can't be parsed
+// has no Entry, and its error in place of it in errs.
+func (c *LogClient) GetEntries(ctx context.Context, start uint64,
+	end uint64) (entries []*certificatetransparency.EntryAndPosition,
+	errs []error, err error) {
+	batchSize := c.BatchSize
+	if batchSize == 0 {
+		batchSize = DEFAULT_LOG_BATCH_SIZE
+	}
+	if end-start > batchSize {
+		end = start + batchSize
+	}
+	var response struct {
+		Entries []struct {
+			LeafInput []byte `json:"leaf_input"`
+			ExtraData []byte `json:"extra_data"`
+		} `json:"entries"`
+	}
+	// get-entries takes the index of the last entry, not the one after it.
+	path := fmt.Sprintf("get-entries?start=%d&end=%d", start, end-1)
+	if err := c.getJSON(ctx, path, &response); err != nil {
+		return nil, nil, err
+	}
+	if len(response.Entries) == 0 || uint64(len(response.Entries)) > end-start {
+		return nil, nil, fmt.Errorf("asked for %d entries from %d, got %d",
+			end-start, start, len(response.Entries))
+	}
+	for i, raw := range response.Entries {
+		entry, err := ParseLogEntry(raw.LeafInput, raw.ExtraData)
+		entries = append(entries, &certificatetransparency.EntryAndPosition{
+			Index: start + uint64(i),
+			Raw:   raw.LeafInput,
+			Entry: entry,
+		})
+		errs = append(errs, err)
+	}
+	return entries, errs, nil
+}
+
+// Parses the leaf_input (an RFC 6962 MerkleTreeLeaf) and extra_data of an
+// entry from get-entries. As in entries files, a precert entry's X509Cert is
+// the precertificate from extra_data, and ExtraCerts is the chain.
+func ParseLogEntry(leafInput []byte, extraData []byte) (*certificatetransparency.Entry, error) {
+	if len(leafInput) < 12 {
+		return nil, errors.New("truncated MerkleTreeLeaf")
+	}
+	if leafInput[0] != 0 || leafInput[1] != 0 {
+		return nil, fmt.Errorf("unsupported MerkleTreeLeaf version %d or leaf type %d",
+			leafInput[0], leafInput[1])
+	}
+	entry := &certificatetransparency.Entry{
+		Timestamp: binary.BigEndian.Uint64(leafInput[2:]),
+		Type:      certificatetransparency.LogEntryType(binary.BigEndian.Uint16(leafInput[10:])),
+	}
+	var chain []byte
+	var err error
+	switch entry.Type {
+	case certificatetransparency.X509Entry:
+		entry.X509Cert, _, err = readOpaque(leafInput[12:], 3)
+		if err != nil {
+			return nil, err
+		}
+		chain, _, err = readOpaque(extraData, 3)
+	case certificatetransparency.PreCertEntry:
+		entry.X509Cert, extraData, err = readOpaque(extraData, 3)
+		if err != nil {
+			return nil, err
+		}
+		chain, _, err = readOpaque(extraData, 3)
+	default:
+		return nil, fmt.Errorf("unknown entry type %d", entry.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for len(chain) > 0 {
+		var cert []byte
+		cert, chain, err = readOpaque(chain, 3)
+		if err != nil {
+			return nil, err
+		}
+		entry.ExtraCerts = append(entry.ExtraCerts, cert)
+	}
+	return entry, nil
+}
+
+// Follows a CT log as it grows, passing each new entry to a callback.
+type LogMonitor struct {
+	Client *LogClient
+	// The index of the next entry to fetch. Polling advances it past each
+	// entry passed on, so it's where to carry on from, even in a later run.
+	Next uint64
+	// If set, gets errors fetching entries.
+	Log *Logger
+}
+
+// Passes each entry added to the log since the last cycle to callback, in
+// order, and returns how many there were. If ctx is done, it stops before the
+// next entry, which the next cycle starts from.
+func (m *LogMonitor) PollOnce(ctx context.Context,
+	callback func(*certificatetransparency.EntryAndPosition, error)) (uint64, error) {
+	size, err := m.Client.TreeSize(ctx)
+	if err != nil {
+		return 0, err
+	}
+	processed := uint64(0)
+	for m.Next < size {
+		entries, errs, err := m.Client.GetEntries(ctx, m.Next, size)
+		if err != nil {
+			return processed, err
+		}
+		for i, ent := range entries {
+			if ctx.Err() != nil {
+				return processed, nil
+			}
+			callback(ent, errs[i])
+			m.Next++
+			processed++
+		}
+	}
+	return processed, nil
+}
+
+// Calls PollOnce every interval until ctx is done, so that entries are passed
+// to callback soon after they're logged without the log being asked for them
+// too often. A cycle that fails is logged, and the next one carries on from
+// where it stopped. If afterCycle isn't nil, it's called with Next after
+// each cycle, such as to save it.
+func (m *LogMonitor) Poll(ctx context.Context, interval time.Duration,
+	callback func(*certificatetransparency.EntryAndPosition, error),
+	afterCycle func(next uint64)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Once ctx is done, a tick may be ready too, and select could pick
+		// it, so check before starting a cycle and after finishing one.
+		if ctx.Err() != nil {
+			return
+		}
+		processed, err := m.PollOnce(ctx, callback)
+		if err != nil && ctx.Err() == nil {
+			m.Log.Warnf("Failed to fetch entries from %s: %s", m.Client.URL, err)
+		}
+		m.Log.Debugf("Processed %d new entries from %s", processed, m.Client.URL)
+		if afterCycle != nil {
+			afterCycle(m.Next)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
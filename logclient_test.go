@@ -0,0 +1,191 @@
+package sunlight
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/monicachew/certificatetransparency"
+	"github.com/mozkeeler/sunlight/internal/testcerts"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns value with a length prefix of lengthBytes bytes.
+func opaque(lengthBytes int, value []byte) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(value)))
+	return append(length[4-lengthBytes:], value...)
+}
+
+// Returns the leaf_input and extra_data of an X509 entry for der, logged at
+// timestamp with chain.
+func x509LogEntry(timestamp uint64, der []byte, chain ...[]byte) ([]byte, []byte) {
+	leaf := []byte{0, 0}
+	leaf = append(leaf, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(leaf[2:], timestamp)
+	leaf = append(leaf, 0, 0)
+	leaf = append(leaf, opaque(3, der)...)
+	leaf = append(leaf, 0, 0)
+	var certs []byte
+	for _, cert := range chain {
+		certs = append(certs, opaque(3, cert)...)
+	}
+	return leaf, opaque(3, certs)
+}
+
+// A fake CT log serving get-sth and get-entries, which returns at most two
+// entries at once.
+type fakeLog struct {
+	lock    sync.Mutex
+	entries [][2][]byte
+}
+
+func (l *fakeLog) add(leafInput []byte, extraData []byte) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, [2][]byte{leafInput, extraData})
+}
+
+func (l *fakeLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	switch r.URL.Path {
+	case "/ct/v1/get-sth":
+		json.NewEncoder(w).Encode(map[string]int{"tree_size": len(l.entries)})
+	case "/ct/v1/get-entries":
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		end, _ := strconv.Atoi(r.URL.Query().Get("end"))
+		if start > end || end >= len(l.entries) {
+			http.Error(w, fmt.Sprintf("bad range %d to %d", start, end),
+				http.StatusBadRequest)
+			return
+		}
+		if end > start+1 {
+			end = start + 1
+		}
+		type entry struct {
+			LeafInput []byte `json:"leaf_input"`
+			ExtraData []byte `json:"extra_data"`
+		}
+		var entries []entry
+		for _, e := range l.entries[start : end+1] {
+			entries = append(entries, entry{e[0], e[1]})
+		}
+		json.NewEncoder(w).Encode(map[string][]entry{"entries": entries})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestLogMonitor(t *testing.T) {
+	issuer := testcerts.New(t, testcerts.Spec{
+		Subject: pkix.Name{CommonName: "Test CA"},
+		IsCA:    true,
+	})
+	log := &fakeLog{}
+	server := httptest.NewServer(log)
+	defer server.Close()
+	addEntries := func(count int) {
+		for i := 0; i < count; i++ {
+			cert := testcerts.New(t, testcerts.Spec{
+				Subject: pkix.Name{CommonName: fmt.Sprintf("%d.example.com", i)},
+			})
+			log.add(x509LogEntry(uint64(1000+i), cert.Raw, issuer.Raw))
+		}
+	}
+
+	var indices []uint64
+	var entries []*certificatetransparency.Entry
+	callback := func(ent *certificatetransparency.EntryAndPosition, err error) {
+		if err != nil {
+			t.Errorf("Entry %d: unexpected error %s", ent.Index, err)
+			return
+		}
+		indices = append(indices, ent.Index)
+		entries = append(entries, ent.Entry)
+	}
+	monitor := &LogMonitor{Client: NewLogClient(server.URL + "/"), Next: 1}
+
+	// The log grows between polls, and only the new entries are processed,
+	// in however many batches it takes.
+	addEntries(4)
+	processed, err := monitor.PollOnce(context.Background(), callback)
+	if err != nil || processed != 3 || monitor.Next != 4 {
+		t.Fatalf("Expected entries 1 to 3, got %d up to %d (%v)", processed,
+			monitor.Next, err)
+	}
+	addEntries(3)
+	processed, err = monitor.PollOnce(context.Background(), callback)
+	if err != nil || processed != 3 || monitor.Next != 7 {
+		t.Fatalf("Expected entries 4 to 6, got %d up to %d (%v)", processed,
+			monitor.Next, err)
+	}
+	processed, err = monitor.PollOnce(context.Background(), callback)
+	if err != nil || processed != 0 {
+		t.Errorf("Expected no new entries, got %d (%v)", processed, err)
+	}
+	for i, index := range indices {
+		if index != uint64(i+1) {
+			t.Fatalf("Expected entries 1 to 6 in order, got %v", indices)
+		}
+	}
+	if len(entries) != 6 || entries[0].Timestamp != 1001 ||
+		len(entries[0].ExtraCerts) != 1 {
+		t.Fatalf("Unexpected entries %+v", entries)
+	}
+	cert, chain, err := ParseEntry(&certificatetransparency.EntryAndPosition{
+		Entry: entries[0]})
+	if err != nil || cert.Subject.CommonName != "1.example.com" ||
+		len(chain) != 1 || chain[0].Subject.CommonName != "Test CA" {
+		t.Errorf("Unexpected cert and chain from entry 1 (%v)", err)
+	}
+
+	// Polling until cancelled saves where it got to after each cycle.
+	addEntries(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	var saved []uint64
+	monitor.Poll(ctx, time.Millisecond, callback, func(next uint64) {
+		saved = append(saved, next)
+		cancel()
+	})
+	if len(saved) != 1 || saved[0] != 9 {
+		t.Errorf("Expected to have saved index 9, got %v", saved)
+	}
+}
+
+func TestParseLogEntry(t *testing.T) {
+	cert := testcerts.New(t, testcerts.Spec{})
+	// A precert entry's leaf has the issuer key hash and TBSCertificate, and
+	// its extra data the precertificate and chain.
+	leaf := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 42, 0, 1}
+	leaf = append(leaf, make([]byte, 32)...)
+	leaf = append(leaf, opaque(3, cert.RawTBSCertificate)...)
+	leaf = append(leaf, 0, 0)
+	extra := append(opaque(3, cert.Raw), opaque(3, opaque(3, cert.Raw))...)
+	entry, err := ParseLogEntry(leaf, extra)
+	if err != nil {
+		t.Fatal("could not parse precert entry", err)
+	}
+	if entry.Type != certificatetransparency.PreCertEntry ||
+		entry.Timestamp != 42 || string(entry.X509Cert) != string(cert.Raw) ||
+		len(entry.ExtraCerts) != 1 {
+		t.Errorf("Unexpected precert entry %+v", entry)
+	}
+
+	for _, leaf := range [][]byte{
+		{0, 0, 0},
+		{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2},
+		{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9},
+	} {
+		if _, err := ParseLogEntry(leaf, nil); err == nil {
+			t.Errorf("Expected an error parsing leaf %v", leaf)
+		}
+	}
+}
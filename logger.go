@@ -0,0 +1,77 @@
+package sunlight
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LOG_DEBUG LogLevel = iota
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (level LogLevel) String() string {
+	if level < LOG_DEBUG || level > LOG_ERROR {
+		return fmt.Sprintf("level%d", int(level))
+	}
+	return logLevelNames[level]
+}
+
+// Parses the name of a log level ("debug", "info", "warn" or "error").
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+	return LOG_INFO, fmt.Errorf("unknown log level %q (expected one of %s)",
+		name, strings.Join(logLevelNames, ", "))
+}
+
+// A Logger writes timestamped messages at or above its level to a writer. It
+// is safe to use concurrently. A nil *Logger discards everything.
+type Logger struct {
+	Level LogLevel
+	out   io.Writer
+	lock  sync.Mutex
+}
+
+func NewLogger(out io.Writer, level LogLevel) *Logger {
+	return &Logger{Level: level, out: out}
+}
+
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if l == nil || level < l.Level {
+		return
+	}
+	message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	fmt.Fprintf(l.out, "%s %s: %s\n", time.Now().Format(time.RFC3339),
+		strings.ToUpper(level.String()), message)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LOG_DEBUG, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(LOG_INFO, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logf(LOG_WARN, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LOG_ERROR, format, args...)
+}
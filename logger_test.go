@@ -0,0 +1,38 @@
+package sunlight
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevel(t *testing.T) {
+	level, err := ParseLogLevel("WARN")
+	if err != nil || level != LOG_WARN {
+		t.Fatalf("Expected to parse WARN as LOG_WARN, got %v, %v", level, err)
+	}
+	var out bytes.Buffer
+	logger := NewLogger(&out, level)
+	logger.Debugf("debug %d", 1)
+	logger.Infof("info %d", 2)
+	logger.Warnf("warn %d", 3)
+	logger.Errorf("error %d\n", 4)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines of output, got %q", out.String())
+	}
+	if !strings.HasSuffix(lines[0], " WARN: warn 3") {
+		t.Errorf("Unexpected warning line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], " ERROR: error 4") {
+		t.Errorf("Unexpected error line %q", lines[1])
+	}
+
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown log level")
+	}
+	// A nil logger discards everything rather than crashing.
+	var nilLogger *Logger
+	nilLogger.Errorf("dropped")
+}
@@ -0,0 +1,83 @@
+package sunlight
+
+import (
+	"sort"
+)
+
+// An issuer whose certs had a violation more often than a threshold allows,
+// over every month it was seen in.
+type Offender struct {
+	Issuer                  string
+	IssuerSha256Fingerprint string
+	Violation               string
+	// The number of the issuer's certs that had the violation, out of
+	// RawCount.
+	ViolatingCount uint64
+	RawCount       uint64
+	// ViolatingCount / RawCount
+	Rate float64
+}
+
+type issuerKey struct {
+	issuer      string
+	fingerprint string
+}
+
+// Returns the issuers that had more than threshold (in [0, 1]) of their certs
+// violate each of the given checks (or every violation, if checks is nil),
+// ordered by violation and then from the highest rate to the lowest. Counts
+// are summed over all of an issuer's reputations, which are per month, so the
+// reputations needn't have been finished.
+func FindOffenders(issuers []*IssuerReputation, checks map[string]bool,
+	threshold float64) []*Offender {
+	totals := make(map[issuerKey]uint64)
+	violating := make(map[issuerKey]map[string]uint64)
+	for _, issuer := range issuers {
+		key := issuerKey{issuer.Issuer, issuer.IssuerSha256Fingerprint}
+		totals[key] += issuer.RawCount
+		if violating[key] == nil {
+			violating[key] = make(map[string]uint64)
+		}
+		for name, score := range issuer.Scores {
+			violating[key][name] += score.ViolatingCount
+		}
+	}
+
+	offenders := make([]*Offender, 0)
+	for key, total := range totals {
+		for _, name := range violationNames() {
+			if checks != nil && !checks[name] {
+				continue
+			}
+			count := violating[key][name]
+			if total == 0 || count == 0 {
+				continue
+			}
+			rate := float64(count) / float64(total)
+			if rate > threshold {
+				offenders = append(offenders, &Offender{
+					Issuer:                  key.issuer,
+					IssuerSha256Fingerprint: key.fingerprint,
+					Violation:               name,
+					ViolatingCount:          count,
+					RawCount:                total,
+					Rate:                    rate,
+				})
+			}
+		}
+	}
+	sort.Slice(offenders, func(i, j int) bool {
+		a, b := offenders[i], offenders[j]
+		if a.Violation != b.Violation {
+			return a.Violation < b.Violation
+		}
+		if a.Rate != b.Rate {
+			return a.Rate > b.Rate
+		}
+		if a.Issuer != b.Issuer {
+			return a.Issuer < b.Issuer
+		}
+		return a.IssuerSha256Fingerprint < b.IssuerSha256Fingerprint
+	})
+	return offenders
+}
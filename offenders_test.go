@@ -0,0 +1,56 @@
+package sunlight
+
+import (
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestFindOffenders(t *testing.T) {
+	ts := uint64(1402580730123)
+	clean := NewIssuerReputation(pkix.Name{CommonName: "Clean CA"}, ts)
+	for i := 0; i < 100; i++ {
+		clean.Update(&CertSummary{
+			MaxReputation: -1,
+			Violations:    map[string]bool{DEPRECATED_SIGNATURE_ALGORITHM: i < 2},
+		})
+	}
+	// The offending issuer's certs are spread over two months, neither of
+	// which is over the threshold on its own.
+	var offending []*IssuerReputation
+	for month := uint64(0); month < 2; month++ {
+		issuer := NewIssuerReputation(pkix.Name{CommonName: "Offending CA"},
+			ts+month*31*24*60*60*1000)
+		for i := 0; i < 100; i++ {
+			issuer.Update(&CertSummary{
+				MaxReputation: -1,
+				Violations: map[string]bool{
+					DEPRECATED_SIGNATURE_ALGORITHM: i < 4+int(month)*4,
+					KEY_TOO_SHORT:                  i < 1,
+				},
+			})
+		}
+		offending = append(offending, issuer)
+	}
+	issuers := append(offending, clean)
+
+	offenders := FindOffenders(issuers,
+		map[string]bool{DEPRECATED_SIGNATURE_ALGORITHM: true}, 0.05)
+	if len(offenders) != 1 {
+		t.Fatalf("Expected 1 offender, got %d", len(offenders))
+	}
+	offender := offenders[0]
+	if offender.Issuer != "CN=Offending CA" ||
+		offender.Violation != DEPRECATED_SIGNATURE_ALGORITHM ||
+		offender.ViolatingCount != 12 || offender.RawCount != 200 ||
+		offender.Rate != 0.06 {
+		t.Errorf("Unexpected offender %+v", offender)
+	}
+
+	// With every check considered, both issuers' SHA-1 rates are above 1%,
+	// and the offending issuer's short keys aren't.
+	offenders = FindOffenders(issuers, nil, 0.01)
+	if len(offenders) != 2 || offenders[0].Issuer != "CN=Offending CA" ||
+		offenders[1].Issuer != "CN=Clean CA" {
+		t.Errorf("Unexpected offenders %+v", offenders)
+	}
+}
@@ -0,0 +1,122 @@
+package sunlight
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A Ranker rates domains by popularity. *alexa.AlexaRank is one.
+type Ranker interface {
+	// Returns a reputation in [0, 1] for host, with more popular domains
+	// closer to 1, or -1 if host isn't ranked.
+	GetReputation(host string) (float32, error)
+}
+
+// The formats of domain ranking lists that can be read.
+const (
+	RANK_FORMAT_ALEXA    = "alexa"
+	RANK_FORMAT_TRANCO   = "tranco"
+	RANK_FORMAT_UMBRELLA = "umbrella"
+)
+
+// A Ranker backed by a list of <rank, domain> rows, such as the Tranco or
+// Cisco Umbrella top million lists.
+type ListRanker struct {
+	ranks map[string]int
+	// The lowest (numerically highest) rank in the list.
+	lowest int
+}
+
+// Reads a ranking list in the given format, which must be RANK_FORMAT_TRANCO
+// or RANK_FORMAT_UMBRELLA. Both are CSV with the rank first and the domain
+// second, but Tranco lists may start with a "rank,domain" header and Umbrella
+// lists have CRLF line endings and can rank names with a trailing dot. If a
+// domain is listed more than once, its best rank is kept.
+func ReadRankList(r io.Reader, format string) (*ListRanker, error) {
+	if format != RANK_FORMAT_TRANCO && format != RANK_FORMAT_UMBRELLA {
+		return nil, fmt.Errorf("unsupported ranking list format %q", format)
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	ranker := &ListRanker{ranks: make(map[string]int)}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected <rank, domain>", line)
+		}
+		rank, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			if line == 1 && format == RANK_FORMAT_TRANCO {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid rank %q", line, record[0])
+		}
+		if rank < 1 {
+			return nil, fmt.Errorf("line %d: invalid rank %d", line, rank)
+		}
+		domain := NormalizeDNSName(strings.TrimSpace(record[1]))
+		if current, ok := ranker.ranks[domain]; !ok || rank < current {
+			ranker.ranks[domain] = rank
+		}
+		if rank > ranker.lowest {
+			ranker.lowest = rank
+		}
+	}
+	return ranker, nil
+}
+
+// Returns 1 for the top ranked domain, falling linearly towards 0 for the
+// lowest ranked one, or -1 if host isn't in the list.
+func (ranker *ListRanker) GetReputation(host string) (float32, error) {
+	rank, ok := ranker.ranks[NormalizeDNSName(host)]
+	if !ok {
+		return -1, nil
+	}
+	return 1.0 - float32(rank-1)/float32(ranker.lowest), nil
+}
+
+// A Ranker that remembers the reputation of each host it's asked about, since
+// the same names turn up in many certs. It's safe to use concurrently, and
+// keeps everything for as long as it's around, so use one per run.
+type CachingRanker struct {
+	ranker Ranker
+	// The number of lookups passed on to the underlying Ranker.
+	Lookups uint64
+	cache   map[string]float32
+	lock    sync.RWMutex
+}
+
+func NewCachingRanker(ranker Ranker) *CachingRanker {
+	return &CachingRanker{ranker: ranker, cache: make(map[string]float32)}
+}
+
+// Errors from the underlying Ranker aren't cached, so the lookup is tried
+// again next time.
+func (ranker *CachingRanker) GetReputation(host string) (float32, error) {
+	ranker.lock.RLock()
+	reputation, ok := ranker.cache[host]
+	ranker.lock.RUnlock()
+	if ok {
+		return reputation, nil
+	}
+	atomic.AddUint64(&ranker.Lookups, 1)
+	reputation, err := ranker.ranker.GetReputation(host)
+	if err != nil {
+		return reputation, err
+	}
+	ranker.lock.Lock()
+	ranker.cache[host] = reputation
+	ranker.lock.Unlock()
+	return reputation, nil
+}
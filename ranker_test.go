@@ -0,0 +1,139 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+)
+
+const trancoSample = "rank,domain\n1,google.com\n2,Facebook.com\n4,example.com\n"
+
+const umbrellaSample = "1,google.com\r\n2,www.facebook.com.\r\n4,example.com\r\n"
+
+func TestReadRankList(t *testing.T) {
+	for format, sample := range map[string]string{
+		RANK_FORMAT_TRANCO:   trancoSample,
+		RANK_FORMAT_UMBRELLA: umbrellaSample,
+	} {
+		ranker, err := ReadRankList(strings.NewReader(sample), format)
+		if err != nil {
+			t.Errorf("%s: could not read sample: %s", format, err)
+			continue
+		}
+		for host, expected := range map[string]float32{
+			"google.com":         1,
+			"EXAMPLE.com":        0.25,
+			"unranked.example":   -1,
+			"www.unranked.test.": -1,
+		} {
+			if reputation, _ := ranker.GetReputation(host); reputation != expected {
+				t.Errorf("%s: expected %s to have reputation %f, got %f", format,
+					host, expected, reputation)
+			}
+		}
+	}
+
+	ranker, _ := ReadRankList(strings.NewReader(umbrellaSample), RANK_FORMAT_UMBRELLA)
+	if reputation, _ := ranker.GetReputation("www.facebook.com"); reputation != 0.75 {
+		t.Errorf("Expected the trailing dot to be ignored, got %f", reputation)
+	}
+}
+
+func TestReadRankListErrors(t *testing.T) {
+	// Only Tranco lists have a header.
+	if _, err := ReadRankList(strings.NewReader(trancoSample), RANK_FORMAT_UMBRELLA); err == nil {
+		t.Error("Expected an error for a header in an Umbrella list")
+	}
+	if _, err := ReadRankList(strings.NewReader("1\n"), RANK_FORMAT_TRANCO); err == nil {
+		t.Error("Expected an error for a row without a domain")
+	}
+	if _, err := ReadRankList(strings.NewReader(trancoSample), RANK_FORMAT_ALEXA); err == nil {
+		t.Error("Expected an error for the Alexa format")
+	}
+}
+
+func TestCertSummaryUsesRanker(t *testing.T) {
+	ranker, err := ReadRankList(strings.NewReader(trancoSample), RANK_FORMAT_TRANCO)
+	if err != nil {
+		t.Fatal("could not read sample", err)
+	}
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"example.com", "google.com"},
+	})
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, ranker, nil, nil, nil)
+	if summary.MaxReputation != 1 {
+		t.Errorf("Expected the best ranked name to count, got %f", summary.MaxReputation)
+	}
+}
+
+// Counts the lookups made of the Ranker it wraps.
+type countingRanker struct {
+	Ranker
+	lookups int
+}
+
+func (ranker *countingRanker) GetReputation(host string) (float32, error) {
+	ranker.lookups++
+	return ranker.Ranker.GetReputation(host)
+}
+
+func TestCachingRanker(t *testing.T) {
+	list, err := ReadRankList(strings.NewReader(trancoSample), RANK_FORMAT_TRANCO)
+	if err != nil {
+		t.Fatal("could not read sample", err)
+	}
+	counter := &countingRanker{Ranker: list}
+	ranker := NewCachingRanker(counter)
+	for i := 0; i < 3; i++ {
+		for host, expected := range map[string]float32{
+			"google.com":       1,
+			"unranked.example": -1,
+		} {
+			if reputation, _ := ranker.GetReputation(host); reputation != expected {
+				t.Errorf("Expected %s to have reputation %f, got %f", host,
+					expected, reputation)
+			}
+		}
+	}
+	if counter.lookups != 2 || ranker.Lookups != 2 {
+		t.Errorf("Expected 2 lookups, got %d (%d counted)", counter.lookups,
+			ranker.Lookups)
+	}
+}
+
+// Summarizes the same cert repeatedly, as happens when a site's certs are
+// renewed, reporting how many lookups reach the underlying ranker per cert.
+func benchmarkRankerLookups(b *testing.B, cache bool) {
+	list, err := ReadRankList(strings.NewReader(trancoSample), RANK_FORMAT_TRANCO)
+	if err != nil {
+		b.Fatal("could not read sample", err)
+	}
+	counter := &countingRanker{Ranker: list}
+	var ranker Ranker = counter
+	if cache {
+		ranker = NewCachingRanker(counter)
+	}
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com", "www.example.com", "google.com"},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CalculateCertSummary(cert, 0, 0, false, ranker, nil, nil, nil)
+	}
+	b.ReportMetric(float64(counter.lookups)/float64(b.N), "lookups/op")
+}
+
+func BenchmarkRankerLookups(b *testing.B) {
+	benchmarkRankerLookups(b, false)
+}
+
+func BenchmarkCachingRankerLookups(b *testing.B) {
+	benchmarkRankerLookups(b, true)
+}
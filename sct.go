@@ -0,0 +1,349 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// The extension embedding a SignedCertificateTimestampList in a cert
+// (RFC 6962 section 3.3).
+var sctListOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// The critical extension that makes a precertificate unusable as a cert
+// (RFC 6962 section 3.1).
+var ctPoisonOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// Values from RFC 6962 and RFC 5246 used when verifying SCTs.
+const (
+	sctVersionV1            = 0
+	certificateTimestamp    = 0
+	precertLogEntryType     = 1
+	hashAlgorithmSHA256     = 4
+	signatureAlgorithmRSA   = 1
+	signatureAlgorithmECDSA = 3
+)
+
+// A SignedCertificateTimestamp as defined in RFC 6962 section 3.2.
+type SignedCertificateTimestamp struct {
+	Version            uint8
+	LogID              [32]byte
+	Timestamp          uint64
+	Extensions         []byte
+	HashAlgorithm      uint8
+	SignatureAlgorithm uint8
+	Signature          []byte
+}
+
+// The structure of a TBSCertificate (RFC 5280 section 4.1). Everything but
+// the extensions is kept in its original encoding so that re-encoding it
+// doesn't change any bytes.
+type tbsCertificate struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       asn1.RawValue
+	SignatureAlgorithm asn1.RawValue
+	Issuer             asn1.RawValue
+	Validity           asn1.RawValue
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	UniqueId           asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueId    asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions         []pkix.Extension `asn1:"omitempty,optional,explicit,tag:3"`
+}
+
+// Reads a TLS-style length-prefixed opaque value with a length of
+// lengthBytes bytes from the start of data, returning it and the rest.
+func readOpaque(data []byte, lengthBytes int) ([]byte, []byte, error) {
+	if len(data) < lengthBytes {
+		return nil, nil, errors.New("truncated length")
+	}
+	length := 0
+	for _, b := range data[:lengthBytes] {
+		length = length<<8 | int(b)
+	}
+	data = data[lengthBytes:]
+	if len(data) < length {
+		return nil, nil, errors.New("truncated value")
+	}
+	return data[:length], data[length:], nil
+}
+
+func parseSCT(data []byte) (*SignedCertificateTimestamp, error) {
+	sct := new(SignedCertificateTimestamp)
+	if len(data) < 1+32+8 {
+		return nil, errors.New("truncated SCT")
+	}
+	sct.Version = data[0]
+	if sct.Version != sctVersionV1 {
+		return nil, fmt.Errorf("unsupported SCT version %d", sct.Version)
+	}
+	copy(sct.LogID[:], data[1:33])
+	sct.Timestamp = binary.BigEndian.Uint64(data[33:41])
+	extensions, rest, err := readOpaque(data[41:], 2)
+	if err != nil {
+		return nil, err
+	}
+	sct.Extensions = extensions
+	if len(rest) < 2 {
+		return nil, errors.New("truncated SCT signature")
+	}
+	sct.HashAlgorithm = rest[0]
+	sct.SignatureAlgorithm = rest[1]
+	signature, rest, err := readOpaque(rest[2:], 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after SCT")
+	}
+	sct.Signature = signature
+	return sct, nil
+}
+
+// Returns the SCTs embedded in cert, if any.
+func ParseEmbeddedSCTs(cert *x509.Certificate) ([]*SignedCertificateTimestamp, error) {
+	var scts []*SignedCertificateTimestamp
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(sctListOID) {
+			continue
+		}
+		var sctList []byte
+		rest, err := asn1.Unmarshal(ext.Value, &sctList)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 {
+			return nil, errors.New("trailing data after SCT list")
+		}
+		list, rest, err := readOpaque(sctList, 2)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 {
+			return nil, errors.New("trailing data after SCT list")
+		}
+		for len(list) > 0 {
+			var data []byte
+			data, list, err = readOpaque(list, 2)
+			if err != nil {
+				return nil, err
+			}
+			sct, err := parseSCT(data)
+			if err != nil {
+				return nil, err
+			}
+			scts = append(scts, sct)
+		}
+	}
+	return scts, nil
+}
+
+// The CT policies of Chrome and Apple require SCTs from this many distinct
+// logs for certs valid for at most SHORT_LIVED_SCT_DAYS, and from MIN_SCTS
+// logs for longer-lived certs, and that the logs have at least
+// MIN_SCT_OPERATORS operators between them.
+const (
+	MIN_SCTS_SHORT_LIVED = 2
+	MIN_SCTS             = 3
+	SHORT_LIVED_SCT_DAYS = 180
+	MIN_SCT_OPERATORS    = 2
+)
+
+// Returns how many distinct logs cert needs SCTs from.
+func requiredSCTs(cert *x509.Certificate) int {
+	if cert.NotAfter.Sub(cert.NotBefore) <= SHORT_LIVED_SCT_DAYS*24*time.Hour {
+		return MIN_SCTS_SHORT_LIVED
+	}
+	return MIN_SCTS
+}
+
+// Returns the sorted, distinct base64 IDs of the logs that issued scts, and
+// how many distinct operators they have according to operators, with each
+// log that isn't in operators counting as an operator of its own.
+func sctLogs(scts []*SignedCertificateTimestamp,
+	operators map[[32]byte]string) ([]string, int) {
+	if len(scts) == 0 {
+		return nil, 0
+	}
+	logs := make(map[string]bool)
+	// Operators and unlisted logs are prefixed so that they can't clash.
+	operatorSet := make(map[string]bool)
+	for _, sct := range scts {
+		id := base64.StdEncoding.EncodeToString(sct.LogID[:])
+		logs[id] = true
+		if operator, ok := operators[sct.LogID]; ok {
+			operatorSet["operator:"+operator] = true
+		} else {
+			operatorSet["log:"+id] = true
+		}
+	}
+	return sortedKeys(logs), len(operatorSet)
+}
+
+// Returns cert's TBSCertificate with the embedded SCT extension removed,
+// which is what the log signed when it issued the SCTs.
+func precertTBS(cert *x509.Certificate) ([]byte, error) {
+	var tbs tbsCertificate
+	rest, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after TBSCertificate")
+	}
+	extensions := make([]pkix.Extension, 0, len(tbs.Extensions))
+	for _, ext := range tbs.Extensions {
+		if !ext.Id.Equal(sctListOID) {
+			extensions = append(extensions, ext)
+		}
+	}
+	tbs.Raw = nil
+	tbs.Extensions = extensions
+	return asn1.Marshal(tbs)
+}
+
+// Returns the digitally-signed struct from RFC 6962 section 3.2 that a log
+// signs when issuing sct for the precert with the given TBSCertificate.
+func sctSignedData(sct *SignedCertificateTimestamp, issuer *x509.Certificate,
+	tbs []byte) []byte {
+	var signed bytes.Buffer
+	signed.WriteByte(sctVersionV1)
+	signed.WriteByte(certificateTimestamp)
+	binary.Write(&signed, binary.BigEndian, sct.Timestamp)
+	binary.Write(&signed, binary.BigEndian, uint16(precertLogEntryType))
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	signed.Write(issuerKeyHash[:])
+	signed.Write([]byte{byte(len(tbs) >> 16), byte(len(tbs) >> 8), byte(len(tbs))})
+	signed.Write(tbs)
+	binary.Write(&signed, binary.BigEndian, uint16(len(sct.Extensions)))
+	signed.Write(sct.Extensions)
+	return signed.Bytes()
+}
+
+// Verifies that sct, embedded in cert, was signed by the log with the given
+// public key. issuer is the cert that issued cert.
+func (sct *SignedCertificateTimestamp) Verify(cert *x509.Certificate,
+	issuer *x509.Certificate, key crypto.PublicKey) error {
+	tbs, err := precertTBS(cert)
+	if err != nil {
+		return err
+	}
+	if len(tbs) >= 1<<24 {
+		return errors.New("TBSCertificate too long")
+	}
+	if sct.HashAlgorithm != hashAlgorithmSHA256 {
+		return fmt.Errorf("unsupported SCT hash algorithm %d", sct.HashAlgorithm)
+	}
+	digest := sha256.Sum256(sctSignedData(sct, issuer, tbs))
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if sct.SignatureAlgorithm != signatureAlgorithmECDSA {
+			return errors.New("SCT signature algorithm doesn't match log key")
+		}
+		if !ecdsa.VerifyASN1(key, digest[:], sct.Signature) {
+			return errors.New("invalid SCT signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if sct.SignatureAlgorithm != signatureAlgorithmRSA {
+			return errors.New("SCT signature algorithm doesn't match log key")
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sct.Signature)
+	}
+	return fmt.Errorf("unsupported log key type %T", key)
+}
+
+// Returns the ID of the CT log with the given public key: the SHA-256 hash
+// of its DER-encoded SubjectPublicKeyInfo.
+func CTLogID(key crypto.PublicKey) ([32]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(der), nil
+}
+
+// Reads the PEM-encoded public keys of CT logs in filename, returning them
+// keyed on log ID.
+func ReadCTLogKeys(filename string) (map[[32]byte]crypto.PublicKey, error) {
+	pemBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[[32]byte]crypto.PublicKey)
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		id, err := CTLogID(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// Reads a list of CT logs in the JSON format of Chrome's (version 3), as at
+// https://www.gstatic.com/ct/log_list/v3/log_list.json, returning the public
+// keys and operators of the logs, keyed on log ID.
+func ReadCTLogList(r io.Reader) (map[[32]byte]crypto.PublicKey,
+	map[[32]byte]string, error) {
+	type log struct {
+		LogID []byte `json:"log_id"`
+		Key   []byte `json:"key"`
+	}
+	var list struct {
+		Operators []struct {
+			Name      string `json:"name"`
+			Logs      []log  `json:"logs"`
+			TiledLogs []log  `json:"tiled_logs"`
+		} `json:"operators"`
+	}
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return nil, nil, err
+	}
+	keys := make(map[[32]byte]crypto.PublicKey)
+	operators := make(map[[32]byte]string)
+	for _, operator := range list.Operators {
+		for _, log := range append(operator.Logs, operator.TiledLogs...) {
+			key, err := x509.ParsePKIXPublicKey(log.Key)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid key for a log of %s: %s",
+					operator.Name, err)
+			}
+			id, err := CTLogID(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !bytes.Equal(id[:], log.LogID) {
+				return nil, nil, fmt.Errorf("log ID %s of a log of %s doesn't match its key",
+					base64.StdEncoding.EncodeToString(log.LogID), operator.Name)
+			}
+			keys[id] = key
+			operators[id] = operator.Name
+		}
+	}
+	return keys, operators, nil
+}
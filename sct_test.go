@@ -0,0 +1,261 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Encodes scts as the value of an embedded SCT list extension.
+func marshalSCTList(t *testing.T, scts ...*SignedCertificateTimestamp) []byte {
+	var list bytes.Buffer
+	for _, sct := range scts {
+		var encoded bytes.Buffer
+		encoded.WriteByte(sct.Version)
+		encoded.Write(sct.LogID[:])
+		binary.Write(&encoded, binary.BigEndian, sct.Timestamp)
+		binary.Write(&encoded, binary.BigEndian, uint16(len(sct.Extensions)))
+		encoded.Write(sct.Extensions)
+		encoded.WriteByte(sct.HashAlgorithm)
+		encoded.WriteByte(sct.SignatureAlgorithm)
+		binary.Write(&encoded, binary.BigEndian, uint16(len(sct.Signature)))
+		encoded.Write(sct.Signature)
+		binary.Write(&list, binary.BigEndian, uint16(encoded.Len()))
+		list.Write(encoded.Bytes())
+	}
+	var value bytes.Buffer
+	binary.Write(&value, binary.BigEndian, uint16(list.Len()))
+	value.Write(list.Bytes())
+	der, err := asn1.Marshal(value.Bytes())
+	if err != nil {
+		t.Fatal("could not encode SCT list", err)
+	}
+	return der
+}
+
+// Returns an SCT from the log with the given key for the precert, issued by
+// issuer, that template will be turned into.
+func makeSCT(t *testing.T, logKey *ecdsa.PrivateKey, template *x509.Certificate,
+	issuer *x509.Certificate, timestamp uint64) *SignedCertificateTimestamp {
+	logID, err := CTLogID(&logKey.PublicKey)
+	if err != nil {
+		t.Fatal("could not compute log ID", err)
+	}
+	precert := issueCert(t, template, issuer, &testKey.PublicKey)
+	sct := &SignedCertificateTimestamp{
+		LogID:              logID,
+		Timestamp:          timestamp,
+		HashAlgorithm:      hashAlgorithmSHA256,
+		SignatureAlgorithm: signatureAlgorithmECDSA,
+	}
+	digest := sha256.Sum256(sctSignedData(sct, issuer, precert.RawTBSCertificate))
+	sct.Signature, err = ecdsa.SignASN1(rand.Reader, logKey, digest[:])
+	if err != nil {
+		t.Fatal("could not sign SCT", err)
+	}
+	return sct
+}
+
+func TestEmbeddedSCTs(t *testing.T) {
+	logged := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	ts := uint64(logged.Unix()) * 1000
+	logKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	logID, _ := CTLogID(&logKey.PublicKey)
+	config := &RuleConfig{
+		CTLogKeys: map[[32]byte]crypto.PublicKey{logID: &logKey.PublicKey},
+	}
+	issuer := makeCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             logged.AddDate(-1, 0, 0),
+		NotAfter:              logged.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "sct.example.com"},
+		NotBefore:    logged,
+		NotAfter:     logged.AddDate(1, 0, 0),
+		DNSNames:     []string{"sct.example.com"},
+	}
+	sct := makeSCT(t, logKey, template, issuer, ts)
+	template.ExtraExtensions = []pkix.Extension{
+		{Id: sctListOID, Value: marshalSCTList(t, sct)},
+	}
+	cert := issueCert(t, template, issuer, &testKey.PublicKey)
+	chain := []*x509.Certificate{issuer}
+
+	summary, _ := CalculateCertSummary(cert, 0, ts, false, nil, chain, nil, config)
+	if summary.EmbeddedSCTCount != 1 {
+		t.Errorf("Expected 1 embedded SCT, got %d", summary.EmbeddedSCTCount)
+	}
+	if summary.Violations[SCT_SIGNATURE_INVALID] {
+		t.Error("Valid SCT should verify")
+	}
+
+	// An SCT whose signature doesn't match the cert.
+	sct.Timestamp += 1
+	template.ExtraExtensions = []pkix.Extension{
+		{Id: sctListOID, Value: marshalSCTList(t, sct)},
+	}
+	badCert := issueCert(t, template, issuer, &testKey.PublicKey)
+	summary, _ = CalculateCertSummary(badCert, 0, ts, false, nil, chain, nil, config)
+	if !summary.Violations[SCT_SIGNATURE_INVALID] {
+		t.Error("SCT with a bad signature should be flagged")
+	}
+
+	// Without the log's key the SCT can't be checked at all.
+	summary, _ = CalculateCertSummary(badCert, 0, ts, false, nil, chain, nil, nil)
+	if summary.EmbeddedSCTCount != 1 || summary.Violations[SCT_SIGNATURE_INVALID] {
+		t.Error("SCT from an unknown log should be counted but not flagged")
+	}
+}
+
+func TestPoisonOnFinalCert(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "poison.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"poison.example.com"},
+		ExtraExtensions: []pkix.Extension{
+			{Id: ctPoisonOID, Critical: true, Value: []byte{0x05, 0x00}},
+		},
+	})
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	if !summary.Violations[POISON_ON_FINAL_CERT] {
+		t.Error("Final cert with the poison extension should be flagged")
+	}
+	summary, _ = CalculateCertSummary(cert, 0, 0, true, nil, nil, nil, nil)
+	if summary.Violations[POISON_ON_FINAL_CERT] {
+		t.Error("Precert with the poison extension shouldn't be flagged")
+	}
+}
+
+func TestSCTLogs(t *testing.T) {
+	logged := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	ts := uint64(logged.Unix()) * 1000
+	firstKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secondKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	firstID, _ := CTLogID(&firstKey.PublicKey)
+	secondID, _ := CTLogID(&secondKey.PublicKey)
+	expectedIDs := []string{base64.StdEncoding.EncodeToString(firstID[:]),
+		base64.StdEncoding.EncodeToString(secondID[:])}
+	sort.Strings(expectedIDs)
+	issuer := makeCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             logged.AddDate(-1, 0, 0),
+		NotAfter:              logged.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	// Returns a cert valid for the given number of days with SCTs from both
+	// logs.
+	issue := func(days int) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "sct.example.com"},
+			NotBefore:    logged,
+			NotAfter:     logged.AddDate(0, 0, days),
+			DNSNames:     []string{"sct.example.com"},
+		}
+		scts := []*SignedCertificateTimestamp{
+			makeSCT(t, firstKey, template, issuer, ts),
+			makeSCT(t, secondKey, template, issuer, ts),
+		}
+		template.ExtraExtensions = []pkix.Extension{
+			{Id: sctListOID, Value: marshalSCTList(t, scts...)},
+		}
+		return issueCert(t, template, issuer, &testKey.PublicKey)
+	}
+	chain := []*x509.Certificate{issuer}
+	differentOperators := &RuleConfig{
+		CTLogOperators: map[[32]byte]string{firstID: "Operator A", secondID: "Operator B"},
+	}
+	sameOperator := &RuleConfig{
+		CTLogOperators: map[[32]byte]string{firstID: "Operator A", secondID: "Operator A"},
+	}
+
+	for _, test := range []struct {
+		description  string
+		days         int
+		config       *RuleConfig
+		operators    int
+		insufficient bool
+	}{
+		{"short-lived, different operators", 90, differentOperators, 2, false},
+		{"short-lived, unknown operators", 90, nil, 2, false},
+		{"short-lived, one operator", 90, sameOperator, 1, true},
+		{"long-lived, different operators", 365, differentOperators, 2, true},
+	} {
+		summary, _ := CalculateCertSummary(issue(test.days), 0, ts, false, nil,
+			chain, nil, test.config)
+		if summary.EmbeddedSCTCount != 2 ||
+			!reflect.DeepEqual(summary.SCTLogIDs, expectedIDs) ||
+			summary.SCTOperatorCount != test.operators {
+			t.Errorf("%s: expected 2 SCTs from logs %v with %d operators, got %d from %v with %d",
+				test.description, expectedIDs, test.operators,
+				summary.EmbeddedSCTCount, summary.SCTLogIDs, summary.SCTOperatorCount)
+		}
+		if summary.Violations[INSUFFICIENT_SCTS] != test.insufficient {
+			t.Errorf("%s: expected InsufficientSCTs %t", test.description,
+				test.insufficient)
+		}
+	}
+
+	// Without embedded SCTs, the cert may have got them to clients another
+	// way.
+	summary, _ := CalculateCertSummary(issuer, 0, ts, false, nil, nil, nil, nil)
+	if summary.SCTLogIDs != nil || summary.Violations[INSUFFICIENT_SCTS] {
+		t.Errorf("Expected no SCT logs and no violation, got %v", summary.SCTLogIDs)
+	}
+}
+
+func TestReadCTLogList(t *testing.T) {
+	logKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	logID, _ := CTLogID(&logKey.PublicKey)
+	der, err := x509.MarshalPKIXPublicKey(&logKey.PublicKey)
+	if err != nil {
+		t.Fatal("could not marshal log key", err)
+	}
+	list := fmt.Sprintf(`{"version": "1.0", "operators": [
+		{"name": "Operator A", "email": [], "logs": [
+			{"description": "A log", "log_id": %q, "key": %q, "mmd": 86400}
+		]}
+	]}`, base64.StdEncoding.EncodeToString(logID[:]),
+		base64.StdEncoding.EncodeToString(der))
+	keys, operators, err := ReadCTLogList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal("could not read log list", err)
+	}
+	if len(keys) != 1 || keys[logID] == nil || len(operators) != 1 ||
+		operators[logID] != "Operator A" {
+		t.Errorf("Unexpected keys %v and operators %v", keys, operators)
+	}
+
+	// A log ID that isn't that of the key is an error.
+	wrongID := strings.Replace(list, base64.StdEncoding.EncodeToString(logID[:]),
+		base64.StdEncoding.EncodeToString(make([]byte, 32)), 1)
+	if _, _, err := ReadCTLogList(strings.NewReader(wrongID)); err == nil {
+		t.Error("Expected an error for a mismatched log ID")
+	}
+}
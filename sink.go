@@ -0,0 +1,233 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Sink records the summaries of violating certs. Write may be called
+// concurrently, and nothing may be written after Close.
+type Sink interface {
+	Write(summary *CertSummary, cert *x509.Certificate) error
+	Close() error
+}
+
+// Adapts a function to a Sink that has nothing to do on Close.
+type SinkFunc func(summary *CertSummary, cert *x509.Certificate) error
+
+func (f SinkFunc) Write(summary *CertSummary, cert *x509.Certificate) error {
+	return f(summary, cert)
+}
+
+func (f SinkFunc) Close() error { return nil }
+
+type multiSink []Sink
+
+// Returns a Sink that writes to each of sinks in turn. A sink failing doesn't
+// stop the others from being written to or closed; the first error is
+// returned.
+func NewMultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (sinks multiSink) Write(summary *CertSummary, cert *x509.Certificate) error {
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.Write(summary, cert); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (sinks multiSink) Close() error {
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Writes summaries to a writer as a JSON object {"Certs": [...]}.
+type jsonSink struct {
+	out   io.Writer
+	lock  sync.Mutex
+	first bool
+}
+
+// Returns a Sink writing a JSON object {"Certs": [...]} to out. Closing it
+// finishes the object, but doesn't close out. Each summary marshals the same
+// way every time, but certs are in the order they're written, which only
+// stays the same from run to run if entries are processed one at a time.
+func NewJSONSink(out io.Writer) Sink {
+	fmt.Fprintf(out, "{\"Certs\":[")
+	return &jsonSink{out: out, first: true}
+}
+
+func (w *jsonSink) Write(summary *CertSummary, cert *x509.Certificate) error {
+	marshalled, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("couldn't write json: %s", err)
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	separator := ",\n"
+	if w.first {
+		separator = "\n"
+	}
+	w.first = false
+	if _, err = fmt.Fprintf(w.out, "%s%s", separator, marshalled); err != nil {
+		return fmt.Errorf("couldn't write json: %s", err)
+	}
+	return nil
+}
+
+func (w *jsonSink) Close() error {
+	_, err := fmt.Fprintf(w.out, "]}\n")
+	return err
+}
+
+// Writes summaries to a writer as newline-delimited JSON.
+type ndjsonSink struct {
+	out  io.Writer
+	lock sync.Mutex
+}
+
+// Returns a Sink writing each summary to out as a line of JSON, which unlike
+// NewJSONSink's output can be processed as it's written. Closing it doesn't
+// close out.
+func NewNDJSONSink(out io.Writer) Sink {
+	return &ndjsonSink{out: out}
+}
+
+func (w *ndjsonSink) Write(summary *CertSummary, cert *x509.Certificate) error {
+	marshalled, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("couldn't write json: %s", err)
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, err = fmt.Fprintf(w.out, "%s\n", marshalled); err != nil {
+		return fmt.Errorf("couldn't write json: %s", err)
+	}
+	return nil
+}
+
+func (w *ndjsonSink) Close() error { return nil }
+
+// The columns written by NewCSVSink.
+var CSVColumns = []string{"logIndex", "timestamp", "cn", "issuer",
+	"sha256Fingerprint", "notBefore", "notAfter", "dnsNames", "violations"}
+
+// Writes summaries to a writer as CSV.
+type csvSink struct {
+	out  *csv.Writer
+	lock sync.Mutex
+}
+
+// Returns a Sink writing a CSV row for each summary to out, after a header of
+// CSVColumns. DNS names and the names of the violations found are separated
+// by spaces, and times are RFC 3339. Closing it flushes the output, but
+// doesn't close out.
+func NewCSVSink(out io.Writer) (Sink, error) {
+	w := &csvSink{out: csv.NewWriter(out)}
+	if err := w.out.Write(CSVColumns); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvSink) Write(summary *CertSummary, cert *x509.Certificate) error {
+	var violations []string
+	for _, name := range violationNames() {
+		if summary.Violations[name] {
+			violations = append(violations, name)
+		}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.out.Write([]string{
+		strconv.FormatUint(summary.LogIndex, 10),
+		strconv.FormatUint(summary.Timestamp, 10),
+		summary.CN,
+		summary.Issuer,
+		summary.Sha256Fingerprint,
+		summary.NotBeforeTime.Format(time.RFC3339),
+		summary.NotAfterTime.Format(time.RFC3339),
+		strings.Join(summary.DnsNames, " "),
+		strings.Join(violations, " "),
+	})
+}
+
+func (w *csvSink) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.out.Flush()
+	return w.out.Error()
+}
+
+// Formats NewSink can write summaries in.
+const (
+	OUTPUT_FORMAT_JSON   = "json"
+	OUTPUT_FORMAT_NDJSON = "ndjson"
+	OUTPUT_FORMAT_CSV    = "csv"
+)
+
+// Returns a Sink writing summaries to out in format, which must be one of
+// OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_NDJSON or OUTPUT_FORMAT_CSV, as
+// NewJSONSink, NewNDJSONSink or NewCSVSink would.
+func NewSink(out io.Writer, format string) (Sink, error) {
+	switch format {
+	case OUTPUT_FORMAT_JSON:
+		return NewJSONSink(out), nil
+	case OUTPUT_FORMAT_NDJSON:
+		return NewNDJSONSink(out), nil
+	case OUTPUT_FORMAT_CSV:
+		return NewCSVSink(out)
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// Writes summaries to out in format, as NewSink's Sink would, and finishes
+// the output. It doesn't close out, so out can be anything from a file to a
+// bytes.Buffer.
+func WriteSummaries(out io.Writer, format string, summaries []*CertSummary) error {
+	sink, err := NewSink(out, format)
+	if err != nil {
+		return err
+	}
+	for _, summary := range summaries {
+		if err := sink.Write(summary, nil); err != nil {
+			sink.Close()
+			return err
+		}
+	}
+	return sink.Close()
+}
+
+// Writes finished issuer reputations to out as a JSON array, ordered by issuer
+// and then month so that runs over the same entries give the same output.
+func WriteIssuerJSON(out io.Writer, issuers []*IssuerReputation) error {
+	sorted := make([]*IssuerReputation, len(issuers))
+	copy(sorted, issuers)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Issuer != sorted[j].Issuer {
+			return sorted[i].Issuer < sorted[j].Issuer
+		}
+		if sorted[i].IssuerSha256Fingerprint != sorted[j].IssuerSha256Fingerprint {
+			return sorted[i].IssuerSha256Fingerprint < sorted[j].IssuerSha256Fingerprint
+		}
+		return sorted[i].BeginTime < sorted[j].BeginTime
+	})
+	return json.NewEncoder(out).Encode(sorted)
+}
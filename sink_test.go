@@ -0,0 +1,209 @@
+package sunlight
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/monicachew/certificatetransparency"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Keeps the summaries written to it in memory.
+type memorySink struct {
+	lock      sync.Mutex
+	summaries []*CertSummary
+	closed    bool
+}
+
+func (s *memorySink) Write(summary *CertSummary, cert *x509.Certificate) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.summaries = append(s.summaries, summary)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestMultiSink(t *testing.T) {
+	now := time.Now()
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "long.example.com"},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(6, 0, 0),
+		DNSNames:  []string{"long.example.com"},
+	})
+	first, second := &memorySink{}, &memorySink{}
+	failing := SinkFunc(func(summary *CertSummary, cert *x509.Certificate) error {
+		return errors.New("disk full")
+	})
+	sink := NewMultiSink(first, failing, second)
+	analyzer := NewAnalyzer(nil, nil, nil, sink)
+	analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+		Index: 3,
+		Entry: &certificatetransparency.Entry{
+			Timestamp: uint64(now.Unix()) * 1000,
+			X509Cert:  cert.Raw,
+		},
+	}, nil)
+	if err := sink.Close(); err != nil {
+		t.Errorf("Unexpected error closing sinks: %s", err)
+	}
+
+	// A failing sink doesn't stop the others from being written to.
+	for _, s := range []*memorySink{first, second} {
+		if len(s.summaries) != 1 || s.summaries[0].LogIndex != 3 || !s.closed {
+			t.Errorf("Expected one summary and a close, got %v", s.summaries)
+		}
+	}
+	if analyzer.WriteErrors != 1 {
+		t.Errorf("Expected 1 write error, got %d", analyzer.WriteErrors)
+	}
+}
+
+func sinkSummaries() []*CertSummary {
+	notBefore := time.Date(2014, time.June, 12, 0, 0, 0, 0, time.UTC)
+	return []*CertSummary{
+		{
+			CN:            "a.example.com",
+			Issuer:        "CN=Example CA",
+			LogIndex:      1,
+			DnsNames:      []string{"a.example.com", "b.example.com"},
+			NotBeforeTime: notBefore,
+			NotAfterTime:  notBefore.AddDate(6, 0, 0),
+			Violations: map[string]bool{
+				KEY_TOO_SHORT:         true,
+				VALID_PERIOD_TOO_LONG: true,
+				EXP_TOO_SMALL:         false,
+			},
+		},
+		{CN: "c.example.com", LogIndex: 2},
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewJSONSink(&out)
+	for _, summary := range sinkSummaries() {
+		sink.Write(summary, nil)
+	}
+	sink.Close()
+	var decoded struct{ Certs []CertSummary }
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON %q: %s", out.String(), err)
+	}
+	if len(decoded.Certs) != 2 || decoded.Certs[1].CN != "c.example.com" {
+		t.Errorf("Unexpected JSON output:\n%s", out.String())
+	}
+}
+
+func TestNDJSONSink(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewNDJSONSink(&out)
+	for _, summary := range sinkSummaries() {
+		sink.Write(summary, nil)
+	}
+	sink.Close()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got:\n%s", out.String())
+	}
+	for i, line := range lines {
+		var summary CertSummary
+		if err := json.Unmarshal([]byte(line), &summary); err != nil {
+			t.Errorf("Invalid JSON line %q: %s", line, err)
+		} else if summary.LogIndex != uint64(i+1) {
+			t.Errorf("Unexpected summary on line %d: %s", i, line)
+		}
+	}
+}
+
+func TestCSVSink(t *testing.T) {
+	var out bytes.Buffer
+	sink, err := NewCSVSink(&out)
+	if err != nil {
+		t.Fatal("could not create CSV sink", err)
+	}
+	for _, summary := range sinkSummaries() {
+		sink.Write(summary, nil)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal("could not close CSV sink", err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal("invalid CSV", err)
+	}
+	if len(records) != 3 || strings.Join(records[0], ",") != strings.Join(CSVColumns, ",") {
+		t.Fatalf("Unexpected CSV records %v", records)
+	}
+	expected := []string{"1", "0", "a.example.com", "CN=Example CA", "",
+		"2014-06-12T00:00:00Z", "2020-06-12T00:00:00Z",
+		"a.example.com b.example.com", VALID_PERIOD_TOO_LONG + " " + KEY_TOO_SHORT}
+	if strings.Join(records[1], "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %v, got %v", expected, records[1])
+	}
+}
+
+func TestWriteSummaries(t *testing.T) {
+	for _, test := range []struct {
+		format   string
+		expected string
+	}{
+		{OUTPUT_FORMAT_JSON, `{"Certs":[` + "\n" + `{"CN":"a.example.com",`},
+		{OUTPUT_FORMAT_NDJSON, `{"CN":"a.example.com",`},
+		{OUTPUT_FORMAT_CSV, strings.Join(CSVColumns, ",") + "\n1,0,a.example.com,"},
+	} {
+		var out bytes.Buffer
+		if err := WriteSummaries(&out, test.format, sinkSummaries()); err != nil {
+			t.Errorf("%s: could not write summaries: %s", test.format, err)
+			continue
+		}
+		if !strings.HasPrefix(out.String(), test.expected) ||
+			!strings.Contains(out.String(), "c.example.com") {
+			t.Errorf("%s: unexpected output:\n%s", test.format, out.String())
+		}
+	}
+	var out bytes.Buffer
+	if err := WriteSummaries(&out, "xml", sinkSummaries()); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestWriteIssuerJSON(t *testing.T) {
+	issuers := []*IssuerReputation{
+		{Issuer: "CN=B", BeginTime: 1},
+		{Issuer: "CN=B", BeginTime: 0},
+		{Issuer: "CN=A", IssuerSha256Fingerprint: "ff"},
+		{Issuer: "CN=A", IssuerSha256Fingerprint: "00"},
+	}
+	var out bytes.Buffer
+	if err := WriteIssuerJSON(&out, issuers); err != nil {
+		t.Fatal("could not write issuers", err)
+	}
+	var decoded []IssuerReputation
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON %q: %s", out.String(), err)
+	}
+	var order []string
+	for _, issuer := range decoded {
+		order = append(order, fmt.Sprintf("%s/%s/%d", issuer.Issuer,
+			issuer.IssuerSha256Fingerprint, issuer.BeginTime))
+	}
+	expected := "CN=A/00/0 CN=A/ff/0 CN=B//0 CN=B//1"
+	if strings.Join(order, " ") != expected {
+		t.Errorf("Expected issuers in the order %s, got %v", expected, order)
+	}
+	if issuers[0].BeginTime != 1 {
+		t.Error("Expected the issuers passed in to be left in their order")
+	}
+}
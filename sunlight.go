@@ -2,18 +2,27 @@ package sunlight
 
 import (
 	"bytes"
-	"golang.org/x/net/idna"
+	"crypto"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/monicachew/alexa"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 	"io/ioutil"
+	"math"
+	"math/big"
 	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -25,38 +34,386 @@ const (
 	MISSING_CN_IN_SAN              = "MissingCNInSan"
 	KEY_TOO_SHORT                  = "KeyTooShort"
 	EXP_TOO_SMALL                  = "ExpTooSmall"
+	FUTURE_NOT_BEFORE              = "FutureNotBefore"
+	WEAK_RSA_MODULUS               = "WeakRSAModulus"
+	SCT_SIGNATURE_INVALID          = "SCTSignatureInvalid"
+	NO_SAN_EXTENSION               = "NoSANExtension"
+	UNKNOWN_SIGNATURE_ALGORITHM    = "UnknownSignatureAlgorithm"
+	POISON_ON_FINAL_CERT           = "PoisonOnFinalCert"
+	KEY_IDENTIFIER_MISMATCH        = "KeyIdentifierMismatch"
+	LEAF_OUTLIVES_ISSUER           = "LeafOutlivesIssuer"
+	ILLEGAL_DNS_CHARACTER          = "IllegalDNSCharacter"
+	MIXED_WILDCARD_AND_IP          = "MixedWildcardAndIP"
+	EMPTY_SUBJECT_NO_SAN           = "EmptySubjectNoSAN"
+	PATHLEN_EXCEEDED               = "PathLenExceeded"
+	PUBLIC_SUFFIX_SAN              = "PublicSuffixSAN"
+	EXTENSIONS_BEFORE_V3           = "ExtensionsBeforeV3"
+	MISSING_AKI                    = "MissingAKI"
+	MULTIPLE_CN                    = "MultipleCN"
+	MISSING_CERT_POLICY            = "MissingCertPolicy"
+	SHA1_IN_CHAIN                  = "SHA1InChain"
+	RESERVED_TLD                   = "ReservedTLD"
+	LATE_LOGGING                   = "LateLogging"
+	NONSTANDARD_RSA_EXPONENT       = "NonstandardRSAExponent"
+	MISSING_OCSP                   = "MissingOCSP"
+	MISSING_CRL                    = "MissingCRL"
+	NAME_CONSTRAINT_VIOLATION      = "NameConstraintViolation"
+	MISPLACED_WILDCARD             = "MisplacedWildcard"
+	FORBIDDEN_SAN_TYPE             = "ForbiddenSANType"
+	UNPARSEABLE_KEY                = "UnparseableKey"
+	SHA1_AFTER_SUNSET              = "SHA1AfterSunset"
+	MALFORMED_VALIDITY_DATES       = "MalformedValidityDates"
+	KEY_USAGE_MISMATCH             = "KeyUsageMismatch"
+	INSUFFICIENT_SCTS              = "InsufficientSCTs"
+	EMPTY_ORGANIZATION             = "EmptyOrganization"
+	BROKEN_SIGNATURE_ALGORITHM     = "BrokenSignatureAlgorithm"
+	ALGORITHM_MISMATCH             = "AlgorithmMismatch"
+	IP_AS_DNS_NAME                 = "IPAsDNSName"
+)
+
+// The names of the built-in violations CalculateCertSummary checks for.
+// RegisterCheck can add others.
+var ViolationNames = []string{
+	VALID_PERIOD_TOO_LONG,
+	DEPRECATED_SIGNATURE_ALGORITHM,
+	DEPRECATED_VERSION,
+	MISSING_CN_IN_SAN,
+	KEY_TOO_SHORT,
+	EXP_TOO_SMALL,
+	FUTURE_NOT_BEFORE,
+	WEAK_RSA_MODULUS,
+	SCT_SIGNATURE_INVALID,
+	NO_SAN_EXTENSION,
+	UNKNOWN_SIGNATURE_ALGORITHM,
+	POISON_ON_FINAL_CERT,
+	KEY_IDENTIFIER_MISMATCH,
+	LEAF_OUTLIVES_ISSUER,
+	ILLEGAL_DNS_CHARACTER,
+	MIXED_WILDCARD_AND_IP,
+	EMPTY_SUBJECT_NO_SAN,
+	PATHLEN_EXCEEDED,
+	PUBLIC_SUFFIX_SAN,
+	EXTENSIONS_BEFORE_V3,
+	MISSING_AKI,
+	MULTIPLE_CN,
+	MISSING_CERT_POLICY,
+	SHA1_IN_CHAIN,
+	RESERVED_TLD,
+	LATE_LOGGING,
+	NONSTANDARD_RSA_EXPONENT,
+	MISSING_OCSP,
+	MISSING_CRL,
+	NAME_CONSTRAINT_VIOLATION,
+	MISPLACED_WILDCARD,
+	FORBIDDEN_SAN_TYPE,
+	UNPARSEABLE_KEY,
+	SHA1_AFTER_SUNSET,
+	MALFORMED_VALIDITY_DATES,
+	KEY_USAGE_MISMATCH,
+	INSUFFICIENT_SCTS,
+	EMPTY_ORGANIZATION,
+	BROKEN_SIGNATURE_ALGORITHM,
+	ALGORITHM_MISMATCH,
+	IP_AS_DNS_NAME,
+}
+
+// How much validation a CA claims to have done of a cert's subject.
+const (
+	VALIDATION_DV = "DV"
+	VALIDATION_OV = "OV"
+	VALIDATION_EV = "EV"
 )
 
+// Certificate policies that mark a cert as extended validation: the CA/B
+// Forum's, and those some CAs used before it existed.
+var evPolicyOIDs = []asn1.ObjectIdentifier{
+	{2, 23, 140, 1, 1},                      // CA/B Forum
+	{2, 16, 840, 1, 114412, 2, 1},           // DigiCert
+	{2, 16, 840, 1, 113733, 1, 7, 23, 6},    // VeriSign
+	{2, 16, 840, 1, 114413, 1, 7, 23, 3},    // Go Daddy
+	{2, 16, 840, 1, 114028, 10, 1, 2},       // Entrust
+	{1, 3, 6, 1, 4, 1, 4146, 1, 1},          // GlobalSign
+	{1, 3, 6, 1, 4, 1, 6449, 1, 2, 1, 5, 1}, // Comodo
+}
+
+var commonNameOID = asn1.ObjectIdentifier{2, 5, 4, 3}
+
+var subjectAltNameOID = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// Returns the number of common name attributes in name. pkix.Name's
+// CommonName only holds the last of them.
+func countCommonNames(name pkix.Name) int {
+	count := 0
+	for _, attribute := range name.Names {
+		if attribute.Type.Equal(commonNameOID) {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the validation level of cert: EV if it asserts an EV policy, OV if
+// its subject names an organization or locality, and DV otherwise.
+func ValidationLevel(cert *x509.Certificate) string {
+	for _, policy := range cert.PolicyIdentifiers {
+		for _, ev := range evPolicyOIDs {
+			if policy.Equal(ev) {
+				return VALIDATION_EV
+			}
+		}
+	}
+	if len(cert.Subject.Organization) > 0 || len(cert.Subject.Locality) > 0 {
+		return VALIDATION_OV
+	}
+	return VALIDATION_DV
+}
+
+// How far past the time a cert was logged its NotBefore may be before we
+// consider it to be in the future.
+const NOT_BEFORE_SKEW = 24 * time.Hour
+
+// Certs are expected to be logged around when they're issued. Ones logged
+// more than this long after their NotBefore were probably backfilled.
+const LATE_LOGGING_DELAY = 24 * time.Hour
+
+// An issuer is suspected of issuing low-quality certs in bulk if it has at
+// least BULK_ISSUANCE_MIN_CERTS certs, at most BULK_ISSUANCE_MAX_RANKED of
+// them (as a fraction) are for domains in Alexa, and at least
+// BULK_ISSUANCE_MIN_VIOLATING of them have a violation.
+const (
+	BULK_ISSUANCE_MIN_CERTS     = 100
+	BULK_ISSUANCE_MAX_RANKED    = 0.05
+	BULK_ISSUANCE_MIN_VIOLATING = 0.5
+)
+
+// RSA keys with at most this many bits are too short, unless a RuleConfig
+// says otherwise.
+const DEFAULT_SHORT_KEY_BITS = 1024
+
+// SHA-1 certs issued after this, when the BRs stopped allowing them, are
+// SHA1_AFTER_SUNSET, unless a RuleConfig says otherwise.
+var DefaultSHA1Cutoff = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// RSA moduli are checked for factors among the primes below this.
+const SMALL_PRIME_LIMIT = 3000
+
+// The product of all primes below SMALL_PRIME_LIMIT.
+var smallPrimeProduct = func() *big.Int {
+	product := big.NewInt(1)
+	composite := make([]bool, SMALL_PRIME_LIMIT)
+	for i := 2; i < SMALL_PRIME_LIMIT; i++ {
+		if composite[i] {
+			continue
+		}
+		product.Mul(product, big.NewInt(int64(i)))
+		for j := i * i; j < SMALL_PRIME_LIMIT; j += i {
+			composite[j] = true
+		}
+	}
+	return product
+}()
+
 // Only fields that start with capital letters are exported
 type CertSummary struct {
-	CN                 string
-	Issuer             string
-	Sha256Fingerprint  string
-	NotBefore          string
-	NotAfter           string
+	CN                string
+	Issuer            string
+	Sha256Fingerprint string
+	// The fingerprint of the cert that issued this one, if the chain was
+	// logged, which tells apart CAs that reuse a name across keys.
+	IssuerSha256Fingerprint string
+	// Dates for display, like "Jun 12 2014".
+	NotBefore string
+	NotAfter  string
+	// The same, as RFC 3339 timestamps in JSON.
+	NotBeforeTime      time.Time
+	NotAfterTime       time.Time
 	KeySize            int
 	Exp                int
 	SignatureAlgorithm int
 	Version            int
 	IsCA               bool
 	DnsNames           []string
+	RawDnsNames        []string
 	IpAddresses        []string
+	EmailAddresses     []string
+	URIs               []string
 	Violations         map[string]bool
 	MaxReputation      float32
 	IssuerInMozillaDB  bool
 	Timestamp          uint64
+	LogIndex           uint64
+	EmbeddedSCTCount   int
+	// The base64 IDs of the logs that issued the embedded SCTs, sorted and
+	// without duplicates, and how many operators those logs have between
+	// them.
+	SCTLogIDs        []string
+	SCTOperatorCount int
+	Precert          bool
+	SubjectKeyId     string
+	AuthorityKeyId   string
+	// One of VALIDATION_DV, VALIDATION_OV or VALIDATION_EV.
+	ValidationLevel string
+	// The certificate policy OIDs the cert asserts, like "2.23.140.1.2.1".
+	PolicyOIDs []string
+	// The URLs in the cert's authority information access extension.
+	OCSPServers            []string
+	IssuingCertificateURLs []string
+	// The URLs in the cert's CRL distribution points extension.
+	CRLDistributionPoints []string
+	// How long after its NotBefore the cert was logged, in milliseconds. It's
+	// negative if the cert was logged first, and 0 if it wasn't logged.
+	LoggingDelay int64
+	// For each root program the Analyzer was given, whether an issuer in the
+	// cert's chain is one of the program's roots.
+	RootPrograms map[string]bool `json:",omitempty"`
+	// The reputation of each ranked DNS name in the cert, if the RuleConfig
+	// asked for them. MaxReputation is the greatest of these, or of the CN's.
+	SANReputations map[string]float32 `json:",omitempty"`
+}
+
+// Options controlling how certs are checked. Passing a nil *RuleConfig to
+// CalculateCertSummary is the same as passing an empty one.
+type RuleConfig struct {
+	// Public keys of CT logs, keyed on log ID, used to verify embedded SCTs.
+	// SCTs from logs that aren't listed here aren't verified.
+	CTLogKeys map[[32]byte]crypto.PublicKey
+	// The operators of CT logs, keyed on log ID. Logs that aren't listed
+	// here count as operated by themselves alone.
+	CTLogOperators map[[32]byte]string
+	// The violations to check for. If nil, every violation is checked for.
+	// Violations that aren't checked for don't appear in a summary's
+	// Violations.
+	Checks map[string]bool
+	// RSA keys with at most this many bits are KEY_TOO_SHORT. If 0,
+	// DEFAULT_SHORT_KEY_BITS is used.
+	ShortKeyBits int
+	// If true, a CN only counts as being in the SAN if a DNS name matches it
+	// exactly (ignoring case), and not if it's only covered by a wildcard.
+	StrictCNInSAN bool
+	// SHA-1 certs with a NotBefore after this are SHA1_AFTER_SUNSET as well as
+	// DEPRECATED_SIGNATURE_ALGORITHM. If zero, DefaultSHA1Cutoff is used.
+	SHA1Cutoff time.Time
+	// If true, summaries record the reputation of each ranked DNS name in
+	// SANReputations, and not just the greatest in MaxReputation. It's off by
+	// default, since it makes summaries much bigger.
+	RecordSANReputations bool
+	// How much each violation counts towards an issuer's overall scores,
+	// relative to the others, as used by IssuerReputation.FinishWeighted.
+	// Violations that aren't listed have a weight of 1, or 0 if they're
+	// informational, so if nil, every other violation counts the same.
+	Weights map[string]float32
+}
+
+// Weights that make violations which undermine a cert's security count for
+// more than cosmetic ones.
+var DefaultViolationWeights = map[string]float32{
+	DEPRECATED_SIGNATURE_ALGORITHM: 3,
+	SHA1_AFTER_SUNSET:              3,
+	BROKEN_SIGNATURE_ALGORITHM:     5,
+	KEY_TOO_SHORT:                  3,
+	EXP_TOO_SMALL:                  2,
+	WEAK_RSA_MODULUS:               3,
+	PUBLIC_SUFFIX_SAN:              3,
+	EXTENSIONS_BEFORE_V3:           2,
+	MISSING_CN_IN_SAN:              0.5,
+}
+
+// Marshals the config with CT log keys replaced by their (base64) IDs, so that
+// it can be recorded alongside results.
+func (config *RuleConfig) MarshalJSON() ([]byte, error) {
+	type plainConfig RuleConfig
+	ids := make([]string, 0, len(config.CTLogKeys))
+	for id := range config.CTLogKeys {
+		ids = append(ids, base64.StdEncoding.EncodeToString(id[:]))
+	}
+	sort.Strings(ids)
+	operators := make(map[string]string, len(config.CTLogOperators))
+	for id, operator := range config.CTLogOperators {
+		operators[base64.StdEncoding.EncodeToString(id[:])] = operator
+	}
+	return json.Marshal(struct {
+		*plainConfig
+		CTLogKeys      []string
+		CTLogOperators map[string]string
+	}{(*plainConfig)(config), ids, operators})
+}
+
+// Returns true if the violation with the given name should be checked for.
+func (config *RuleConfig) Enabled(name string) bool {
+	return config.Checks == nil || config.Checks[name]
+}
+
+// Returns the weight of the violation with the given name. config may be nil.
+func (config *RuleConfig) Weight(name string) float32 {
+	if config != nil {
+		if weight, ok := config.Weights[name]; ok {
+			return weight
+		}
+	}
+	if IsInformational(name) {
+		return 0
+	}
+	return 1
+}
+
+// Parses a comma-separated list of name=weight pairs, as used by
+// RuleConfig.Weights. Returns an error if any name isn't a known violation or
+// any weight is negative.
+func ParseWeights(list string) (map[string]float32, error) {
+	weights := make(map[string]float32)
+	for _, pair := range strings.Split(list, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected name=weight, got %q", pair)
+		}
+		name := strings.TrimSpace(parts[0])
+		if _, err := ParseChecks(name); err != nil {
+			return nil, err
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 32)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", parts[1], name)
+		}
+		weights[name] = float32(weight)
+	}
+	return weights, nil
+}
+
+// Parses a comma-separated list of violation names, as used by
+// RuleConfig.Checks. Returns an error if any name isn't a known violation.
+func ParseChecks(list string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, name := range violationNames() {
+		known[name] = true
+	}
+	checks := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			return nil, fmt.Errorf("unknown violation %q (known violations: %s)",
+				name, strings.Join(violationNames(), ", "))
+		}
+		checks[name] = true
+	}
+	return checks, nil
 }
 
 type IssuerReputationScore struct {
 	NormalizedScore float32
 	RawScore        float32
+	// The number of certs that had the violation.
+	ViolatingCount uint64
 }
 
 type IssuerReputation struct {
-	Issuer            string
-	IssuerInMozillaDB bool
-	Scores            map[string]*IssuerReputationScore
-	IsCA              uint64
+	Issuer string
+	// The fingerprint of the issuing cert, or empty if it wasn't logged.
+	IssuerSha256Fingerprint string
+	IssuerInMozillaDB       bool
+	Scores                  map[string]*IssuerReputationScore
+	IsCA                    uint64
+	// Which root programs the issuer is in, as for CertSummary.
+	RootPrograms map[string]bool `json:",omitempty"`
 	// Issuer reputation, between [0, 1]. This is only affected by certs that
 	// have MaxReputation != -1
 	NormalizedScore float32
@@ -66,7 +423,26 @@ type IssuerReputation struct {
 	// Total count of certs issued by this issuer for domains in Alexa.
 	NormalizedCount uint64
 	// Total count of certs issued by this issuer
-	RawCount  uint64
+	RawCount uint64
+	// The number of distinct registrable domains (eTLD+1, such as
+	// example.co.uk) that the issuer's certs were for, estimated once there
+	// are more than a thousand or so. Set by Finish.
+	RegistrableDomains uint64
+	domains            hyperLogLog
+	// The number of the issuer's certs that had any violation.
+	ViolatingCount uint64
+	// The variance of the reputations of the issuer's certs, counting those
+	// for domains not in Alexa as 0. An issuer with many certs and a variance
+	// near 0 issues for domains that are all alike. Set by Finish.
+	ReputationVariance float32
+	// Whether the issuer looks like it issues low-quality certs in bulk: lots
+	// of certs for domains not in Alexa, most of them violating. Set by
+	// Finish.
+	SuspectedBulkIssuance bool
+	// The sums of the reputations of the issuer's certs and of their squares.
+	reputationSum     float64
+	reputationSquares float64
+	// The start of the month covered, in milliseconds since the epoch
 	BeginTime uint64
 	done      bool
 }
@@ -75,21 +451,31 @@ type IssuerReputation struct {
 // epoch in milliseconds that is the GMT time of the month that most
 // recently began before that time.
 func TruncateMonth(t uint64) uint64 {
-	// t is in milliseconds, but time.Unix wants its first argument in seconds
-	d := time.Unix(int64(t)/1000, 0)
+	// t is in milliseconds, but time.Unix wants its first argument in seconds.
+	// time.Unix returns local time, and months begin at different times in
+	// different zones.
+	d := time.Unix(int64(t)/1000, 0).UTC()
 	truncated := time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.UTC)
 	// again, time.Unix returns seconds - we want milliseconds
 	return uint64(truncated.Unix()) * 1000
 }
 
+// Given a time since the epoch in milliseconds, returns the corresponding
+// time.Time.
+func TimestampToTime(t uint64) time.Time {
+	return time.Unix(int64(t)/1000, int64(t)%1000*int64(time.Millisecond))
+}
+
+// Formats the UTC date of t for JSON output, like "Jun 12 2014".
 func TimeToJSONString(t time.Time) string {
 	const layout = "Jan 2 2006"
-	return t.Format(layout)
+	return t.UTC().Format(layout)
 }
 
+// Returns true if the cert has any violation that isn't informational.
 func (summary *CertSummary) ViolatesBR() bool {
-	for _, val := range summary.Violations {
-		if val {
+	for name, val := range summary.Violations {
+		if val && !IsInformational(name) {
 			return true
 		}
 	}
@@ -127,6 +513,17 @@ func DistinguishedNameToString(n pkix.Name) string {
 	return buffer.String()
 }
 
+// Returns true if the RSA modulus n is even, divisible by a small prime, or a
+// perfect square, any of which means its key was generated badly.
+func isWeakRSAModulus(n *big.Int) bool {
+	one := big.NewInt(1)
+	if new(big.Int).GCD(nil, nil, n, smallPrimeProduct).Cmp(one) != 0 {
+		return true
+	}
+	root := new(big.Int).Sqrt(n)
+	return root.Mul(root, root).Cmp(n) == 0
+}
+
 func containsIssuerInRootList(certChain []*x509.Certificate, rootCAMap map[string]bool) bool {
 	for _, cert := range certChain {
 		if rootCAMap[DistinguishedNameToString(cert.Issuer)] {
@@ -136,6 +533,20 @@ func containsIssuerInRootList(certChain []*x509.Certificate, rootCAMap map[strin
 	return false
 }
 
+// Returns, for each of programs (which map a root program's name to its root
+// CA map, as read by ReadRootCAMap), whether an issuer in certChain is one of
+// the program's roots.
+func RootProgramMembership(certChain []*x509.Certificate,
+	programs map[string]map[string]bool) map[string]bool {
+	membership := make(map[string]bool, len(programs))
+	for name, rootCAMap := range programs {
+		membership[name] = containsIssuerInRootList(certChain, rootCAMap)
+	}
+	return membership
+}
+
+// timestamp is when the issuer's first cert was logged, in milliseconds since
+// the epoch.
 func NewIssuerReputation(issuer pkix.Name, timestamp uint64) *IssuerReputation {
 	reputation := new(IssuerReputation)
 	reputation.BeginTime = TruncateMonth(timestamp)
@@ -147,6 +558,7 @@ func NewIssuerReputation(issuer pkix.Name, timestamp uint64) *IssuerReputation {
 func (score *IssuerReputationScore) Update(reputation float32) {
 	score.NormalizedScore += reputation
 	score.RawScore += 1
+	score.ViolatingCount += 1
 }
 
 func (score *IssuerReputationScore) Finish(normalizedCount uint64,
@@ -158,9 +570,38 @@ func (score *IssuerReputationScore) Finish(normalizedCount uint64,
 	score.RawScore = 1.0 - score.RawScore
 }
 
+// Returns a pointer to score, or nil if it's NaN, as normalized scores are for
+// issuers with no certs for domains in Alexa. JSON can't represent NaN, so
+// those scores are marshalled as null.
+func finiteScore(score float32) *float32 {
+	if math.IsNaN(float64(score)) {
+		return nil
+	}
+	return &score
+}
+
+func (score IssuerReputationScore) MarshalJSON() ([]byte, error) {
+	type plainScore IssuerReputationScore
+	return json.Marshal(struct {
+		plainScore
+		NormalizedScore *float32
+	}{plainScore(score), finiteScore(score.NormalizedScore)})
+}
+
+func (issuer IssuerReputation) MarshalJSON() ([]byte, error) {
+	type plainReputation IssuerReputation
+	return json.Marshal(struct {
+		plainReputation
+		NormalizedScore *float32
+	}{plainReputation(issuer), finiteScore(issuer.NormalizedScore)})
+}
+
 func (issuer *IssuerReputation) Update(summary *CertSummary) {
 	issuer.RawCount += 1
 	issuer.IssuerInMozillaDB = summary.IssuerInMozillaDB
+	if summary.RootPrograms != nil {
+		issuer.RootPrograms = summary.RootPrograms
+	}
 	reputation := summary.MaxReputation
 	if reputation != -1 {
 		// Keep track of certs issued for domains in Alexa
@@ -168,6 +609,11 @@ func (issuer *IssuerReputation) Update(summary *CertSummary) {
 	} else {
 		reputation = 0
 	}
+	issuer.reputationSum += float64(reputation)
+	issuer.reputationSquares += float64(reputation) * float64(reputation)
+	if summary.ViolatesBR() {
+		issuer.ViolatingCount += 1
+	}
 
 	for name, val := range summary.Violations {
 		if issuer.Scores[name] == nil {
@@ -181,68 +627,276 @@ func (issuer *IssuerReputation) Update(summary *CertSummary) {
 	if summary.IsCA {
 		issuer.IsCA += 1
 	}
+
+	for _, name := range summary.DnsNames {
+		domain, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimPrefix(name, "*."))
+		if err == nil {
+			issuer.domains.Add(domain)
+		}
+	}
 }
 
+// Returns a copy of the issuer's reputation that can be finished or updated
+// without affecting the original.
+func (issuer *IssuerReputation) clone() *IssuerReputation {
+	clone := *issuer
+	clone.Scores = make(map[string]*IssuerReputationScore, len(issuer.Scores))
+	for name, score := range issuer.Scores {
+		copied := *score
+		clone.Scores[name] = &copied
+	}
+	clone.domains = issuer.domains.clone()
+	return &clone
+}
+
+// Returns the issuer's score for the violation with the given name. If that
+// violation wasn't checked for, the score is as if no cert violated it.
+func (issuer *IssuerReputation) Score(name string) *IssuerReputationScore {
+	if score := issuer.Scores[name]; score != nil {
+		return score
+	}
+	return &IssuerReputationScore{NormalizedScore: 1.0, RawScore: 1.0}
+}
+
+// Finishes the issuer's scores, with every violation counting the same
+// towards its overall scores.
 func (issuer *IssuerReputation) Finish() {
+	issuer.FinishWeighted(nil)
+}
+
+// Finishes the issuer's scores. Its overall scores are the averages of its
+// scores for each violation, weighted by config's Weights. config may be nil.
+func (issuer *IssuerReputation) FinishWeighted(config *RuleConfig) {
+	// Floating point addition isn't associative, so the scores are summed in
+	// a fixed order rather than the map's, for the same inputs to always give
+	// the same output.
+	names := make([]string, 0, len(issuer.Scores))
+	for name := range issuer.Scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	normalizedSum := float32(0.0)
 	rawSum := float32(0.0)
-	for _, score := range issuer.Scores {
+	weightSum := float32(0.0)
+	for _, name := range names {
+		score := issuer.Scores[name]
 		score.Finish(issuer.NormalizedCount, issuer.RawCount)
-		normalizedSum += score.NormalizedScore
-		rawSum += score.RawScore
+		weight := config.Weight(name)
+		normalizedSum += weight * score.NormalizedScore
+		rawSum += weight * score.RawScore
+		weightSum += weight
+	}
+	if weightSum > 0 {
+		issuer.NormalizedScore = normalizedSum / weightSum
+		issuer.RawScore = rawSum / weightSum
+	} else {
+		// Nothing counts against the issuer, which is the best score, as
+		// though no cert had a violation. As for each violation's score, the
+		// normalized one is NaN without certs for ranked domains.
+		issuer.NormalizedScore = 1.0
+		if issuer.NormalizedCount == 0 {
+			issuer.NormalizedScore = float32(math.NaN())
+		}
+		issuer.RawScore = 1.0
+	}
+	issuer.RegistrableDomains = issuer.domains.Count()
+	if issuer.RawCount > 0 {
+		mean := issuer.reputationSum / float64(issuer.RawCount)
+		variance := issuer.reputationSquares/float64(issuer.RawCount) - mean*mean
+		// Rounding can leave a tiny negative variance.
+		issuer.ReputationVariance = float32(math.Max(variance, 0))
+	}
+	issuer.SuspectedBulkIssuance = issuer.RawCount >= BULK_ISSUANCE_MIN_CERTS &&
+		float64(issuer.NormalizedCount) <=
+			BULK_ISSUANCE_MAX_RANKED*float64(issuer.RawCount) &&
+		float64(issuer.ViolatingCount) >=
+			BULK_ISSUANCE_MIN_VIOLATING*float64(issuer.RawCount)
+}
+
+// An issuer reputation's place in a ranking of issuers from worst to best.
+type IssuerRank struct {
+	Rank       int
+	RankScore  float32
+	Reputation *IssuerReputation
+}
+
+type issuerRanks []*IssuerRank
+
+func (r issuerRanks) Len() int      { return len(r) }
+func (r issuerRanks) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r issuerRanks) Less(i, j int) bool {
+	if r[i].RankScore != r[j].RankScore {
+		return r[i].RankScore > r[j].RankScore
+	}
+	if r[i].Reputation.Issuer != r[j].Reputation.Issuer {
+		return r[i].Reputation.Issuer < r[j].Reputation.Issuer
+	}
+	if r[i].Reputation.IssuerSha256Fingerprint != r[j].Reputation.IssuerSha256Fingerprint {
+		return r[i].Reputation.IssuerSha256Fingerprint <
+			r[j].Reputation.IssuerSha256Fingerprint
+	}
+	return r[i].Reputation.BeginTime < r[j].Reputation.BeginTime
+}
+
+// Ranks finished issuer reputations from worst to best. An issuer's rank
+// score is its badness (1 - NormalizedScore) scaled by its NormalizedCount
+// relative to the largest NormalizedCount of any issuer. countWeight, in
+// [0, 1], controls how much that scaling matters: at 0 issuers are ranked on
+// badness alone, and at 1 a CA with one bad cert can't outrank a CA with
+// thousands. Issuers with no certs for domains in Alexa have a rank score of
+// 0, since their NormalizedScore isn't meaningful.
+func RankIssuers(issuers []*IssuerReputation, countWeight float32) []*IssuerRank {
+	maxCount := uint64(0)
+	for _, issuer := range issuers {
+		if issuer.NormalizedCount > maxCount {
+			maxCount = issuer.NormalizedCount
+		}
+	}
+	ranks := make([]*IssuerRank, 0, len(issuers))
+	for _, issuer := range issuers {
+		rank := &IssuerRank{Reputation: issuer}
+		if issuer.NormalizedCount > 0 {
+			share := float32(issuer.NormalizedCount) / float32(maxCount)
+			rank.RankScore = (1.0 - issuer.NormalizedScore) *
+				(1.0 - countWeight + countWeight*share)
+		}
+		ranks = append(ranks, rank)
 	}
-	issuer.NormalizedScore = normalizedSum / float32(len(issuer.Scores))
-	issuer.RawScore = rawSum / float32(len(issuer.Scores))
+	sort.Sort(issuerRanks(ranks))
+	for i, rank := range ranks {
+		rank.Rank = i + 1
+	}
+	return ranks
 }
 
-func CalculateCertSummary(cert *x509.Certificate, timestamp uint64, ranker *alexa.AlexaRank,
-	certChain []*x509.Certificate, rootCAMap map[string]bool) (result *CertSummary, err error) {
+// timestamp is when cert was logged, in milliseconds since the epoch, and
+// precert is true if cert was logged as a precertificate.
+func CalculateCertSummary(cert *x509.Certificate, logIndex uint64, timestamp uint64,
+	precert bool, ranker Ranker, certChain []*x509.Certificate,
+	rootCAMap map[string]bool, config *RuleConfig) (result *CertSummary, err error) {
+	if config == nil {
+		config = &RuleConfig{}
+	}
 	summary := CertSummary{}
 	summary.Timestamp = timestamp
+	summary.LogIndex = logIndex
+	summary.Precert = precert
+	summary.SubjectKeyId = hex.EncodeToString(cert.SubjectKeyId)
+	summary.AuthorityKeyId = hex.EncodeToString(cert.AuthorityKeyId)
+	summary.ValidationLevel = ValidationLevel(cert)
+	for _, policy := range cert.PolicyIdentifiers {
+		summary.PolicyOIDs = append(summary.PolicyOIDs, policy.String())
+	}
+	summary.OCSPServers = cert.OCSPServer
+	summary.IssuingCertificateURLs = cert.IssuingCertificateURL
+	summary.CRLDistributionPoints = cert.CRLDistributionPoints
 	summary.CN = cert.Subject.CommonName
 	summary.Issuer = DistinguishedNameToString(cert.Issuer)
 	summary.NotBefore = TimeToJSONString(cert.NotBefore)
 	summary.NotAfter = TimeToJSONString(cert.NotAfter)
+	summary.NotBeforeTime = cert.NotBefore
+	summary.NotAfterTime = cert.NotAfter
 	summary.IsCA = cert.IsCA
 	summary.Version = cert.Version
 	summary.SignatureAlgorithm = int(cert.SignatureAlgorithm)
-	summary.Violations = map[string]bool{
-		VALID_PERIOD_TOO_LONG:          false,
-		DEPRECATED_SIGNATURE_ALGORITHM: false,
-		DEPRECATED_VERSION:             cert.Version != 3,
-		KEY_TOO_SHORT:                  false,
-		EXP_TOO_SMALL:                  false,
-		MISSING_CN_IN_SAN:              false,
+	summary.Violations = make(map[string]bool)
+	for _, name := range violationNames() {
+		if config.Enabled(name) {
+			summary.Violations[name] = false
+		}
+	}
+
+	for _, check := range checks() {
+		if config.Enabled(check.Name()) && check.Evaluate(cert, certChain, config) {
+			summary.Violations[check.Name()] = true
+		}
+	}
+
+	// A timestamp of 0 means there's no log time to compare NotBefore to.
+	if timestamp != 0 {
+		// NotBefore is further in the future than the time the cert was
+		// logged than clock skew can account for.
+		if config.Enabled(FUTURE_NOT_BEFORE) &&
+			cert.NotBefore.After(TimestampToTime(timestamp).Add(NOT_BEFORE_SKEW)) {
+			summary.Violations[FUTURE_NOT_BEFORE] = true
+		}
+		delay := TimestampToTime(timestamp).Sub(cert.NotBefore)
+		summary.LoggingDelay = int64(delay / time.Millisecond)
+		if config.Enabled(LATE_LOGGING) && delay > LATE_LOGGING_DELAY {
+			summary.Violations[LATE_LOGGING] = true
+		}
 	}
 
-	// BR 9.4.1: Validity period is longer than 5 years.  This
-	// should be restricted to certs that don't have CA:True
-	if cert.NotAfter.After(cert.NotBefore.AddDate(5, 0, 7)) &&
-		(!cert.BasicConstraintsValid ||
-			(cert.BasicConstraintsValid && !cert.IsCA)) {
-		summary.Violations[VALID_PERIOD_TOO_LONG] = true
+	// An intermediate signed with SHA-1 is as weak a link as a SHA-1 leaf.
+	// Roots are trusted for being in the root program, not for their
+	// signatures, so theirs don't matter.
+	if config.Enabled(SHA1_IN_CHAIN) {
+		for _, ca := range certChain {
+			if !isRoot(ca, rootCAMap) && isSHA1(ca.SignatureAlgorithm) {
+				summary.Violations[SHA1_IN_CHAIN] = true
+			}
+		}
+	}
+
+	// Only precertificates may carry the CT poison extension.
+	if config.Enabled(POISON_ON_FINAL_CERT) && !precert {
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(ctPoisonOID) {
+				summary.Violations[POISON_ON_FINAL_CERT] = true
+			}
+		}
 	}
 
-	// SignatureAlgorithm is SHA1
-	if cert.SignatureAlgorithm == x509.SHA1WithRSA ||
-		cert.SignatureAlgorithm == x509.DSAWithSHA1 ||
-		cert.SignatureAlgorithm == x509.ECDSAWithSHA1 {
-		summary.Violations[DEPRECATED_SIGNATURE_ALGORITHM] = true
+	if config.Enabled(PATHLEN_EXCEEDED) && pathLenExceeded(certChain, rootCAMap) {
+		summary.Violations[PATHLEN_EXCEEDED] = true
 	}
 
-	// Public key length <= 1024 bits
+	// Public key length <= 1024 bits, by default
+	shortKeyBits := config.ShortKeyBits
+	if shortKeyBits == 0 {
+		shortKeyBits = DEFAULT_SHORT_KEY_BITS
+	}
 	summary.KeySize = -1
 	summary.Exp = -1
 	parsedKey, ok := cert.PublicKey.(*rsa.PublicKey)
 	if ok {
 		summary.KeySize = parsedKey.N.BitLen()
 		summary.Exp = parsedKey.E
-		if summary.KeySize <= 1024 {
+		if config.Enabled(KEY_TOO_SHORT) && summary.KeySize <= shortKeyBits {
 			summary.Violations[KEY_TOO_SHORT] = true
 		}
-		if summary.Exp <= 3 {
+		if config.Enabled(EXP_TOO_SMALL) && summary.Exp <= 3 {
 			summary.Violations[EXP_TOO_SMALL] = true
 		}
+		if config.Enabled(WEAK_RSA_MODULUS) && isWeakRSAModulus(parsedKey.N) {
+			summary.Violations[WEAK_RSA_MODULUS] = true
+		}
+	}
+
+	// Embedded SCTs can only be verified if we know the key of the log that
+	// issued them and have the issuing cert for the issuer key hash.
+	scts, sctErr := ParseEmbeddedSCTs(cert)
+	checkSCTs := config.Enabled(SCT_SIGNATURE_INVALID)
+	if checkSCTs && sctErr != nil {
+		summary.Violations[SCT_SIGNATURE_INVALID] = true
+	}
+	summary.EmbeddedSCTCount = len(scts)
+	summary.SCTLogIDs, summary.SCTOperatorCount = sctLogs(scts,
+		config.CTLogOperators)
+	// Certs that don't embed SCTs may deliver them in other ways, which
+	// can't be seen here, and precerts can't embed them at all.
+	if config.Enabled(INSUFFICIENT_SCTS) && !cert.IsCA && len(scts) > 0 &&
+		(len(summary.SCTLogIDs) < requiredSCTs(cert) ||
+			summary.SCTOperatorCount < MIN_SCT_OPERATORS) {
+		summary.Violations[INSUFFICIENT_SCTS] = true
+	}
+	if checkSCTs && len(certChain) > 0 {
+		for _, sct := range scts {
+			key, ok := config.CTLogKeys[sct.LogID]
+			if ok && sct.Verify(cert, certChain[0], key) != nil {
+				summary.Violations[SCT_SIGNATURE_INVALID] = true
+			}
+		}
 	}
 
 	if ranker != nil {
@@ -252,49 +906,379 @@ func CalculateCertSummary(cert *x509.Certificate, timestamp uint64, ranker *alex
 			if reputation > summary.MaxReputation {
 				summary.MaxReputation = reputation
 			}
+			if config.RecordSANReputations && reputation != -1 {
+				if summary.SANReputations == nil {
+					summary.SANReputations = make(map[string]float32)
+				}
+				summary.SANReputations[host] = reputation
+			}
 		}
 	}
 	sha256hasher := sha256.New()
 	sha256hasher.Write(cert.Raw)
 	summary.Sha256Fingerprint = base64.StdEncoding.EncodeToString(sha256hasher.Sum(nil))
+	if len(certChain) > 0 {
+		issuerFingerprint := sha256.Sum256(certChain[0].Raw)
+		summary.IssuerSha256Fingerprint = base64.StdEncoding.EncodeToString(issuerFingerprint[:])
+	}
 
 	// DNS names and IP addresses
-	summary.DnsNames = cert.DNSNames
+	summary.RawDnsNames = cert.DNSNames
+	for _, name := range cert.DNSNames {
+		summary.DnsNames = append(summary.DnsNames, NormalizeDNSName(name))
+	}
 	for _, address := range cert.IPAddresses {
 		summary.IpAddresses = append(summary.IpAddresses, address.String())
 	}
+	summary.EmailAddresses = cert.EmailAddresses
+	for _, uri := range cert.URIs {
+		summary.URIs = append(summary.URIs, uri.String())
+	}
 
 	summary.IssuerInMozillaDB = containsIssuerInRootList(certChain, rootCAMap)
+	return &summary, nil
+}
+
+func isSHA1(algorithm x509.SignatureAlgorithm) bool {
+	return algorithm == x509.SHA1WithRSA || algorithm == x509.DSAWithSHA1 ||
+		algorithm == x509.ECDSAWithSHA1
+}
+
+// Returns true if algorithm hashes with MD2 or MD5, which are broken enough
+// that collisions can be used to forge certs.
+func isMD(algorithm x509.SignatureAlgorithm) bool {
+	return algorithm == x509.MD2WithRSA || algorithm == x509.MD5WithRSA
+}
 
+// Returns true if cert is a trust anchor: self-issued, with a subject in
+// rootCAMap. Checks that walk the chain skip roots, which are trusted for
+// being in the root program rather than for what they contain, and are often
+// old enough to predate the rules. Their self-signatures aren't verified, as
+// Go won't verify SHA-1 signatures, which many roots have.
+func isRoot(cert *x509.Certificate, rootCAMap map[string]bool) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) &&
+		rootCAMap[DistinguishedNameToString(cert.Subject)]
+}
+
+// Returns true if a CA in certChain, which starts with the issuer of the
+// cert being checked, has more intermediates below it than its
+// pathLenConstraint allows (RFC 5280 section 4.2.1.9). The cert being
+// checked is the end of the path, so it doesn't count, and neither do
+// self-issued certs. A root's constraints don't apply, since RFC 5280 path
+// validation starts below the trust anchor.
+func pathLenExceeded(certChain []*x509.Certificate, rootCAMap map[string]bool) bool {
+	intermediates := 0
+	for _, ca := range certChain {
+		if isRoot(ca, rootCAMap) {
+			continue
+		}
+		limited := ca.MaxPathLen > 0 || (ca.MaxPathLen == 0 && ca.MaxPathLenZero)
+		if ca.BasicConstraintsValid && limited && intermediates > ca.MaxPathLen {
+			return true
+		}
+		if !bytes.Equal(ca.RawSubject, ca.RawIssuer) {
+			intermediates++
+		}
+	}
+	return false
+}
+
+// Returns true if one of cert's DNS names is outside the DNS name
+// constraints of a CA in certChain (RFC 5280 section 4.2.1.10): that is, if
+// the CA permits some domains and the name is in none of them, or if the name
+// is in a domain the CA excludes. A wildcard name is also taken to violate an
+// exclusion of any domain it covers.
+func violatesNameConstraints(cert *x509.Certificate,
+	certChain []*x509.Certificate) bool {
+	for _, ca := range certChain {
+		if len(ca.PermittedDNSDomains) == 0 && len(ca.ExcludedDNSDomains) == 0 {
+			continue
+		}
+		for _, name := range cert.DNSNames {
+			name = NormalizeDNSName(name)
+			if len(ca.PermittedDNSDomains) > 0 &&
+				!inAnyDomain(name, ca.PermittedDNSDomains) {
+				return true
+			}
+			if inAnyDomain(name, ca.ExcludedDNSDomains) {
+				return true
+			}
+			if strings.HasPrefix(name, "*.") {
+				for _, excluded := range ca.ExcludedDNSDomains {
+					if inDomain(NormalizeDNSName(excluded), name[2:]) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if name is in one of the domains in constraints.
+func inAnyDomain(name string, constraints []string) bool {
+	for _, constraint := range constraints {
+		if inDomain(name, constraint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if name is in the domain named by constraint, which covers the
+// domain and its subdomains, or only its subdomains if it starts with a dot.
+// A wildcard name is in the domains that cover the names it matches.
+func inDomain(name string, constraint string) bool {
+	constraint = NormalizeDNSName(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// BR 9.2.2: Returns true unless the Common Name is in the Subject Alt Names,
+// either as an IP or a DNS name.
+func missingCNInSAN(cert *x509.Certificate, config *RuleConfig) bool {
 	// Assume a 0-length CN means it isn't present (this isn't a good
 	// assumption). If the CN is missing, then it can't be missing CN in SAN.
 	if len(cert.Subject.CommonName) == 0 {
-		return &summary, nil
+		return false
 	}
 
-	cnAsPunycode, err := idna.ToASCII(cert.Subject.CommonName)
-	if err != nil {
-		return &summary, nil
+	if _, err := idna.ToASCII(cert.Subject.CommonName); err != nil {
+		return false
 	}
+	// www.Example.com and www.example.com. are the same name.
+	cn := NormalizeDNSName(cert.Subject.CommonName)
 
-	// BR 9.2.2: Found Common Name in Subject Alt Names, either as an IP or a
-	// DNS name.
-	summary.Violations[MISSING_CN_IN_SAN] = true
 	cnAsIP := net.ParseIP(cert.Subject.CommonName)
 	if cnAsIP != nil {
 		for _, ip := range cert.IPAddresses {
 			if cnAsIP.Equal(ip) {
-				summary.Violations[MISSING_CN_IN_SAN] = false
+				return false
 			}
 		}
 	} else {
 		for _, san := range cert.DNSNames {
-			if err == nil && strings.EqualFold(san, cnAsPunycode) {
-				summary.Violations[MISSING_CN_IN_SAN] = false
+			san = NormalizeDNSName(san)
+			if san == cn || (!config.StrictCNInSAN && wildcardCovers(san, cn)) {
+				return false
 			}
 		}
 	}
-	return &summary, nil
+	return true
+}
+
+// Returns true if pattern is a wildcard DNS name such as *.example.com that
+// covers name. The wildcard only stands for the single leftmost label.
+func wildcardCovers(pattern string, name string) bool {
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	dot := strings.Index(name, ".")
+	if dot <= 0 {
+		return false
+	}
+	return strings.EqualFold(pattern[1:], name[dot:])
+}
+
+// The outermost structure of a cert (RFC 5280 section 4.1).
+type certificate struct {
+	TBSCertificate     asn1.RawValue
+	SignatureAlgorithm asn1.RawValue
+	SignatureValue     asn1.BitString
+}
+
+// Returns the outer signature algorithm of the cert der and the signature
+// field of its TBSCertificate, as encoded.
+func signatureAlgorithms(der []byte) (outer []byte, inner []byte, err error) {
+	var cert certificate
+	rest, err := asn1.Unmarshal(der, &cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("trailing data after certificate")
+	}
+	var tbs tbsCertificate
+	if _, err := asn1.Unmarshal(cert.TBSCertificate.FullBytes, &tbs); err != nil {
+		return nil, nil, err
+	}
+	return cert.SignatureAlgorithm.FullBytes, tbs.SignatureAlgorithm.FullBytes, nil
+}
+
+// Parses der like x509.ParseCertificate, except that a cert whose outer
+// signature algorithm differs from the signature field of its TBSCertificate
+// is still parsed, so that it can be flagged as ALGORITHM_MISMATCH rather than
+// lost as a parse error. Such a cert is parsed as though the outer algorithm
+// were the inner one, but its Raw is der as given.
+func ParseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, parseErr := x509.ParseCertificate(der)
+	if parseErr == nil {
+		return cert, nil
+	}
+	outer, inner, err := signatureAlgorithms(der)
+	if err != nil || bytes.Equal(outer, inner) {
+		return nil, parseErr
+	}
+	var original certificate
+	if _, err := asn1.Unmarshal(der, &original); err != nil {
+		return nil, parseErr
+	}
+	original.SignatureAlgorithm = asn1.RawValue{FullBytes: inner}
+	rewritten, err := asn1.Marshal(original)
+	if err != nil {
+		return nil, parseErr
+	}
+	cert, err = x509.ParseCertificate(rewritten)
+	if err != nil {
+		return nil, parseErr
+	}
+	cert.Raw = der
+	return cert, nil
+}
+
+// Returns true if cert's outer signature algorithm differs from the signature
+// field of its TBSCertificate. The outer one isn't signed, so a mismatch can
+// mean the signature was stripped and replaced.
+func algorithmsDiffer(cert *x509.Certificate) bool {
+	outer, inner, err := signatureAlgorithms(cert.Raw)
+	return err == nil && !bytes.Equal(outer, inner)
+}
+
+// Returns true if cert's TBSCertificate has an extensions field. Go doesn't
+// parse the extensions of v1 and v2 certs, so this looks for the field itself.
+func hasExtensionsField(cert *x509.Certificate) bool {
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err != nil {
+		return false
+	}
+	fields := tbs.Bytes
+	for len(fields) > 0 {
+		var field asn1.RawValue
+		var err error
+		fields, err = asn1.Unmarshal(fields, &field)
+		if err != nil {
+			return false
+		}
+		if field.Class == asn1.ClassContextSpecific && field.Tag == 3 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if cert's subject alternative name extension has an otherName,
+// which Go doesn't parse, so this looks through the extension itself.
+func hasOtherNameSAN(cert *x509.Certificate) bool {
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(subjectAltNameOID) {
+			continue
+		}
+		var names asn1.RawValue
+		if _, err := asn1.Unmarshal(extension.Value, &names); err != nil {
+			return false
+		}
+		rest := names.Bytes
+		for len(rest) > 0 {
+			var name asn1.RawValue
+			var err error
+			rest, err = asn1.Unmarshal(rest, &name)
+			if err != nil {
+				return false
+			}
+			if name.Class == asn1.ClassContextSpecific && name.Tag == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if cert may be used to authenticate TLS servers, going by
+// its extended key usages. Certs without any aren't counted.
+func isServerAuth(cert *x509.Certificate) bool {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if name, or the domain a wildcard name covers the subdomains
+// of, is an ICANN public suffix such as com or co.uk, which no one can
+// validate control of. Private suffixes such as github.io are owned by
+// someone, and names that aren't under any listed suffix are internal names,
+// so neither count.
+func isPublicSuffix(name string) bool {
+	name = strings.TrimPrefix(NormalizeDNSName(name), "*.")
+	suffix, icann := publicsuffix.PublicSuffix(name)
+	return icann && suffix == name
+}
+
+// Special-use TLDs (RFC 6761 section 6, and local from RFC 6762), which can't
+// be registered, so no one can validate control of names under them.
+var reservedTLDs = map[string]bool{
+	"example":   true,
+	"invalid":   true,
+	"local":     true,
+	"localhost": true,
+	"test":      true,
+}
+
+// Returns true if name is under a reserved TLD. Onion names (RFC 7686) are
+// only allowed in EV certs (CA/B Forum ballot 144), so they're reserved in
+// anything else.
+func isReservedName(name string, validationLevel string) bool {
+	name = NormalizeDNSName(name)
+	tld := name[strings.LastIndex(name, ".")+1:]
+	if tld == "onion" {
+		return validationLevel != VALIDATION_EV
+	}
+	return reservedTLDs[tld]
+}
+
+// Returns true if every label of name is made of letters, digits and hyphens,
+// except that the leftmost label may be a lone wildcard. Internationalized
+// names have to be in their punycode form to pass.
+func isLDHName(name string) bool {
+	for i, label := range strings.Split(name, ".") {
+		if i == 0 && label == "*" {
+			continue
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+				c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns true if name has a wildcard anywhere but as the whole leftmost
+// label, such as a.*.example.com, **.example.com or a*.example.com. Wildcards
+// only stand for a whole label, and only the leftmost one.
+func isMisplacedWildcard(name string) bool {
+	return strings.Contains(strings.TrimPrefix(name, "*."), "*")
+}
+
+// Returns true if year could be that of a real cert's validity date.
+func isPlausibleYear(year int) bool {
+	return year >= 1970 && year <= 9999
+}
+
+// Lowercases name, strips any trailing dot, and converts it to its ASCII
+// (punycode) form so that different spellings of the same name compare
+// equal. If the name isn't valid IDNA, the lowercased form is returned.
+func NormalizeDNSName(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	ascii, err := idna.ToASCII(name)
+	if err != nil {
+		return name
+	}
+	return ascii
 }
 
 // Takes the name of a file containing newline-delimited Subject Names (as
@@ -2,11 +2,26 @@ package sunlight
 
 import (
 	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"github.com/mozkeeler/sunlight/internal/testcerts"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -28,20 +43,23 @@ func TestCertSummary(t *testing.T) {
 	cert, _ := x509.ParseCertificate(pemBlock.Bytes)
 	fakeRootCAMap := make(map[string]bool)
 	fakeCertList := make([]*x509.Certificate, 0)
-	ts := uint64(time.Now().Unix())
-	summary, _ := CalculateCertSummary(cert, ts, nil, fakeCertList, fakeRootCAMap)
+	ts := uint64(time.Now().Unix()) * 1000
+	summary, _ := CalculateCertSummary(cert, 7, ts, false, nil, fakeCertList, fakeRootCAMap, nil)
 	expected := CertSummary{
 		CN:                 "test.example.com",
 		Issuer:             "O=Acme Co, CN=test.example.com",
 		Sha256Fingerprint:  "Gvp+Qw6i96YPjUZoO2zqLWdusngA8xpAtvMBouj+MZ8=",
 		NotBefore:          "Jan 1 1970",
 		NotAfter:           "Jan 2 1970",
+		NotBeforeTime:      time.Date(1970, 1, 1, 0, 16, 40, 0, time.UTC),
+		NotAfterTime:       time.Date(1970, 1, 2, 3, 46, 40, 0, time.UTC),
 		KeySize:            512,
 		Exp:                65537,
 		SignatureAlgorithm: 3,
 		Version:            3,
 		IsCA:               true,
 		DnsNames:           []string{"test.example.com"},
+		RawDnsNames:        []string{"test.example.com"},
 		IpAddresses:        nil,
 		Violations: map[string]bool{
 			DEPRECATED_SIGNATURE_ALGORITHM: true,
@@ -50,19 +68,127 @@ func TestCertSummary(t *testing.T) {
 			KEY_TOO_SHORT:                  true,
 			MISSING_CN_IN_SAN:              false,
 			VALID_PERIOD_TOO_LONG:          false,
+			FUTURE_NOT_BEFORE:              false,
+			WEAK_RSA_MODULUS:               false,
+			SCT_SIGNATURE_INVALID:          false,
+			NO_SAN_EXTENSION:               false,
+			UNKNOWN_SIGNATURE_ALGORITHM:    false,
+			POISON_ON_FINAL_CERT:           false,
+			KEY_IDENTIFIER_MISMATCH:        false,
+			LEAF_OUTLIVES_ISSUER:           false,
+			ILLEGAL_DNS_CHARACTER:          false,
+			MIXED_WILDCARD_AND_IP:          false,
+			EMPTY_SUBJECT_NO_SAN:           false,
+			PATHLEN_EXCEEDED:               false,
+			PUBLIC_SUFFIX_SAN:              false,
+			EXTENSIONS_BEFORE_V3:           false,
+			MISSING_AKI:                    false,
+			MULTIPLE_CN:                    false,
+			MISSING_CERT_POLICY:            false,
+			SHA1_IN_CHAIN:                  false,
+			RESERVED_TLD:                   false,
+			// Its NotBefore was decades before now.
+			LATE_LOGGING:               true,
+			NONSTANDARD_RSA_EXPONENT:   false,
+			MISSING_OCSP:               false,
+			MISSING_CRL:                false,
+			NAME_CONSTRAINT_VIOLATION:  false,
+			MISPLACED_WILDCARD:         false,
+			FORBIDDEN_SAN_TYPE:         false,
+			UNPARSEABLE_KEY:            false,
+			SHA1_AFTER_SUNSET:          false,
+			MALFORMED_VALIDITY_DATES:   false,
+			KEY_USAGE_MISMATCH:         false,
+			INSUFFICIENT_SCTS:          false,
+			EMPTY_ORGANIZATION:         false,
+			BROKEN_SIGNATURE_ALGORITHM: false,
+			ALGORITHM_MISMATCH:         false,
+			IP_AS_DNS_NAME:             false,
 		},
-		MaxReputation: 0,
-		Timestamp:     ts,
+		MaxReputation:   0,
+		Timestamp:       ts,
+		LogIndex:        7,
+		SubjectKeyId:    "01020304",
+		AuthorityKeyId:  "01020304",
+		ValidationLevel: VALIDATION_OV,
+		PolicyOIDs:      []string{"1.2.3"},
+		LoggingDelay:    int64(ts) - 1000*1000,
 	}
 	b, _ := json.MarshalIndent(summary, "", "  ")
 	expected_b, _ := json.MarshalIndent(expected, "", "  ")
 	if !bytes.Equal(expected_b, b) {
 		t.Errorf("Didn't get expected summary: %b \n!= \n%b\n", expected_b, b)
 	}
+
+	// A generated cert like the fixture, but with a key Go will still
+	// generate, that was logged an hour after it became valid.
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy, _ := x509.OIDFromInts([]uint64{1, 2, 3})
+	cert = testcerts.New(t, testcerts.Spec{
+		Subject: pkix.Name{Organization: []string{"Acme Co"},
+			CommonName: "test.example.com"},
+		KeyBits:            1024,
+		NotBefore:          notBefore,
+		NotAfter:           notBefore.AddDate(0, 0, 1),
+		SignatureAlgorithm: x509.SHA1WithRSA,
+		DNSNames:           []string{"test.example.com"},
+		Policies:           []x509.OID{policy},
+		IsCA:               true,
+	})
+	ts = uint64(notBefore.Add(time.Hour).Unix()) * 1000
+	summary, _ = CalculateCertSummary(cert, 7, ts, false, nil, fakeCertList,
+		fakeRootCAMap, nil)
+	expected.Sha256Fingerprint = summary.Sha256Fingerprint
+	expected.NotBefore = "Jan 1 2014"
+	expected.NotAfter = "Jan 2 2014"
+	expected.NotBeforeTime = notBefore
+	expected.NotAfterTime = notBefore.AddDate(0, 0, 1)
+	expected.KeySize = 1024
+	expected.Violations[LATE_LOGGING] = false
+	expected.Timestamp = ts
+	// The subject key identifier is a hash of the generated key, and Go leaves
+	// the authority key identifier out of self-signed certs.
+	expected.SubjectKeyId = summary.SubjectKeyId
+	expected.AuthorityKeyId = ""
+	expected.LoggingDelay = int64(time.Hour / time.Millisecond)
+	b, _ = json.MarshalIndent(summary, "", "  ")
+	expected_b, _ = json.MarshalIndent(expected, "", "  ")
+	if summary.SubjectKeyId == "" || !bytes.Equal(expected_b, b) {
+		t.Errorf("Didn't get expected summary of generated cert: %s \n!= \n%s\n",
+			expected_b, b)
+	}
+}
+
+func TestCertSummaryJSONRoundTrip(t *testing.T) {
+	pemBlock, _ := pem.Decode([]byte(pemCertificate))
+	cert, _ := x509.ParseCertificate(pemBlock.Bytes)
+	summary, err := CalculateCertSummary(cert, 7, 1402580730123, false, nil,
+		nil, nil, nil)
+	if err != nil {
+		t.Fatal("could not summarize cert", err)
+	}
+	marshalled, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatal("could not marshal summary", err)
+	}
+	if !bytes.Contains(marshalled, []byte(`"NotBeforeTime":"1970-01-01T00:16:40Z"`)) {
+		t.Errorf("Expected an RFC 3339 NotBeforeTime:\n%s", marshalled)
+	}
+	var decoded CertSummary
+	if err := json.Unmarshal(marshalled, &decoded); err != nil {
+		t.Fatal("could not unmarshal summary", err)
+	}
+	if !reflect.DeepEqual(&decoded, summary) {
+		t.Errorf("Round trip changed the summary:\n%+v\n!=\n%+v", decoded, *summary)
+	}
+	if !decoded.NotAfterTime.Equal(cert.NotAfter) {
+		t.Errorf("Expected NotAfterTime %s, got %s", cert.NotAfter,
+			decoded.NotAfterTime)
+	}
 }
 
 func TestIssuerReputation(t *testing.T) {
-	ts := uint64(time.Now().Unix())
+	ts := uint64(time.Now().Unix()) * 1000
 	summary := CertSummary{
 		CN:                "example.com",
 		Issuer:            "CN=Honest Al",
@@ -143,18 +269,22 @@ func TestIssuerReputation(t *testing.T) {
 			MISSING_CN_IN_SAN: {
 				NormalizedScore: 0.9,
 				RawScore:        0,
+				ViolatingCount:  2,
 			},
 			VALID_PERIOD_TOO_LONG: {
 				NormalizedScore: 0.9,
 				RawScore:        0,
+				ViolatingCount:  2,
 			},
 		},
-		IsCA:            0,
-		NormalizedScore: 0.9666667,
-		RawScore:        0.6666667,
-		NormalizedCount: 1,
-		RawCount:        2,
-		BeginTime:       TruncateMonth(ts),
+		IsCA:               0,
+		NormalizedScore:    0.9666667,
+		RawScore:           0.6666667,
+		NormalizedCount:    1,
+		RawCount:           2,
+		ViolatingCount:     2,
+		ReputationVariance: 0.0025000002,
+		BeginTime:          TruncateMonth(ts),
 	}
 	b, _ := json.MarshalIndent(issuer, "", "  ")
 	expected_b, _ := json.MarshalIndent(expected_issuer, "", "  ")
@@ -162,3 +292,1707 @@ func TestIssuerReputation(t *testing.T) {
 		t.Errorf("Didn't get expected reputation: %s \n!= \n%s\n", expected_b, b)
 	}
 }
+
+func TestIssuerReputationWeights(t *testing.T) {
+	ts := uint64(1402580730123)
+	weights, err := ParseWeights("DeprecatedSignatureAlgorithm=3, MissingCNInSan=1")
+	if err != nil {
+		t.Fatal("could not parse weights", err)
+	}
+	config := &RuleConfig{Weights: weights}
+	newIssuer := func() *IssuerReputation {
+		issuer := NewIssuerReputation(pkix.Name{CommonName: "Example CA"}, ts)
+		// Half of the certs are signed with SHA-1, and none are missing their
+		// CN in the SAN.
+		for i := 0; i < 4; i++ {
+			issuer.Update(&CertSummary{
+				MaxReputation: -1,
+				Violations: map[string]bool{
+					DEPRECATED_SIGNATURE_ALGORITHM: i%2 == 0,
+					MISSING_CN_IN_SAN:              false,
+				},
+			})
+		}
+		return issuer
+	}
+	equal := newIssuer()
+	equal.Finish()
+	if equal.RawScore != 0.75 {
+		t.Errorf("Expected an equally weighted raw score of 0.75, got %f",
+			equal.RawScore)
+	}
+	weighted := newIssuer()
+	weighted.FinishWeighted(config)
+	// (3 * 0.5 + 1 * 1) / 4
+	if weighted.RawScore != 0.625 {
+		t.Errorf("Expected a weighted raw score of 0.625, got %f",
+			weighted.RawScore)
+	}
+	if weighted.Scores[DEPRECATED_SIGNATURE_ALGORITHM].RawScore != 0.5 {
+		t.Error("Weights shouldn't change the scores for each violation")
+	}
+
+	// With nothing counting, nothing counts against the issuer.
+	zeroWeights, err := ParseWeights("DeprecatedSignatureAlgorithm=0, MissingCNInSan=0")
+	if err != nil {
+		t.Fatal("could not parse weights", err)
+	}
+	unweighted := newIssuer()
+	unweighted.FinishWeighted(&RuleConfig{Weights: zeroWeights})
+	if unweighted.RawScore != 1 ||
+		!math.IsNaN(float64(unweighted.NormalizedScore)) {
+		t.Errorf("Expected a raw score of 1 and no normalized score with zero "+
+			"weights, got %f and %f", unweighted.RawScore,
+			unweighted.NormalizedScore)
+	}
+	// Likewise with no scores at all, for an issuer whose certs are all for
+	// ranked domains.
+	unchecked := NewIssuerReputation(pkix.Name{CommonName: "Example CA"}, ts)
+	unchecked.Update(&CertSummary{MaxReputation: 0.5})
+	unchecked.Finish()
+	if unchecked.RawScore != 1 || unchecked.NormalizedScore != 1 {
+		t.Errorf("Expected scores of 1 with nothing checked, got %f and %f",
+			unchecked.RawScore, unchecked.NormalizedScore)
+	}
+
+	for _, list := range []string{"KeyTooShrot=1", "KeyTooShort", "KeyTooShort=-1"} {
+		if _, err := ParseWeights(list); err == nil {
+			t.Errorf("Expected an error parsing weights %q", list)
+		}
+	}
+}
+
+func TestSuspectedBulkIssuance(t *testing.T) {
+	ts := uint64(1402580730123)
+	// Almost all of the bulk issuer's certs are for domains not in Alexa, and
+	// most have a violation.
+	bulk := NewIssuerReputation(pkix.Name{CommonName: "Bulk CA"}, ts)
+	for i := 0; i < BULK_ISSUANCE_MIN_CERTS; i++ {
+		bulk.Update(&CertSummary{
+			MaxReputation: -1,
+			Violations:    map[string]bool{VALID_PERIOD_TOO_LONG: i%5 != 0},
+		})
+	}
+	bulk.Finish()
+	if !bulk.SuspectedBulkIssuance || bulk.ViolatingCount != 80 ||
+		bulk.ReputationVariance != 0 {
+		t.Errorf("Expected suspected bulk issuance with 80 violating certs "+
+			"and no variance, got %+v", bulk)
+	}
+
+	// As many violations, but for domains in Alexa.
+	ranked := NewIssuerReputation(pkix.Name{CommonName: "Ranked CA"}, ts)
+	for i := 0; i < BULK_ISSUANCE_MIN_CERTS; i++ {
+		ranked.Update(&CertSummary{
+			MaxReputation: float32(i%2) / 2,
+			Violations:    map[string]bool{VALID_PERIOD_TOO_LONG: i%5 != 0},
+		})
+	}
+	ranked.Finish()
+	// Half the reputations are 0 and half 0.5.
+	if ranked.SuspectedBulkIssuance || ranked.ReputationVariance != 0.0625 {
+		t.Errorf("Expected no suspected bulk issuance and a variance of 0.0625, "+
+			"got %+v", ranked)
+	}
+}
+
+func TestIssuerRegistrableDomains(t *testing.T) {
+	ts := uint64(1402580730123)
+	issuer := NewIssuerReputation(pkix.Name{CommonName: "Example CA"}, ts)
+	for _, names := range [][]string{
+		{"example.com", "www.example.com", "*.example.com"},
+		{"a.b.example.com"},
+		{"example.co.uk", "mail.example.co.uk"},
+		{"other.co.uk"},
+		// Neither IP addresses nor public suffixes have a registrable domain.
+		{"192.0.2.1", "co.uk"},
+	} {
+		issuer.Update(&CertSummary{MaxReputation: -1, DnsNames: names})
+	}
+	issuer.Finish()
+	if issuer.RegistrableDomains != 3 {
+		t.Errorf("Expected 3 registrable domains, got %d",
+			issuer.RegistrableDomains)
+	}
+}
+
+func TestTimestampConversions(t *testing.T) {
+	// 2014-06-12 13:45:30.123 UTC, in milliseconds.
+	const ts = 1402580730123
+	if month := TruncateMonth(ts); month != 1401580800000 {
+		t.Errorf("Expected June 2014 to begin at 1401580800000, got %d", month)
+	}
+	// The first millisecond of a month is in that month, and the last
+	// millisecond of the month before isn't.
+	if month := TruncateMonth(1401580800000); month != 1401580800000 {
+		t.Errorf("Expected the start of June 2014 to be in June, got %d", month)
+	}
+	if month := TruncateMonth(1401580799999); month != 1398902400000 {
+		t.Errorf("Expected the end of May 2014 to be in May, got %d", month)
+	}
+	if TruncateMonth(0) != 0 {
+		t.Errorf("Expected the epoch to begin a month")
+	}
+	converted := TimestampToTime(ts)
+	expected := time.Date(2014, 6, 12, 13, 45, 30, 123*int(time.Millisecond), time.UTC)
+	if !converted.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, converted)
+	}
+	pacific := time.FixedZone("PDT", -7*60*60)
+	if s := TimeToJSONString(time.Date(2014, 6, 30, 20, 0, 0, 0, pacific)); s != "Jul 1 2014" {
+		t.Errorf("Expected the UTC date Jul 1 2014, got %s", s)
+	}
+}
+
+var testKey = testcerts.Key
+
+// Creates a certificate from template, self-signed with testKey.
+func makeCert(t testing.TB, template *x509.Certificate) *x509.Certificate {
+	return makeCertWithKey(t, template, &testKey.PublicKey)
+}
+
+// Creates a self-issued certificate for the public key pub from template,
+// signed with testKey.
+func makeCertWithKey(t testing.TB, template *x509.Certificate,
+	pub interface{}) *x509.Certificate {
+	return issueCert(t, template, template, pub)
+}
+
+// Creates a certificate for the public key pub from template, issued by
+// parent and signed with testKey.
+func issueCert(t testing.TB, template *x509.Certificate,
+	parent *x509.Certificate, pub interface{}) *x509.Certificate {
+	return testcerts.Sign(t, template, parent, pub)
+}
+
+func TestFutureNotBefore(t *testing.T) {
+	logged := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	ts := uint64(logged.Unix()) * 1000
+	notBefores := map[time.Time]bool{
+		logged.Add(-time.Hour):     false,
+		logged.Add(12 * time.Hour): false,
+		logged.Add(48 * time.Hour): true,
+	}
+	for notBefore, expected := range notBefores {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "future.example.com"},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{"future.example.com"},
+		})
+		summary, _ := CalculateCertSummary(cert, 0, ts, false, nil, nil, nil, nil)
+		if summary.Violations[FUTURE_NOT_BEFORE] != expected {
+			t.Errorf("NotBefore %s logged at %s: expected FutureNotBefore %t",
+				notBefore, logged, expected)
+		}
+	}
+
+	// Without a log time, nothing is in the future.
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "future.example.com"},
+		NotBefore: logged,
+		NotAfter:  logged.AddDate(1, 0, 0),
+		DNSNames:  []string{"future.example.com"},
+	})
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	if summary.Violations[FUTURE_NOT_BEFORE] {
+		t.Error("Expected no FutureNotBefore without a log time")
+	}
+}
+
+func TestLogIndex(t *testing.T) {
+	pemBlock, _ := pem.Decode([]byte(pemCertificate))
+	cert, _ := x509.ParseCertificate(pemBlock.Bytes)
+	summary, _ := CalculateCertSummary(cert, 1234567, 0, false, nil, nil, nil, nil)
+	if summary.LogIndex != 1234567 {
+		t.Errorf("Expected log index 1234567, got %d", summary.LogIndex)
+	}
+	b, _ := json.Marshal(summary)
+	var decoded CertSummary
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal("could not decode summary", err)
+	}
+	if decoded.LogIndex != 1234567 {
+		t.Errorf("Log index not preserved in JSON, got %d", decoded.LogIndex)
+	}
+}
+
+func TestRankIssuers(t *testing.T) {
+	issuers := []*IssuerReputation{
+		{Issuer: "CN=Tiny Bad CA", NormalizedScore: 0.0, NormalizedCount: 1},
+		{Issuer: "CN=Big Sloppy CA", NormalizedScore: 0.5, NormalizedCount: 10000},
+		{Issuer: "CN=Mostly Fine CA", NormalizedScore: 0.9, NormalizedCount: 5000},
+	}
+	expectedOrders := map[float32][]string{
+		0: {"CN=Tiny Bad CA", "CN=Big Sloppy CA", "CN=Mostly Fine CA"},
+		1: {"CN=Big Sloppy CA", "CN=Mostly Fine CA", "CN=Tiny Bad CA"},
+	}
+	for countWeight, expected := range expectedOrders {
+		ranks := RankIssuers(issuers, countWeight)
+		if len(ranks) != len(expected) {
+			t.Fatalf("Expected %d ranks, got %d", len(expected), len(ranks))
+		}
+		for i, rank := range ranks {
+			if rank.Reputation.Issuer != expected[i] || rank.Rank != i+1 {
+				t.Errorf("With count weight %f, expected %s at rank %d, got %s at %d",
+					countWeight, expected[i], i+1, rank.Reputation.Issuer, rank.Rank)
+			}
+		}
+	}
+}
+
+func TestWeakRSAModulus(t *testing.T) {
+	goodKey := testcerts.RSAKey(t, 1024)
+	evenModulus := new(big.Int).Lsh(goodKey.N, 1)
+	// 2999 is the largest prime below SMALL_PRIME_LIMIT.
+	smallFactor := new(big.Int).Mul(goodKey.N, big.NewInt(2999))
+	square := new(big.Int).Mul(goodKey.Primes[0], goodKey.Primes[0])
+	moduli := map[*big.Int]bool{
+		goodKey.N:   false,
+		evenModulus: true,
+		smallFactor: true,
+		square:      true,
+	}
+	for modulus, expected := range moduli {
+		cert := makeCertWithKey(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "rsa.example.com"},
+			NotBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:  time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+			DNSNames:  []string{"rsa.example.com"},
+		}, &rsa.PublicKey{N: modulus, E: 65537})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[WEAK_RSA_MODULUS] != expected {
+			t.Errorf("Modulus %x: expected WeakRSAModulus %t", modulus, expected)
+		}
+	}
+}
+
+func TestNonstandardRSAExponent(t *testing.T) {
+	key := testcerts.RSAKey(t, 1024)
+	for _, test := range []struct {
+		exponent    int
+		nonstandard bool
+		tooSmall    bool
+	}{
+		{3, true, true},
+		{17, true, false},
+		{65537, false, false},
+	} {
+		cert := makeCertWithKey(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "rsa.example.com"},
+			NotBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:  time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+			DNSNames:  []string{"rsa.example.com"},
+		}, &rsa.PublicKey{N: key.N, E: test.exponent})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[NONSTANDARD_RSA_EXPONENT] != test.nonstandard {
+			t.Errorf("e=%d: expected NonstandardRSAExponent %t", test.exponent,
+				test.nonstandard)
+		}
+		if summary.Violations[EXP_TOO_SMALL] != test.tooSmall {
+			t.Errorf("e=%d: expected ExpTooSmall %t", test.exponent, test.tooSmall)
+		}
+	}
+}
+
+func TestMissingOCSP(t *testing.T) {
+	template := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "aia.example.com"},
+		NotBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:  []string{"aia.example.com"},
+	}
+	summary, _ := CalculateCertSummary(makeCert(t, template), 0, 0, false, nil,
+		nil, nil, nil)
+	if !summary.Violations[MISSING_OCSP] || summary.OCSPServers != nil {
+		t.Errorf("Expected MissingOCSP and no OCSP servers, got %+v", summary)
+	}
+
+	template.OCSPServer = []string{"http://ocsp.example.com"}
+	template.IssuingCertificateURL = []string{"http://ca.example.com/ca.crt"}
+	summary, _ = CalculateCertSummary(makeCert(t, template), 0, 0, false, nil,
+		nil, nil, nil)
+	if summary.Violations[MISSING_OCSP] {
+		t.Error("Unexpected MissingOCSP for a cert with an OCSP server")
+	}
+	if !reflect.DeepEqual(summary.OCSPServers, template.OCSPServer) ||
+		!reflect.DeepEqual(summary.IssuingCertificateURLs,
+			template.IssuingCertificateURL) {
+		t.Errorf("Expected AIA URLs %v and %v, got %v and %v",
+			template.OCSPServer, template.IssuingCertificateURL,
+			summary.OCSPServers, summary.IssuingCertificateURLs)
+	}
+}
+
+func TestMissingCRL(t *testing.T) {
+	template := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "crl.example.com"},
+		NotBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:  []string{"crl.example.com"},
+	}
+	summary, _ := CalculateCertSummary(makeCert(t, template), 0, 0, false, nil,
+		nil, nil, nil)
+	if !summary.Violations[MISSING_CRL] || summary.CRLDistributionPoints != nil {
+		t.Errorf("Expected MissingCRL and no CRL URLs, got %+v", summary)
+	}
+
+	template.CRLDistributionPoints = []string{"http://crl.example.com/ca.crl"}
+	summary, _ = CalculateCertSummary(makeCert(t, template), 0, 0, false, nil,
+		nil, nil, nil)
+	if summary.Violations[MISSING_CRL] {
+		t.Error("Unexpected MissingCRL for a cert with a CRL distribution point")
+	}
+	if !reflect.DeepEqual(summary.CRLDistributionPoints,
+		template.CRLDistributionPoints) {
+		t.Errorf("Expected CRL URLs %v, got %v", template.CRLDistributionPoints,
+			summary.CRLDistributionPoints)
+	}
+}
+
+func TestNormalizeDnsNames(t *testing.T) {
+	raw := []string{"WWW.Example.COM.", "www.example.com"}
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "www.example.com"},
+		NotBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:  raw,
+	})
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	expected := []string{"www.example.com", "www.example.com"}
+	if !reflect.DeepEqual(summary.DnsNames, expected) {
+		t.Errorf("Expected DnsNames %v, got %v", expected, summary.DnsNames)
+	}
+	if !reflect.DeepEqual(summary.RawDnsNames, raw) {
+		t.Errorf("Expected RawDnsNames %v, got %v", raw, summary.RawDnsNames)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	checks, err := ParseChecks(DEPRECATED_SIGNATURE_ALGORITHM)
+	if err != nil {
+		t.Fatal("could not parse checks", err)
+	}
+	pemBlock, _ := pem.Decode([]byte(pemCertificate))
+	cert, _ := x509.ParseCertificate(pemBlock.Bytes)
+	config := &RuleConfig{Checks: checks}
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, config)
+	// The test cert also has a key that's too short, but that isn't checked.
+	expected := map[string]bool{DEPRECATED_SIGNATURE_ALGORITHM: true}
+	if !reflect.DeepEqual(summary.Violations, expected) {
+		t.Errorf("Expected violations %v, got %v", expected, summary.Violations)
+	}
+
+	if _, err := ParseChecks("DeprecatedSignatureAlgorithm,KeyTooShrot"); err == nil {
+		t.Error("Expected an error for an unknown violation name")
+	}
+}
+
+func TestNoSANExtension(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	leaf := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "nosan.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+	})
+	summary, _ := CalculateCertSummary(leaf, 0, 0, false, nil, nil, nil, nil)
+	if !summary.Violations[NO_SAN_EXTENSION] {
+		t.Error("Leaf cert without a SAN should be flagged")
+	}
+
+	ca := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	})
+	summary, _ = CalculateCertSummary(ca, 0, 0, false, nil, nil, nil, nil)
+	if summary.Violations[NO_SAN_EXTENSION] {
+		t.Error("CA cert without a SAN shouldn't be flagged")
+	}
+}
+
+func TestUnknownSignatureAlgorithm(t *testing.T) {
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "sig.example.com"},
+		NotBefore: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:  []string{"sig.example.com"},
+	})
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	if summary.Violations[UNKNOWN_SIGNATURE_ALGORITHM] {
+		t.Error("ECDSA with SHA-256 should be a known signature algorithm")
+	}
+
+	// Turn ecdsa-with-SHA256 (1.2.840.10045.4.3.2) into the unassigned
+	// 1.2.840.10045.4.3.9, both in the TBSCertificate and outside it.
+	ecdsaWithSHA256, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2})
+	unknown, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 9})
+	der := bytes.Replace(cert.Raw, ecdsaWithSHA256, unknown, -1)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("could not parse cert with an unknown signature algorithm", err)
+	}
+	summary, _ = CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	if !summary.Violations[UNKNOWN_SIGNATURE_ALGORITHM] {
+		t.Error("Unknown signature algorithm should be flagged")
+	}
+	if summary.SignatureAlgorithm != 0 {
+		t.Errorf("Expected SignatureAlgorithm 0, got %d", summary.SignatureAlgorithm)
+	}
+}
+
+func TestWildcardCoversCN(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		cn      string
+		strict  bool
+		missing bool
+	}{
+		{"www.example.com", false, false},
+		{"www.example.com", true, true},
+		// A wildcard only covers a single label.
+		{"a.b.example.com", false, true},
+		{"example.com", false, true},
+		{"www.example.org", false, true},
+	}
+	for _, test := range tests {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: test.cn},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{"*.example.com"},
+		})
+		config := &RuleConfig{StrictCNInSAN: test.strict}
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, config)
+		if summary.Violations[MISSING_CN_IN_SAN] != test.missing {
+			t.Errorf("CN %s (strict %t): expected MissingCNInSan %t",
+				test.cn, test.strict, test.missing)
+		}
+	}
+}
+
+func TestCNInSANIgnoresCaseAndTrailingDot(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		cn      string
+		san     string
+		missing bool
+	}{
+		{"www.Example.com", "www.example.com.", false},
+		{"www.example.com.", "WWW.EXAMPLE.COM", false},
+		{"www.Example.com", "*.example.com.", false},
+		{"www.example.com", "www.example.com..", true},
+	}
+	for _, test := range tests {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: test.cn},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{test.san},
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[MISSING_CN_IN_SAN] != test.missing {
+			t.Errorf("CN %s, SAN %s: expected MissingCNInSan %t", test.cn,
+				test.san, test.missing)
+		}
+	}
+}
+
+func TestKeyIdentifierMismatch(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	issuer := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	})
+	chain := []*x509.Certificate{issuer}
+	for _, test := range []struct {
+		aki      []byte
+		mismatch bool
+	}{
+		{[]byte{1, 2, 3, 4}, false},
+		{[]byte{5, 6, 7, 8}, true},
+	} {
+		leaf := makeCert(t, &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "aki.example.com"},
+			NotBefore:      notBefore,
+			NotAfter:       notBefore.AddDate(1, 0, 0),
+			DNSNames:       []string{"aki.example.com"},
+			AuthorityKeyId: test.aki,
+		})
+		summary, _ := CalculateCertSummary(leaf, 0, 0, false, nil, chain, nil, nil)
+		if summary.AuthorityKeyId != hex.EncodeToString(test.aki) {
+			t.Errorf("Expected AuthorityKeyId %x, got %s", test.aki,
+				summary.AuthorityKeyId)
+		}
+		if summary.Violations[KEY_IDENTIFIER_MISMATCH] != test.mismatch {
+			t.Errorf("AKI %x: expected KeyIdentifierMismatch %t", test.aki,
+				test.mismatch)
+		}
+	}
+	if hex.EncodeToString(issuer.SubjectKeyId) != "01020304" {
+		t.Errorf("Unexpected issuer SKI %x", issuer.SubjectKeyId)
+	}
+}
+
+func TestMultipleCN(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, names := range [][]string{
+		{"example.com"},
+		{"example.com", "evil.example.net"},
+	} {
+		subject := pkix.Name{}
+		for _, name := range names {
+			subject.ExtraNames = append(subject.ExtraNames,
+				pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 3},
+					Value: name})
+		}
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   subject,
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  names,
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[MULTIPLE_CN] != (len(names) > 1) {
+			t.Errorf("%v: expected MultipleCN %t", names, len(names) > 1)
+		}
+	}
+}
+
+func TestMissingAKI(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	})
+	if len(root.AuthorityKeyId) != 0 {
+		t.Fatalf("Expected the self-signed root to have no AKI")
+	}
+	withoutSKI := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "Test Issuer"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(10, 0, 0),
+	})
+	template := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "aki.example.com"},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{"aki.example.com"},
+		}
+	}
+	for _, test := range []struct {
+		name    string
+		cert    *x509.Certificate
+		missing bool
+	}{
+		{"self-signed root", root, false},
+		// Go sets the AKI from the issuer's SKI, and root has one since
+		// it's a CA.
+		{"leaf with AKI", issueCert(t, template(), root, &testKey.PublicKey), false},
+		{"leaf without AKI", issueCert(t, template(), withoutSKI, &testKey.PublicKey), true},
+	} {
+		summary, _ := CalculateCertSummary(test.cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[MISSING_AKI] != test.missing {
+			t.Errorf("%s: expected MissingAKI %t", test.name, test.missing)
+		}
+	}
+}
+
+func TestValidationLevel(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		subject  pkix.Name
+		policies []asn1.ObjectIdentifier
+		level    string
+	}{
+		{pkix.Name{CommonName: "dv.example.com"}, nil, VALIDATION_DV},
+		{pkix.Name{CommonName: "ov.example.com",
+			Organization: []string{"Example Inc"}}, nil, VALIDATION_OV},
+		{pkix.Name{CommonName: "ev.example.com",
+			Organization: []string{"Example Inc"}},
+			[]asn1.ObjectIdentifier{{2, 23, 140, 1, 1}}, VALIDATION_EV},
+	} {
+		var policies []x509.OID
+		for _, policy := range test.policies {
+			arcs := make([]uint64, len(policy))
+			for i, arc := range policy {
+				arcs[i] = uint64(arc)
+			}
+			oid, err := x509.OIDFromInts(arcs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policies = append(policies, oid)
+		}
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   test.subject,
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{test.subject.CommonName},
+			Policies:  policies,
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.ValidationLevel != test.level {
+			t.Errorf("%s: expected validation level %s, got %s",
+				test.subject.CommonName, test.level, summary.ValidationLevel)
+		}
+		if summary.Violations[MISSING_CERT_POLICY] != (len(test.policies) == 0) {
+			t.Errorf("%s: expected MissingCertPolicy %t", test.subject.CommonName,
+				len(test.policies) == 0)
+		}
+		if len(summary.PolicyOIDs) != len(test.policies) ||
+			len(test.policies) > 0 && summary.PolicyOIDs[0] != "2.23.140.1.1" {
+			t.Errorf("%s: unexpected policy OIDs %v", test.subject.CommonName,
+				summary.PolicyOIDs)
+		}
+	}
+}
+
+func TestLeafOutlivesIssuer(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	})
+	intermediate := issueCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, root, &testKey.PublicKey)
+	chain := []*x509.Certificate{intermediate, root}
+	for _, test := range []struct {
+		notAfter time.Time
+		outlives bool
+	}{
+		{notBefore.AddDate(0, 6, 0), false},
+		{notBefore.AddDate(2, 0, 0), true},
+	} {
+		leaf := issueCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "leaf.example.com"},
+			NotBefore: notBefore,
+			NotAfter:  test.notAfter,
+			DNSNames:  []string{"leaf.example.com"},
+		}, intermediate, &testKey.PublicKey)
+		summary, _ := CalculateCertSummary(leaf, 0, 0, false, nil, chain, nil, nil)
+		if summary.Violations[LEAF_OUTLIVES_ISSUER] != test.outlives {
+			t.Errorf("NotAfter %s: expected LeafOutlivesIssuer %t",
+				test.notAfter, test.outlives)
+		}
+	}
+}
+
+func TestIllegalDNSCharacter(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, illegal := range map[string]bool{
+		"_dmarc.example.com":   true,
+		"foo bar.com":          true,
+		"www.example.com":      false,
+		"*.example.com":        false,
+		"xn--bcher-kva.com":    false,
+		"www.*.example.com":    true,
+		"under_score.test.com": true,
+	} {
+		cert := makeCert(t, &x509.Certificate{
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{name},
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[ILLEGAL_DNS_CHARACTER] != illegal {
+			t.Errorf("%q: expected IllegalDNSCharacter %t", name, illegal)
+		}
+	}
+}
+
+func TestMisplacedWildcard(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, misplaced := range map[string]bool{
+		"*.example.com":             false,
+		"*.a.b.c.d.e.f.example.com": false,
+		"www.example.com":           false,
+		"a.*.example.com":           true,
+		"**.example.com":            true,
+		"a*.example.com":            true,
+		"*.*.example.com":           true,
+	} {
+		cert := makeCert(t, &x509.Certificate{
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{name},
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[MISPLACED_WILDCARD] != misplaced {
+			t.Errorf("%q: expected MisplacedWildcard %t", name, misplaced)
+		}
+	}
+}
+
+// Returns a subject alternative name extension with just a user principal
+// name otherName, as in Microsoft smart card certs.
+func otherNameSAN(t *testing.T, upn string) pkix.Extension {
+	value, _ := asn1.Marshal(upn)
+	otherName, err := asn1.Marshal(struct {
+		TypeID asn1.ObjectIdentifier
+		Value  asn1.RawValue `asn1:"explicit,tag:0"`
+	}{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3},
+		asn1.RawValue{FullBytes: value}})
+	if err != nil {
+		t.Fatal("could not marshal otherName", err)
+	}
+	var sequence asn1.RawValue
+	asn1.Unmarshal(otherName, &sequence)
+	san, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific,
+		Tag: 0, IsCompound: true, Bytes: sequence.Bytes}})
+	if err != nil {
+		t.Fatal("could not marshal subject alternative name", err)
+	}
+	return pkix.Extension{Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: san}
+}
+
+func TestForbiddenSANType(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	uri, _ := url.Parse("https://www.example.com/")
+	serverAuth := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, test := range []struct {
+		description string
+		template    *x509.Certificate
+		forbidden   bool
+	}{
+		{"email SAN", &x509.Certificate{
+			DNSNames:       []string{"www.example.com"},
+			EmailAddresses: []string{"admin@example.com"},
+			ExtKeyUsage:    serverAuth,
+		}, true},
+		{"URI SAN", &x509.Certificate{
+			URIs:        []*url.URL{uri},
+			ExtKeyUsage: serverAuth,
+		}, true},
+		{"otherName SAN", &x509.Certificate{
+			ExtraExtensions: []pkix.Extension{otherNameSAN(t, "admin@example.com")},
+			ExtKeyUsage:     serverAuth,
+		}, true},
+		{"email SAN in an S/MIME cert", &x509.Certificate{
+			EmailAddresses: []string{"admin@example.com"},
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		}, false},
+		{"DNS SAN", &x509.Certificate{
+			DNSNames:    []string{"www.example.com"},
+			ExtKeyUsage: serverAuth,
+		}, false},
+	} {
+		test.template.Subject = pkix.Name{CommonName: "www.example.com"}
+		test.template.NotBefore = notBefore
+		test.template.NotAfter = notBefore.AddDate(1, 0, 0)
+		summary, _ := CalculateCertSummary(makeCert(t, test.template), 0, 0,
+			false, nil, nil, nil, nil)
+		if summary.Violations[FORBIDDEN_SAN_TYPE] != test.forbidden {
+			t.Errorf("%s: expected ForbiddenSANType %t", test.description,
+				test.forbidden)
+		}
+		if !reflect.DeepEqual(summary.EmailAddresses, test.template.EmailAddresses) {
+			t.Errorf("%s: expected email addresses %v, got %v", test.description,
+				test.template.EmailAddresses, summary.EmailAddresses)
+		}
+		if len(summary.URIs) != len(test.template.URIs) {
+			t.Errorf("%s: expected URIs %v, got %v", test.description,
+				test.template.URIs, summary.URIs)
+		}
+	}
+}
+
+func TestMixedWildcardAndIP(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		dnsNames []string
+		ips      []net.IP
+		mixed    bool
+	}{
+		{[]string{"*.example.com"}, []net.IP{net.ParseIP("192.0.2.1")}, true},
+		{[]string{"www.example.com"}, []net.IP{net.ParseIP("192.0.2.1")}, false},
+		{[]string{"*.example.com"}, nil, false},
+	} {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "example.com"},
+			NotBefore:   notBefore,
+			NotAfter:    notBefore.AddDate(1, 0, 0),
+			DNSNames:    test.dnsNames,
+			IPAddresses: test.ips,
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[MIXED_WILDCARD_AND_IP] != test.mixed {
+			t.Errorf("%v and %v: expected MixedWildcardAndIP %t", test.dnsNames,
+				test.ips, test.mixed)
+		}
+	}
+}
+
+// Re-encodes cert as the given version, keeping its extensions only if
+// extensions is set, and signs it again with testKey. x509.CreateCertificate
+// only makes v3 certs.
+func withVersion(t *testing.T, cert *x509.Certificate, version int,
+	extensions bool) *x509.Certificate {
+	var tbs, outer asn1.RawValue
+	var sigAlg asn1.RawValue
+	if _, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err != nil {
+		t.Fatal("could not parse TBSCertificate", err)
+	}
+	if _, err := asn1.Unmarshal(cert.Raw, &outer); err != nil {
+		t.Fatal("could not parse certificate", err)
+	}
+	rest, _ := asn1.Unmarshal(outer.Bytes, &asn1.RawValue{})
+	asn1.Unmarshal(rest, &sigAlg)
+
+	var fields []byte
+	// v1 is the default, so its version is left out.
+	if version > 1 {
+		fields = append(fields, 0xa0, 0x03, 0x02, 0x01, byte(version-1))
+	}
+	for remaining := tbs.Bytes; len(remaining) > 0; {
+		var field asn1.RawValue
+		remaining, _ = asn1.Unmarshal(remaining, &field)
+		if field.Class == asn1.ClassContextSpecific &&
+			(field.Tag == 0 || field.Tag == 3 && !extensions) {
+			continue
+		}
+		fields = append(fields, field.FullBytes...)
+	}
+	tbsBytes, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence,
+		IsCompound: true, Bytes: fields})
+	digest := sha256.Sum256(tbsBytes)
+	signature, err := ecdsa.SignASN1(rand.Reader, testKey, digest[:])
+	if err != nil {
+		t.Fatal("could not sign certificate", err)
+	}
+	der, _ := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		SigAlg    asn1.RawValue
+		Signature asn1.BitString
+	}{asn1.RawValue{FullBytes: tbsBytes}, sigAlg,
+		asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)}})
+	reversioned, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("could not parse re-encoded certificate", err)
+	}
+	return reversioned
+}
+
+func TestDeprecatedVersion(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	v3 := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"example.com"},
+	})
+	for _, test := range []struct {
+		version    int
+		extensions bool
+		deprecated bool
+		malformed  bool
+	}{
+		{1, false, true, false},
+		{2, false, true, false},
+		{1, true, true, true},
+		{2, true, true, true},
+		{3, true, false, false},
+	} {
+		cert := withVersion(t, v3, test.version, test.extensions)
+		summary, err := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatal("could not summarize cert", err)
+		}
+		if summary.Version != test.version {
+			t.Errorf("Expected version %d, got %d", test.version, summary.Version)
+		}
+		if summary.Violations[DEPRECATED_VERSION] != test.deprecated ||
+			summary.Violations[EXTENSIONS_BEFORE_V3] != test.malformed {
+			t.Errorf("v%d, extensions %t: expected DeprecatedVersion %t and "+
+				"ExtensionsBeforeV3 %t, got %v", test.version, test.extensions,
+				test.deprecated, test.malformed, summary.Violations)
+		}
+	}
+}
+
+func TestPublicSuffixSAN(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, publicSuffix := range map[string]bool{
+		"com":               true,
+		"co.uk":             true,
+		"CO.UK.":            true,
+		"*.com":             true,
+		"example.co.uk":     false,
+		"*.example.com":     false,
+		"example.github.io": false,
+		"github.io":         false,
+		"localhost":         false,
+	} {
+		cert := makeCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "Example"},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{name},
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[PUBLIC_SUFFIX_SAN] != publicSuffix {
+			t.Errorf("%q: expected PublicSuffixSAN %t", name, publicSuffix)
+		}
+	}
+}
+
+func TestReservedTLD(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	evPolicy, err := x509.OIDFromInts([]uint64{2, 23, 140, 1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		ev       bool
+		reserved bool
+	}{
+		{"foo.test", false, true},
+		{"bar.invalid", false, true},
+		{"www.example.com", false, false},
+		{"FOO.TEST.", false, true},
+		{"example.onion", false, true},
+		// Onion names are allowed in EV certs.
+		{"example.onion", true, false},
+	} {
+		template := &x509.Certificate{
+			Subject:   pkix.Name{CommonName: test.name},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{test.name},
+		}
+		if test.ev {
+			template.Policies = []x509.OID{evPolicy}
+		}
+		cert := makeCert(t, template)
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[RESERVED_TLD] != test.reserved {
+			t.Errorf("%s (EV %t): expected ReservedTLD %t", test.name, test.ev,
+				test.reserved)
+		}
+	}
+}
+
+func TestLateLogging(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "late.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"late.example.com"},
+	})
+	for _, test := range []struct {
+		logged time.Time
+		late   bool
+	}{
+		{notBefore.Add(time.Hour), false},
+		{notBefore.Add(-time.Hour), false},
+		{notBefore.AddDate(0, 3, 0), true},
+	} {
+		timestamp := uint64(test.logged.Unix()) * 1000
+		summary, _ := CalculateCertSummary(cert, 0, timestamp, false, nil, nil,
+			nil, nil)
+		if summary.Violations[LATE_LOGGING] != test.late {
+			t.Errorf("Logged at %s: expected LateLogging %t", test.logged, test.late)
+		}
+		delay := int64(test.logged.Sub(notBefore) / time.Millisecond)
+		if summary.LoggingDelay != delay {
+			t.Errorf("Logged at %s: expected a delay of %d, got %d", test.logged,
+				delay, summary.LoggingDelay)
+		}
+	}
+	// Certs that weren't logged have no delay.
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	if summary.LoggingDelay != 0 || summary.Violations[LATE_LOGGING] {
+		t.Errorf("Unexpected delay %d for an unlogged cert", summary.LoggingDelay)
+	}
+}
+
+func TestEmptySubjectNoSAN(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := makeCert(t, &x509.Certificate{
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(6, 0, 0),
+	})
+	summary, err := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	if err != nil || summary == nil {
+		t.Fatalf("Expected a summary, got %v", err)
+	}
+	if !summary.Violations[EMPTY_SUBJECT_NO_SAN] {
+		t.Error("Expected EmptySubjectNoSAN")
+	}
+	// The other checks still happen.
+	if !summary.Violations[VALID_PERIOD_TOO_LONG] {
+		t.Error("Expected ValidPeriodTooLong")
+	}
+	if summary.Violations[MISSING_CN_IN_SAN] {
+		t.Error("A missing CN can't be missing from the SAN")
+	}
+
+	cert = makeCert(t, &x509.Certificate{
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"nameless.example.com"},
+	})
+	summary, _ = CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+	if summary.Violations[EMPTY_SUBJECT_NO_SAN] {
+		t.Error("A cert with a SAN has a name")
+	}
+}
+
+func TestSHA1InChain(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "SHA-1 Root"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(20, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		SignatureAlgorithm:    x509.ECDSAWithSHA1,
+	})
+	rootCAMap := map[string]bool{"CN=SHA-1 Root": true}
+	intermediate := func(algorithm x509.SignatureAlgorithm) *x509.Certificate {
+		return issueCert(t, &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "Test Intermediate"},
+			NotBefore:             notBefore,
+			NotAfter:              notBefore.AddDate(5, 0, 0),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			SignatureAlgorithm:    algorithm,
+		}, root, &testKey.PublicKey)
+	}
+	for _, test := range []struct {
+		name      string
+		chain     []*x509.Certificate
+		rootCAMap map[string]bool
+		sha1      bool
+	}{
+		{"SHA-256 intermediate under a SHA-1 root",
+			[]*x509.Certificate{intermediate(x509.ECDSAWithSHA256), root}, rootCAMap, false},
+		{"SHA-1 intermediate", []*x509.Certificate{intermediate(x509.ECDSAWithSHA1), root},
+			rootCAMap, true},
+		// Without the root program, the self-signed cert is just another
+		// SHA-1 CA.
+		{"unknown SHA-1 root", []*x509.Certificate{intermediate(x509.ECDSAWithSHA256), root},
+			nil, true},
+	} {
+		if test.chain[0].SignatureAlgorithm == x509.UnknownSignatureAlgorithm {
+			t.Fatalf("%s: couldn't create the intermediate", test.name)
+		}
+		leaf := issueCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: "leaf.example.com"},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  []string{"leaf.example.com"},
+		}, test.chain[0], &testKey.PublicKey)
+		summary, _ := CalculateCertSummary(leaf, 0, 0, false, nil, test.chain,
+			test.rootCAMap, nil)
+		if summary.Violations[SHA1_IN_CHAIN] != test.sha1 {
+			t.Errorf("%s: expected SHA1InChain %t", test.name, test.sha1)
+		}
+	}
+}
+
+func TestPathLenExceeded(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            -1,
+	})
+	intermediate := issueCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}, root, &testKey.PublicKey)
+	subCA := issueCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Sub-CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, intermediate, &testKey.PublicKey)
+	leafTemplate := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "leaf.example.com"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"leaf.example.com"},
+	}
+
+	// The sub-CA is an intermediate below a pathLen:0 CA.
+	leaf := issueCert(t, leafTemplate, subCA, &testKey.PublicKey)
+	summary, _ := CalculateCertSummary(leaf, 0, 0, false, nil,
+		[]*x509.Certificate{subCA, intermediate, root}, nil, nil)
+	if !summary.Violations[PATHLEN_EXCEEDED] {
+		t.Error("Expected PathLenExceeded below a sub-CA")
+	}
+
+	leaf = issueCert(t, leafTemplate, intermediate, &testKey.PublicKey)
+	summary, _ = CalculateCertSummary(leaf, 0, 0, false, nil,
+		[]*x509.Certificate{intermediate, root}, nil, nil)
+	if summary.Violations[PATHLEN_EXCEEDED] {
+		t.Error("A pathLen:0 CA may issue leaf certs")
+	}
+}
+
+func TestNameConstraintViolation(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	intermediate := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Constrained Intermediate"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(5, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		PermittedDNSDomains:   []string{"example.com"},
+		ExcludedDNSDomains:    []string{"secret.example.com"},
+	})
+	for _, test := range []struct {
+		dnsNames  []string
+		violation bool
+	}{
+		{[]string{"example.com", "www.Example.com."}, false},
+		{[]string{"*.example.com"}, true},
+		{[]string{"*.public.example.com"}, false},
+		{[]string{"www.example.com", "www.example.org"}, true},
+		{[]string{"notexample.com"}, true},
+		{[]string{"db.secret.example.com"}, true},
+	} {
+		leaf := issueCert(t, &x509.Certificate{
+			Subject:   pkix.Name{CommonName: test.dnsNames[0]},
+			NotBefore: notBefore,
+			NotAfter:  notBefore.AddDate(1, 0, 0),
+			DNSNames:  test.dnsNames,
+		}, intermediate, &testKey.PublicKey)
+		summary, _ := CalculateCertSummary(leaf, 0, 0, false, nil,
+			[]*x509.Certificate{intermediate}, nil, nil)
+		if summary.Violations[NAME_CONSTRAINT_VIOLATION] != test.violation {
+			t.Errorf("%v: expected NameConstraintViolation %t", test.dnsNames,
+				test.violation)
+		}
+	}
+
+	// Without the chain, the constraints aren't known.
+	leaf := issueCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "www.example.org"},
+		NotBefore: notBefore,
+		NotAfter:  notBefore.AddDate(1, 0, 0),
+		DNSNames:  []string{"www.example.org"},
+	}, intermediate, &testKey.PublicKey)
+	summary, _ := CalculateCertSummary(leaf, 0, 0, false, nil, nil, nil, nil)
+	if summary.Violations[NAME_CONSTRAINT_VIOLATION] {
+		t.Error("Unexpected NameConstraintViolation without a chain")
+	}
+}
+
+// Reads testdata/corpus.pem: a root, followed by leaf certs it issued that
+// between them have a range of violations. When the corpus changes, so must
+// corpusViolations.
+func readCorpus(tb testing.TB) []*x509.Certificate {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "corpus.pem"))
+	if err != nil {
+		tb.Fatal("could not read corpus", err)
+	}
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		tb.Fatal("could not parse corpus", err)
+	}
+	return certs
+}
+
+// The violations found in each cert in the corpus.
+var corpusViolations = [][]string{
+	{MISSING_CN_IN_SAN},
+	{},
+	{VALID_PERIOD_TOO_LONG, MIXED_WILDCARD_AND_IP, MISSING_CERT_POLICY,
+		MISSING_OCSP, MISSING_CRL},
+	{DEPRECATED_SIGNATURE_ALGORITHM, MISSING_CN_IN_SAN, MISSING_CERT_POLICY,
+		MISSING_OCSP, MISSING_CRL},
+	{ILLEGAL_DNS_CHARACTER, PUBLIC_SUFFIX_SAN, RESERVED_TLD, MISSING_OCSP,
+		MISSING_CRL},
+	{NO_SAN_EXTENSION, MISSING_CERT_POLICY, MISSING_OCSP, MISSING_CRL},
+}
+
+// Summarizes each cert in the corpus, as logged with the root, so that a
+// change to what's found in it is noticed.
+func TestCorpus(t *testing.T) {
+	certs := readCorpus(t)
+	if len(certs) != len(corpusViolations) {
+		t.Fatalf("Expected %d certs in the corpus, got %d",
+			len(corpusViolations), len(certs))
+	}
+	root := certs[0]
+	rootCAMap := map[string]bool{DistinguishedNameToString(root.Subject): true}
+	for i, cert := range certs {
+		chain := []*x509.Certificate{root}
+		if cert == root {
+			chain = nil
+		}
+		logged := uint64(cert.NotBefore.Unix()) * 1000
+		summary, err := CalculateCertSummary(cert, uint64(i), logged, false, nil,
+			chain, rootCAMap, nil)
+		if err != nil {
+			t.Errorf("Couldn't summarize corpus cert %d: %s", i, err)
+			continue
+		}
+		expected := make(map[string]bool)
+		for _, name := range ViolationNames {
+			expected[name] = false
+		}
+		for _, name := range corpusViolations[i] {
+			expected[name] = true
+		}
+		if !reflect.DeepEqual(summary.Violations, expected) {
+			var found []string
+			for _, name := range ViolationNames {
+				if summary.Violations[name] {
+					found = append(found, name)
+				}
+			}
+			t.Errorf("Corpus cert %d (%s): expected %v, got %v", i, cert.Subject,
+				corpusViolations[i], found)
+		}
+	}
+}
+
+func BenchmarkCalculateCertSummary(b *testing.B) {
+	pemBlock, _ := pem.Decode([]byte(pemCertificate))
+	cert, err := x509.ParseCertificate(pemBlock.Bytes)
+	if err != nil {
+		b.Fatal("could not parse cert", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CalculateCertSummary(cert, 0, 1402580730123, false, nil, nil, nil, nil)
+	}
+}
+
+func BenchmarkCalculateCertSummaryCorpus(b *testing.B) {
+	certs := readCorpus(b)
+	chain := certs[:1]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cert := certs[i%len(certs)]
+		CalculateCertSummary(cert, uint64(i), 1402580730123, false, nil, chain,
+			nil, nil)
+	}
+}
+
+func TestUnparseableKey(t *testing.T) {
+	ecdsaCert := testcerts.New(t, testcerts.Spec{})
+	rsaCert := testcerts.New(t, testcerts.Spec{KeyBits: 1024})
+	// Go doesn't know the key algorithm once id-ecPublicKey
+	// (1.2.840.10045.2.1) is changed to 1.2.840.10045.2.9, so it leaves the
+	// public key nil. The signature no longer verifies, but that doesn't
+	// matter for parsing.
+	ecPublicKey := []byte{0x06, 0x07, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x02, 0x01}
+	unknown := []byte{0x06, 0x07, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x02, 0x09}
+	if !bytes.Contains(ecdsaCert.Raw, ecPublicKey) {
+		t.Fatal("expected the ECDSA cert to have id-ecPublicKey")
+	}
+	unknownCert, err := x509.ParseCertificate(bytes.Replace(ecdsaCert.Raw,
+		ecPublicKey, unknown, 1))
+	if err != nil {
+		t.Fatal("could not parse cert with an unknown key algorithm", err)
+	}
+	if unknownCert.PublicKey != nil {
+		t.Fatalf("expected Go not to parse the key, got %T", unknownCert.PublicKey)
+	}
+	// Go won't create a cert with an X25519 key, so the ECDSA cert's key is
+	// swapped for one. The signature no longer verifies, but parsing doesn't
+	// check it.
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate X25519 key", err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(x25519Key.PublicKey())
+	if err != nil {
+		t.Fatal("could not marshal X25519 key", err)
+	}
+	var tbs tbsCertificate
+	if _, err := asn1.Unmarshal(ecdsaCert.RawTBSCertificate, &tbs); err != nil {
+		t.Fatal("could not unmarshal TBSCertificate", err)
+	}
+	tbs.Raw = nil
+	tbs.PublicKey = asn1.RawValue{FullBytes: spki}
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		t.Fatal("could not marshal TBSCertificate", err)
+	}
+	var outer certificate
+	if _, err := asn1.Unmarshal(ecdsaCert.Raw, &outer); err != nil {
+		t.Fatal("could not unmarshal cert", err)
+	}
+	outer.TBSCertificate = asn1.RawValue{FullBytes: tbsDER}
+	x25519DER, err := asn1.Marshal(outer)
+	if err != nil {
+		t.Fatal("could not marshal cert", err)
+	}
+	x25519Cert, err := x509.ParseCertificate(x25519DER)
+	if err != nil {
+		t.Fatal("could not parse X25519 cert", err)
+	}
+	if x25519Cert.PublicKey != nil {
+		t.Fatalf("expected Go not to parse the X25519 key, got %T", x25519Cert.PublicKey)
+	}
+	for _, test := range []struct {
+		description string
+		cert        *x509.Certificate
+		unparseable bool
+	}{
+		{"RSA key", rsaCert, false},
+		{"ECDSA key", ecdsaCert, false},
+		{"X25519 key", x25519Cert, false},
+		{"unknown key algorithm", unknownCert, true},
+	} {
+		summary, _ := CalculateCertSummary(test.cert, 0, 0, false, nil, nil, nil,
+			nil)
+		if summary.Violations[UNPARSEABLE_KEY] != test.unparseable {
+			t.Errorf("%s: expected UnparseableKey %t", test.description,
+				test.unparseable)
+		}
+	}
+}
+
+func TestBrokenSignatureAlgorithm(t *testing.T) {
+	sha256Cert := testcerts.New(t, testcerts.Spec{
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	})
+	// Go won't sign with MD5, so sha256WithRSAEncryption
+	// (1.2.840.113549.1.1.11) is changed to md5WithRSAEncryption
+	// (1.2.840.113549.1.1.4) in both the TBS cert and the outer signature
+	// algorithm. The signature no longer verifies, but that doesn't matter for
+	// parsing.
+	sha256WithRSA := []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x0b}
+	md5WithRSA := []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x04}
+	if bytes.Count(sha256Cert.Raw, sha256WithRSA) != 2 {
+		t.Fatal("expected the cert to have sha256WithRSAEncryption twice")
+	}
+	md5Cert, err := x509.ParseCertificate(bytes.Replace(sha256Cert.Raw,
+		sha256WithRSA, md5WithRSA, -1))
+	if err != nil {
+		t.Fatal("could not parse MD5-signed cert", err)
+	}
+	if md5Cert.SignatureAlgorithm != x509.MD5WithRSA {
+		t.Fatalf("expected an MD5-signed cert, got %s", md5Cert.SignatureAlgorithm)
+	}
+	for _, test := range []struct {
+		description string
+		cert        *x509.Certificate
+		broken      bool
+	}{
+		{"SHA-256", sha256Cert, false},
+		{"MD5", md5Cert, true},
+	} {
+		summary, _ := CalculateCertSummary(test.cert, 0, 0, false, nil, nil, nil,
+			nil)
+		if summary.Violations[BROKEN_SIGNATURE_ALGORITHM] != test.broken {
+			t.Errorf("%s: expected BrokenSignatureAlgorithm %t", test.description,
+				test.broken)
+		}
+		if summary.Violations[DEPRECATED_SIGNATURE_ALGORITHM] {
+			t.Errorf("%s: expected no DeprecatedSignatureAlgorithm",
+				test.description)
+		}
+	}
+}
+
+func TestAlgorithmMismatch(t *testing.T) {
+	matching := testcerts.New(t, testcerts.Spec{
+		KeyBits:            2048,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	})
+	// Change only the outer signature algorithm, which comes after the
+	// TBSCertificate, from sha256WithRSAEncryption (1.2.840.113549.1.1.11) to
+	// sha384WithRSAEncryption (1.2.840.113549.1.1.12).
+	sha256WithRSA := []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x0b}
+	sha384WithRSA := []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x0c}
+	outer := bytes.LastIndex(matching.Raw, sha256WithRSA)
+	if outer < len(matching.RawTBSCertificate) {
+		t.Fatal("expected the outer signature algorithm to be sha256WithRSAEncryption")
+	}
+	der := append([]byte{}, matching.Raw...)
+	copy(der[outer:], sha384WithRSA)
+
+	if _, err := x509.ParseCertificate(der); err == nil {
+		t.Fatal("expected Go to refuse to parse the mismatched cert")
+	}
+	mismatched, err := ParseCertificate(der)
+	if err != nil {
+		t.Fatal("could not parse the mismatched cert", err)
+	}
+	if !bytes.Equal(mismatched.Raw, der) ||
+		!bytes.Equal(mismatched.RawTBSCertificate, matching.RawTBSCertificate) {
+		t.Error("expected the mismatched cert to keep its encoding")
+	}
+	if _, err := ParseCertificate(der[:len(der)-1]); err == nil {
+		t.Error("expected a truncated cert not to parse")
+	}
+	for _, test := range []struct {
+		description string
+		cert        *x509.Certificate
+		mismatch    bool
+	}{
+		{"matching algorithms", matching, false},
+		{"mismatched algorithms", mismatched, true},
+	} {
+		summary, _ := CalculateCertSummary(test.cert, 0, 0, false, nil, nil, nil,
+			nil)
+		if summary.Violations[ALGORITHM_MISMATCH] != test.mismatch {
+			t.Errorf("%s: expected AlgorithmMismatch %t", test.description,
+				test.mismatch)
+		}
+	}
+}
+
+func TestSHA1AfterSunset(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		notBefore   time.Time
+		algorithm   x509.SignatureAlgorithm
+		cutoff      time.Time
+		sunset      bool
+	}{
+		{"SHA-1 before the cutoff", time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC),
+			x509.SHA1WithRSA, time.Time{}, false},
+		{"SHA-1 after the cutoff", time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC),
+			x509.SHA1WithRSA, time.Time{}, true},
+		{"SHA-256 after the cutoff", time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC),
+			x509.SHA256WithRSA, time.Time{}, false},
+		{"SHA-1 after a configured cutoff", time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC),
+			x509.SHA1WithRSA, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), true},
+	} {
+		cert := testcerts.New(t, testcerts.Spec{
+			Subject:            pkix.Name{CommonName: "www.example.com"},
+			KeyBits:            2048,
+			NotBefore:          test.notBefore,
+			SignatureAlgorithm: test.algorithm,
+			DNSNames:           []string{"www.example.com"},
+		})
+		config := &RuleConfig{SHA1Cutoff: test.cutoff}
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, config)
+		if summary.Violations[SHA1_AFTER_SUNSET] != test.sunset {
+			t.Errorf("%s: expected SHA1AfterSunset %t", test.description,
+				test.sunset)
+		}
+		if summary.Violations[DEPRECATED_SIGNATURE_ALGORITHM] !=
+			(test.algorithm == x509.SHA1WithRSA) {
+			t.Errorf("%s: expected DeprecatedSignatureAlgorithm only for SHA-1",
+				test.description)
+		}
+	}
+}
+
+func TestDeterministicJSON(t *testing.T) {
+	cert := testcerts.New(t, testcerts.Spec{
+		Subject:        pkix.Name{CommonName: "www.example.com"},
+		NotAfter:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:       []string{"*.example.com", "www.example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("192.0.2.1")},
+		EmailAddresses: []string{"admin@example.com"},
+	})
+	summary, err := CalculateCertSummary(cert, 7, 0, false, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal("could not summarize cert", err)
+	}
+	var outputs [2]bytes.Buffer
+	for i := range outputs {
+		sink := NewJSONSink(&outputs[i])
+		if err := sink.Write(summary, cert); err != nil {
+			t.Fatal("could not write summary", err)
+		}
+		sink.Close()
+	}
+	if !bytes.Equal(outputs[0].Bytes(), outputs[1].Bytes()) {
+		t.Errorf("Expected the same summary to marshal the same way, got\n%s\nand\n%s",
+			outputs[0].Bytes(), outputs[1].Bytes())
+	}
+
+	// An issuer's overall scores are sums over its map of scores, which has
+	// to be iterated in the same order each time for the sums to match.
+	issuer := NewIssuerReputation(pkix.Name{CommonName: "Test CA"}, 0)
+	for i := 0; i < 10; i++ {
+		violations := make(map[string]bool)
+		for j, name := range ViolationNames {
+			violations[name] = (i+j)%3 == 0
+		}
+		issuer.Update(&CertSummary{Violations: violations, MaxReputation: 0.1 * float32(i)})
+	}
+	config := &RuleConfig{Weights: DefaultViolationWeights}
+	var expected []byte
+	for i := 0; i < 20; i++ {
+		finished := issuer.clone()
+		finished.FinishWeighted(config)
+		marshalled, err := json.Marshal(finished)
+		if err != nil {
+			t.Fatal("could not marshal issuer reputation", err)
+		}
+		if expected == nil {
+			expected = marshalled
+		} else if !bytes.Equal(marshalled, expected) {
+			t.Fatalf("Expected the same issuer reputation to marshal the same way, got\n%s\nand\n%s",
+				expected, marshalled)
+		}
+	}
+}
+
+func TestMalformedValidityDates(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		notBefore   time.Time
+		notAfter    time.Time
+		malformed   bool
+	}{
+		{"plausible dates", time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		// As if 2050 had been encoded as the UTCTime 50, which is 1950.
+		{"notAfter a century early", time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"notBefore before 1970", time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), true},
+	} {
+		cert := testcerts.New(t, testcerts.Spec{
+			Subject:   pkix.Name{CommonName: "www.example.com"},
+			NotBefore: test.notBefore,
+			NotAfter:  test.notAfter,
+			DNSNames:  []string{"www.example.com"},
+		})
+		if cert.NotAfter.Year() != test.notAfter.Year() {
+			t.Fatalf("%s: expected notAfter in %d, got %s", test.description,
+				test.notAfter.Year(), cert.NotAfter)
+		}
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[MALFORMED_VALIDITY_DATES] != test.malformed {
+			t.Errorf("%s: expected MalformedValidityDates %t", test.description,
+				test.malformed)
+		}
+	}
+}
+
+func TestSANReputations(t *testing.T) {
+	ranker, err := ReadRankList(strings.NewReader(trancoSample), RANK_FORMAT_TRANCO)
+	if err != nil {
+		t.Fatal("could not read rank list", err)
+	}
+	cert := testcerts.New(t, testcerts.Spec{
+		Subject:  pkix.Name{CommonName: "unranked.example"},
+		DNSNames: []string{"google.com", "unranked.example", "example.com"},
+	})
+
+	summary, _ := CalculateCertSummary(cert, 0, 0, false, ranker, nil, nil, nil)
+	if summary.MaxReputation != 1 || summary.SANReputations != nil {
+		t.Errorf("Expected only the max reputation by default, got %f and %v",
+			summary.MaxReputation, summary.SANReputations)
+	}
+
+	config := &RuleConfig{RecordSANReputations: true}
+	summary, _ = CalculateCertSummary(cert, 0, 0, false, ranker, nil, nil, config)
+	expected := map[string]float32{"google.com": 1, "example.com": 0.25}
+	if summary.MaxReputation != 1 ||
+		!reflect.DeepEqual(summary.SANReputations, expected) {
+		t.Errorf("Expected the reputations of the ranked names %v, got %v",
+			expected, summary.SANReputations)
+	}
+}
+
+func TestKeyUsageMismatch(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		keyBits     int
+		keyUsage    x509.KeyUsage
+		mismatch    bool
+	}{
+		{"RSA key for signing and key encipherment", 2048,
+			x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, false},
+		{"RSA key for key agreement", 2048, x509.KeyUsageKeyAgreement, true},
+		{"ECDSA key for signing and key agreement", 0,
+			x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement, false},
+		{"ECDSA key for key encipherment", 0,
+			x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, true},
+		{"no key usage", 2048, 0, false},
+	} {
+		cert := testcerts.New(t, testcerts.Spec{
+			Subject:  pkix.Name{CommonName: "www.example.com"},
+			KeyBits:  test.keyBits,
+			DNSNames: []string{"www.example.com"},
+			KeyUsage: test.keyUsage,
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[KEY_USAGE_MISMATCH] != test.mismatch {
+			t.Errorf("%s: expected KeyUsageMismatch %t", test.description,
+				test.mismatch)
+		}
+	}
+}
+
+func TestEmptyOrganization(t *testing.T) {
+	for _, test := range []struct {
+		organization []string
+		empty        bool
+	}{
+		{nil, false},
+		{[]string{"Example Inc"}, false},
+		{[]string{"  "}, true},
+		{[]string{""}, true},
+	} {
+		cert := testcerts.New(t, testcerts.Spec{
+			Subject: pkix.Name{CommonName: "www.example.com",
+				Organization: test.organization},
+			DNSNames: []string{"www.example.com"},
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[EMPTY_ORGANIZATION] != test.empty {
+			t.Errorf("%q: expected EmptyOrganization %t", test.organization,
+				test.empty)
+		}
+	}
+}
+
+func TestIPAsDNSName(t *testing.T) {
+	for _, test := range []struct {
+		dnsNames []string
+		ipAsDNS  bool
+	}{
+		{[]string{"www.example.com"}, false},
+		{[]string{"www.example.com", "192.0.2.1"}, true},
+		{[]string{"2001:db8::1"}, true},
+		// A name that only looks like part of an address is fine.
+		{[]string{"192.0.2.example.com"}, false},
+	} {
+		cert := testcerts.New(t, testcerts.Spec{
+			Subject:  pkix.Name{CommonName: test.dnsNames[0]},
+			DNSNames: test.dnsNames,
+		})
+		summary, _ := CalculateCertSummary(cert, 0, 0, false, nil, nil, nil, nil)
+		if summary.Violations[IP_AS_DNS_NAME] != test.ipAsDNS {
+			t.Errorf("%v: expected IPAsDNSName %t", test.dnsNames, test.ipAsDNS)
+		}
+	}
+}
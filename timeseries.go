@@ -0,0 +1,57 @@
+package sunlight
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// An issuer's normalized score for one month, as a point in a time series.
+type IssuerMonthScore struct {
+	Issuer                  string
+	IssuerSha256Fingerprint string
+	// The start of the month, in milliseconds since the epoch
+	Month           uint64
+	NormalizedScore float32
+	NormalizedCount uint64
+}
+
+func (point IssuerMonthScore) MarshalJSON() ([]byte, error) {
+	type plainPoint IssuerMonthScore
+	return json.Marshal(struct {
+		plainPoint
+		NormalizedScore *float32
+	}{plainPoint(point), finiteScore(point.NormalizedScore)})
+}
+
+// Returns a point for each of the given finished issuer reputations, which
+// are per month, ordered by issuer and then by month so that each issuer's
+// points form a series that can be plotted. Months with no certs for domains
+// in Alexa have a NaN NormalizedScore, marshalled as null.
+func IssuerTimeSeries(issuers []*IssuerReputation) []*IssuerMonthScore {
+	points := make([]*IssuerMonthScore, 0, len(issuers))
+	for _, issuer := range issuers {
+		points = append(points, &IssuerMonthScore{
+			Issuer:                  issuer.Issuer,
+			IssuerSha256Fingerprint: issuer.IssuerSha256Fingerprint,
+			Month:                   issuer.BeginTime,
+			NormalizedScore:         issuer.NormalizedScore,
+			NormalizedCount:         issuer.NormalizedCount,
+		})
+	}
+	SortTimeSeries(points)
+	return points
+}
+
+// Orders points by issuer and then by month.
+func SortTimeSeries(points []*IssuerMonthScore) {
+	sort.Slice(points, func(i, j int) bool {
+		a, b := points[i], points[j]
+		if a.Issuer != b.Issuer {
+			return a.Issuer < b.Issuer
+		}
+		if a.IssuerSha256Fingerprint != b.IssuerSha256Fingerprint {
+			return a.IssuerSha256Fingerprint < b.IssuerSha256Fingerprint
+		}
+		return a.Month < b.Month
+	})
+}
@@ -0,0 +1,55 @@
+package sunlight
+
+import (
+	"crypto/x509/pkix"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIssuerTimeSeries(t *testing.T) {
+	june := uint64(1402580730123)
+	july := june + 31*24*60*60*1000
+	// July's reputation comes first, and only has certs for domains that
+	// aren't in Alexa.
+	var issuers []*IssuerReputation
+	for _, month := range []uint64{july, june} {
+		issuer := NewIssuerReputation(pkix.Name{CommonName: "Example CA"}, month)
+		for i := 0; i < 4; i++ {
+			summary := &CertSummary{
+				MaxReputation: 0.5,
+				Violations: map[string]bool{
+					KEY_TOO_SHORT: i < 1,
+					EXP_TOO_SMALL: false,
+				},
+			}
+			if month == july {
+				summary.MaxReputation = -1
+			}
+			issuer.Update(summary)
+		}
+		issuer.Finish()
+		issuers = append(issuers, issuer)
+	}
+
+	points := IssuerTimeSeries(issuers)
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 points, got %d", len(points))
+	}
+	if points[0].Issuer != "CN=Example CA" || points[0].Month != TruncateMonth(june) ||
+		points[1].Month != TruncateMonth(july) {
+		t.Errorf("Points out of order: %+v, %+v", *points[0], *points[1])
+	}
+	// KEY_TOO_SHORT is 1 - 0.5 / 4 and EXP_TOO_SMALL is 1.
+	if points[0].NormalizedScore != 0.9375 || points[0].NormalizedCount != 4 {
+		t.Errorf("Unexpected June point %+v", *points[0])
+	}
+
+	marshalled, err := json.Marshal(points)
+	if err != nil {
+		t.Fatal("could not marshal time series", err)
+	}
+	if !strings.Contains(string(marshalled), `"NormalizedScore":null`) {
+		t.Errorf("Expected a null score for July, got %s", marshalled)
+	}
+}
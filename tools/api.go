@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	. "github.com/mozkeeler/sunlight"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Keeps the summaries of the most recent violating certs written to it.
+type recentViolations struct {
+	lock      sync.Mutex
+	summaries []*CertSummary
+	// Where the next summary goes once summaries is full.
+	next int
+	size int
+}
+
+func newRecentViolations(size int) *recentViolations {
+	return &recentViolations{size: size}
+}
+
+func (r *recentViolations) Write(summary *CertSummary, cert *x509.Certificate) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.summaries) < r.size {
+		r.summaries = append(r.summaries, summary)
+		return nil
+	}
+	if r.size > 0 {
+		r.summaries[r.next] = summary
+		r.next = (r.next + 1) % r.size
+	}
+	return nil
+}
+
+func (r *recentViolations) Close() error { return nil }
+
+// Returns the summaries kept, newest first.
+func (r *recentViolations) list() []*CertSummary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	list := make([]*CertSummary, 0, len(r.summaries))
+	for i := len(r.summaries) - 1; i >= 0; i-- {
+		list = append(list, r.summaries[(r.next+i)%len(r.summaries)])
+	}
+	return list
+}
+
+// A JSON HTTP API serving an Analyzer's issuer reputations and the most
+// recent violating certs, for dashboards to poll while a run goes on.
+type api struct {
+	analyzer *Analyzer
+	recent   *recentViolations
+	lock     sync.Mutex
+	// Once processing is done, the finished reputations, which are served
+	// instead of snapshots of the Analyzer's.
+	finished []*IssuerReputation
+}
+
+func newAPI(analyzer *Analyzer, recent *recentViolations) *api {
+	return &api{analyzer: analyzer, recent: recent}
+}
+
+// Serves issuers, which are finished and won't change, from now on.
+func (a *api) finish(issuers []*IssuerReputation) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.finished = issuers
+}
+
+func (a *api) issuers() []*IssuerReputation {
+	a.lock.Lock()
+	finished := a.finished
+	a.lock.Unlock()
+	if finished != nil {
+		return finished
+	}
+	return a.analyzer.IssuerSnapshot()
+}
+
+// Returns a handler serving every issuer reputation at /issuers, the
+// reputations (one per month) of the issuer with a given distinguished name
+// at /issuers/<issuer>, and the summaries of the most recent violating certs,
+// newest first, at /violations/recent.
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/issuers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, a.issuers())
+	})
+	mux.HandleFunc("/issuers/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/issuers/")
+		matching := make([]*IssuerReputation, 0)
+		for _, issuer := range a.issuers() {
+			if issuer.Issuer == name {
+				matching = append(matching, issuer)
+			}
+		}
+		if len(matching) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSONResponse(w, matching)
+	})
+	mux.HandleFunc("/violations/recent", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, a.recent.list())
+	})
+	return mux
+}
+
+func writeJSONResponse(w http.ResponseWriter, v interface{}) {
+	marshalled, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(marshalled)
+}
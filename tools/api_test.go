@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"github.com/monicachew/certificatetransparency"
+	. "github.com/mozkeeler/sunlight"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Gets path from server and decodes the JSON response into v, returning the
+// status code.
+func getJSON(t *testing.T, server *httptest.Server, path string,
+	v interface{}) int {
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("could not get %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("invalid JSON from %s: %s", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAPI(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	now := time.Now()
+	recent := newRecentViolations(2)
+	analyzer := NewAnalyzer(nil, nil, nil, recent)
+	// Self-signed, so the first two certs have one issuer and the third
+	// another. All are valid for too long.
+	for i, name := range []string{"a.example.com", "a.example.com", "b.example.com"} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.AddDate(6, 0, 0),
+			DNSNames:     []string{name},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template,
+			&key.PublicKey, key)
+		if err != nil {
+			t.Fatal("could not create cert", err)
+		}
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(now.Unix()) * 1000,
+				X509Cert:  der,
+			},
+		}, nil)
+	}
+	served := newAPI(analyzer, recent)
+	server := httptest.NewServer(served.handler())
+	defer server.Close()
+
+	var issuers []IssuerReputation
+	if getJSON(t, server, "/issuers", &issuers) != http.StatusOK ||
+		len(issuers) != 2 || issuers[0].Issuer != "CN=a.example.com" ||
+		issuers[0].RawCount != 2 {
+		t.Errorf("Unexpected issuers %+v", issuers)
+	}
+
+	issuers = nil
+	status := getJSON(t, server, "/issuers/"+url.PathEscape("CN=b.example.com"),
+		&issuers)
+	if status != http.StatusOK || len(issuers) != 1 || issuers[0].RawCount != 1 {
+		t.Errorf("Unexpected reputations of CN=b.example.com (%d): %+v", status,
+			issuers)
+	}
+	if status := getJSON(t, server, "/issuers/CN=c.example.com", nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown issuer, got %d", status)
+	}
+
+	var summaries []CertSummary
+	if getJSON(t, server, "/violations/recent", &summaries) != http.StatusOK ||
+		len(summaries) != 2 || summaries[0].LogIndex != 2 ||
+		summaries[1].LogIndex != 1 {
+		t.Errorf("Expected the last two violating certs, newest first, got %+v",
+			summaries)
+	}
+
+	// Once processing is done, the finished reputations are served.
+	served.finish([]*IssuerReputation{{Issuer: "CN=Finished CA"}})
+	issuers = nil
+	getJSON(t, server, "/issuers", &issuers)
+	if len(issuers) != 1 || issuers[0].Issuer != "CN=Finished CA" {
+		t.Errorf("Expected the finished reputations, got %+v", issuers)
+	}
+}
+
+// Requests made while a run finishes are served either snapshots or the
+// finished reputations, and neither are finished twice.
+func TestAPIServesIssuersWhileFinishing(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	now := time.Now()
+	analyzer := NewAnalyzer(nil, nil, nil, nil)
+	for i := 0; i < 4; i++ {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: "finishing.example.com"},
+			NotBefore:    now.Add(-time.Hour),
+			// Half of the certs are valid for too long.
+			NotAfter: now.AddDate(1+5*(i%2), 0, 0),
+			DNSNames: []string{"finishing.example.com"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template,
+			&key.PublicKey, key)
+		if err != nil {
+			t.Fatal("could not create cert", err)
+		}
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(now.Unix()) * 1000,
+				X509Cert:  der,
+			},
+		}, nil)
+	}
+	expected := analyzer.IssuerSnapshot()
+	if len(expected) != 1 {
+		t.Fatalf("Expected one issuer, got %d", len(expected))
+	}
+	served := newAPI(analyzer, newRecentViolations(1))
+	server := httptest.NewServer(served.handler())
+	defer server.Close()
+
+	scores := make(chan float32, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := http.Get(server.URL + "/issuers")
+				if err != nil {
+					continue
+				}
+				var issuers []IssuerReputation
+				if json.NewDecoder(resp.Body).Decode(&issuers) == nil &&
+					len(issuers) == 1 {
+					scores <- issuers[0].RawScore
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	finished := finishIssuers(analyzer, served)
+	wg.Wait()
+	close(scores)
+
+	if len(finished) != 1 || finished[0].RawScore != expected[0].RawScore {
+		t.Errorf("Expected a raw score of %f, got %+v", expected[0].RawScore,
+			finished)
+	}
+	for score := range scores {
+		if score != expected[0].RawScore {
+			t.Errorf("Expected every response to have a raw score of %f, got %f",
+				expected[0].RawScore, score)
+		}
+	}
+	var issuers []IssuerReputation
+	getJSON(t, server, "/issuers", &issuers)
+	if len(issuers) != 1 || issuers[0].RawScore != expected[0].RawScore {
+		t.Errorf("Expected the finished reputation, got %+v", issuers)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	. "github.com/mozkeeler/sunlight"
+	"os"
+)
+
+// A subcommand of the tool, run with the args after its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// The subcommands. analyze is the first, and is run if no subcommand is
+// named, as it was before there were subcommands.
+var commands = []*command{
+	{"analyze", "Check the certs in CT logs and record the results (the default)",
+		runAnalyze},
+	{"report", "Write reports from the issuer reputations saved by analyze",
+		runReport},
+	{"reanalyze", "Re-check the certs already in a DB", runReanalyze},
+	{"validate", "Check that JSON output files parse", runValidate},
+	{"compare", "Report how issuer reputations changed between two runs",
+		runCompare},
+}
+
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-10s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(out, "\nRun %s <command> -h for a command's flags. "+
+			"The flags of analyze are:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// Returns the subcommand named by the first of args and the rest of them, or
+// analyze and all of args if none is named.
+func findCommand(args []string) (*command, []string) {
+	if len(args) > 0 {
+		for _, c := range commands {
+			if c.name == args[0] {
+				return c, args[1:]
+			}
+		}
+	}
+	return commands[0], args
+}
+
+// The flags of the reanalyze subcommand.
+type reanalyzeOptions struct {
+	dbFile        string
+	checkList     string
+	weightList    string
+	shortKeyBits  int
+	strictCNInSAN bool
+	sha1Cutoff    string
+	ctLogKeysFile string
+	logLevelName  string
+	quiet         bool
+}
+
+func parseReanalyzeArgs(args []string) (*reanalyzeOptions, error) {
+	o := &reanalyzeOptions{}
+	fs := flag.NewFlagSet("reanalyze", flag.ContinueOnError)
+	fs.StringVar(&o.dbFile, "db_file", "BRs.db", "sqlite DB written by analyze")
+	fs.StringVar(&o.checkList, "checks", "",
+		"Comma-separated violations to check for (empty means all)")
+	fs.StringVar(&o.weightList, "weights", "",
+		"Violation weights, as for analyze")
+	fs.IntVar(&o.shortKeyBits, "short_key_bits", DEFAULT_SHORT_KEY_BITS,
+		"RSA keys with at most this many bits are too short")
+	fs.BoolVar(&o.strictCNInSAN, "strict_cn_in_san", false,
+		"Don't count a CN covered only by a wildcard SAN as being in the SAN")
+	fs.StringVar(&o.sha1Cutoff, "sha1_cutoff",
+		DefaultSHA1Cutoff.Format("2006-01-02"),
+		"SHA-1 certs issued after this date (YYYY-MM-DD) are SHA1AfterSunset")
+	fs.StringVar(&o.ctLogKeysFile, "ct_log_keys", "",
+		"PEM file of CT log public keys or JSON log list, as for analyze")
+	fs.StringVar(&o.logLevelName, "log_level", "info",
+		"Least severe messages to log: debug, info, warn or error")
+	fs.BoolVar(&o.quiet, "quiet", false, "Only log errors, whatever log_level is")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	return o, nil
+}
+
+func runReanalyze(args []string) int {
+	o, err := parseReanalyzeArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logger, err := newLogger(os.Stderr, o.logLevelName, o.quiet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log_level: %s\n", err)
+		return 1
+	}
+	config, err := newRuleConfig(o.checkList, o.weightList, o.shortKeyBits,
+		o.strictCNInSAN, o.sha1Cutoff, o.ctLogKeysFile)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return 1
+	}
+	return reanalyzeFile(logger, o.dbFile, config)
+}
+
+// Re-checks the certs in the DB in dbFile with config, returning the status
+// to exit with.
+func reanalyzeFile(logger *Logger, dbFile string, config *RuleConfig) int {
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		logger.Errorf("Failed to open %s: %s", dbFile, err)
+		return 1
+	}
+	defer db.Close()
+	updated, err := reanalyze(db, config)
+	if err != nil {
+		logger.Errorf("Failed to re-analyze %s: %s", dbFile, err)
+		return 1
+	}
+	logger.Infof("Re-analyzed %d certs in %s", updated, dbFile)
+	return 0
+}
+
+// The flags of the report subcommand.
+type reportOptions struct {
+	issuersFile        string
+	weightList         string
+	issuerJSONFile     string
+	timeSeriesFile     string
+	offendersFile      string
+	offendersChecks    map[string]bool
+	offendersThreshold float64
+}
+
+func parseReportArgs(args []string) (*reportOptions, error) {
+	o := &reportOptions{}
+	var offendersChecks string
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.StringVar(&o.issuersFile, "issuers_file", "",
+		"Issuer reputations saved by analyze -issuers_file (required)")
+	fs.StringVar(&o.weightList, "weights", "",
+		"Violation weights, as for analyze")
+	fs.StringVar(&o.issuerJSONFile, "issuer_json_file", "",
+		"If set, JSON output of the finished issuer reputations (- for stdout)")
+	fs.StringVar(&o.timeSeriesFile, "time_series_file", "",
+		"If set, JSON output of each issuer's normalized score by month (- for stdout)")
+	fs.StringVar(&o.offendersFile, "offenders_file", "",
+		"If set, JSON report of issuers over offenders_threshold (- for stdout)")
+	fs.StringVar(&offendersChecks, "offenders_checks", "",
+		"Comma-separated violations to report offenders for (empty means all)")
+	fs.Float64Var(&o.offendersThreshold, "offenders_threshold", 0.05,
+		"Fraction of an issuer's certs that may have a violation, in [0, 1]")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	if o.issuersFile == "" {
+		return nil, fmt.Errorf("issuers_file is required")
+	}
+	if o.offendersThreshold < 0 || o.offendersThreshold > 1 {
+		return nil, fmt.Errorf("offenders_threshold must be in [0, 1]")
+	}
+	if offendersChecks != "" {
+		var err error
+		o.offendersChecks, err = ParseChecks(offendersChecks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offenders_checks: %s", err)
+		}
+	}
+	return o, nil
+}
+
+// Writes the reports analyze can, for the issuer reputations it saved, without
+// going through the CT logs again.
+func runReport(args []string) int {
+	o, err := parseReportArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logger := NewLogger(os.Stderr, LOG_INFO)
+	config, err := newRuleConfig("", o.weightList, DEFAULT_SHORT_KEY_BITS,
+		false, "", "")
+	if err != nil {
+		logger.Errorf("%s", err)
+		return 1
+	}
+	if _, err := os.Stat(o.issuersFile); err != nil {
+		logger.Errorf("Failed to open issuers_file: %s", err)
+		return 1
+	}
+	analyzer := NewAnalyzer(nil, nil, config, nil)
+	if err := loadIssuers(analyzer, o.issuersFile); err != nil {
+		logger.Errorf("Failed to load issuer reputations from %s: %s",
+			o.issuersFile, err)
+		return 1
+	}
+	issuers := make([]*IssuerReputation, 0, len(analyzer.Issuers))
+	for _, issuer := range analyzer.Issuers {
+		issuer.FinishWeighted(config)
+		issuers = append(issuers, issuer)
+	}
+
+	status := 0
+	if o.issuerJSONFile != "" {
+		if err := writeIssuerJSON(o.issuerJSONFile, issuers); err != nil {
+			logger.Errorf("Failed to write issuer reputations to %s: %s",
+				o.issuerJSONFile, err)
+			status = 1
+		}
+	}
+	if o.timeSeriesFile != "" {
+		points := IssuerTimeSeries(issuers)
+		SortTimeSeries(points)
+		if err := writeJSONFile(o.timeSeriesFile, points); err != nil {
+			logger.Errorf("Failed to write time series to %s: %s",
+				o.timeSeriesFile, err)
+			status = 1
+		}
+	}
+	if o.offendersFile != "" {
+		offenders := FindOffenders(issuers, o.offendersChecks,
+			o.offendersThreshold)
+		if err := writeJSONFile(o.offendersFile, offenders); err != nil {
+			logger.Errorf("Failed to write offenders to %s: %s",
+				o.offendersFile, err)
+			status = 1
+		}
+	}
+	logger.Infof("Reported on %d issuer reputations from %s", len(issuers),
+		o.issuersFile)
+	return status
+}
+
+// Returns the JSON output files the validate subcommand is to check.
+func parseValidateArgs(args []string) ([]string, error) {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate <json_file>...\n", os.Args[0])
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 {
+		return nil, fmt.Errorf("no JSON output files given")
+	}
+	return fs.Args(), nil
+}
+
+func runValidate(args []string) int {
+	files, err := parseValidateArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, name := range files {
+		count, err := validateJSONOutput(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s is invalid: %s\n", name, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: %d certs\n", name, count)
+	}
+	return status
+}
+
+// The flags and arguments of the compare subcommand.
+type compareOptions struct {
+	beforeFile string
+	afterFile  string
+	threshold  float64
+	outputFile string
+}
+
+func parseCompareArgs(args []string) (*compareOptions, error) {
+	o := &compareOptions{}
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s compare [flags] <before> <after>\n\n"+
+			"before and after are issuer reputations written by analyze, either "+
+			"with -issuer_json_file or to a DB (ending in .db). The flags are:\n",
+			os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Float64Var(&o.threshold, "threshold", 0.05,
+		"Report issuers whose normalized score changed by more than this")
+	fs.StringVar(&o.outputFile, "output_file", "-",
+		"JSON report of the changes (- for stdout)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 2 {
+		return nil, fmt.Errorf("expected two issuer reputation files, got %d",
+			fs.NArg())
+	}
+	if o.threshold < 0 || o.threshold > 1 {
+		return nil, fmt.Errorf("threshold must be in [0, 1]")
+	}
+	o.beforeFile, o.afterFile = fs.Arg(0), fs.Arg(1)
+	return o, nil
+}
+
+// Reports the issuers whose normalized scores changed between two runs, and
+// those that appeared or disappeared, for monitoring issuers over time.
+func runCompare(args []string) int {
+	o, err := parseCompareArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logger := NewLogger(os.Stderr, LOG_INFO)
+	before, err := readIssuerReputations(o.beforeFile)
+	if err != nil {
+		logger.Errorf("Failed to read issuer reputations from %s: %s",
+			o.beforeFile, err)
+		return 1
+	}
+	after, err := readIssuerReputations(o.afterFile)
+	if err != nil {
+		logger.Errorf("Failed to read issuer reputations from %s: %s",
+			o.afterFile, err)
+		return 1
+	}
+	comparison := CompareIssuers(before, after, float32(o.threshold))
+	if err := writeJSONFile(o.outputFile, comparison); err != nil {
+		logger.Errorf("Failed to write comparison to %s: %s", o.outputFile, err)
+		return 1
+	}
+	logger.Infof("%d issuers changed, %d appeared and %d disappeared",
+		len(comparison.Changed), len(comparison.Appeared),
+		len(comparison.Disappeared))
+	return 0
+}
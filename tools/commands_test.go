@@ -0,0 +1,111 @@
+package main
+
+import (
+	. "github.com/mozkeeler/sunlight"
+	"reflect"
+	"testing"
+)
+
+func TestFindCommand(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		name     string
+		expected []string
+	}{
+		{nil, "analyze", nil},
+		{[]string{"-json_file", "out.json"}, "analyze", []string{"-json_file", "out.json"}},
+		{[]string{"analyze", "-quiet"}, "analyze", []string{"-quiet"}},
+		{[]string{"report", "-issuers_file", "i.json"}, "report",
+			[]string{"-issuers_file", "i.json"}},
+		{[]string{"reanalyze"}, "reanalyze", []string{}},
+		{[]string{"validate", "certs.json"}, "validate", []string{"certs.json"}},
+		{[]string{"compare", "a.json", "b.json"}, "compare",
+			[]string{"a.json", "b.json"}},
+	} {
+		c, args := findCommand(test.args)
+		if c.name != test.name || !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%q: expected %s %q, got %s %q", test.args, test.name,
+				test.expected, c.name, args)
+		}
+	}
+}
+
+func TestParseAnalyzeArgs(t *testing.T) {
+	defer func(saved string) { jsonFile = saved }(jsonFile)
+	if err := parseAnalyzeArgs([]string{"-json_file", "out.json"}); err != nil {
+		t.Fatal("could not parse analyze args", err)
+	}
+	if jsonFile != "out.json" {
+		t.Errorf("Expected json_file out.json, got %s", jsonFile)
+	}
+	if err := parseAnalyzeArgs([]string{"stray"}); err == nil {
+		t.Error("Expected an error for a stray argument")
+	}
+}
+
+func TestParseReanalyzeArgs(t *testing.T) {
+	o, err := parseReanalyzeArgs([]string{"-db_file", "old.db",
+		"-checks", KEY_TOO_SHORT, "-quiet"})
+	if err != nil {
+		t.Fatal("could not parse reanalyze args", err)
+	}
+	if o.dbFile != "old.db" || o.checkList != KEY_TOO_SHORT || !o.quiet ||
+		o.shortKeyBits != DEFAULT_SHORT_KEY_BITS || o.sha1Cutoff != "2016-01-01" {
+		t.Errorf("Unexpected reanalyze options %+v", o)
+	}
+	if _, err := parseReanalyzeArgs([]string{"old.db"}); err == nil {
+		t.Error("Expected an error for a stray argument")
+	}
+}
+
+func TestParseReportArgs(t *testing.T) {
+	o, err := parseReportArgs([]string{"-issuers_file", "issuers.json",
+		"-offenders_file", "-", "-offenders_checks", KEY_TOO_SHORT})
+	if err != nil {
+		t.Fatal("could not parse report args", err)
+	}
+	if o.issuersFile != "issuers.json" || o.offendersFile != "-" ||
+		!reflect.DeepEqual(o.offendersChecks, map[string]bool{KEY_TOO_SHORT: true}) ||
+		o.offendersThreshold != 0.05 {
+		t.Errorf("Unexpected report options %+v", o)
+	}
+	for _, args := range [][]string{
+		{},
+		{"-issuers_file", "issuers.json", "-offenders_threshold", "2"},
+		{"-issuers_file", "issuers.json", "-offenders_checks", "NoSuchCheck"},
+	} {
+		if _, err := parseReportArgs(args); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseValidateArgs(t *testing.T) {
+	files, err := parseValidateArgs([]string{"a.json", "b.json"})
+	if err != nil || !reflect.DeepEqual(files, []string{"a.json", "b.json"}) {
+		t.Errorf("Expected [a.json b.json], got %q (%v)", files, err)
+	}
+	if _, err := parseValidateArgs(nil); err == nil {
+		t.Error("Expected an error without any files")
+	}
+}
+
+func TestParseCompareArgs(t *testing.T) {
+	o, err := parseCompareArgs([]string{"-threshold", "0.1", "a.json", "b.db"})
+	if err != nil {
+		t.Fatal("could not parse compare args", err)
+	}
+	if o.beforeFile != "a.json" || o.afterFile != "b.db" || o.threshold != 0.1 ||
+		o.outputFile != "-" {
+		t.Errorf("Unexpected compare options %+v", o)
+	}
+	for _, args := range [][]string{
+		{"a.json"},
+		{"a.json", "b.json", "c.json"},
+		{"-threshold", "2", "a.json", "b.json"},
+	} {
+		if _, err := parseCompareArgs(args); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	. "github.com/mozkeeler/sunlight"
+	"math"
+	"os"
+	"strings"
+)
+
+// Reads finished issuer reputations from the file name, which is either the
+// JSON written by analyze -issuer_json_file or, if it ends in .db, a DB
+// written by analyze. Only what CompareIssuers needs is read from a DB.
+func readIssuerReputations(name string) ([]*IssuerReputation, error) {
+	if strings.HasSuffix(name, ".db") {
+		return readIssuerReputationsDB(name)
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var issuers []*IssuerReputation
+	if err := json.NewDecoder(file).Decode(&issuers); err != nil {
+		return nil, fmt.Errorf("invalid issuer reputations: %s", err)
+	}
+	return issuers, nil
+}
+
+func readIssuerReputationsDB(name string) ([]*IssuerReputation, error) {
+	if _, err := os.Stat(name); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", name)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query(`select issuer, issuerSha256Fingerprint,
+		normalizedScore, normalizedCount, rawCount, beginTime
+		from issuerReputation`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	issuers := make([]*IssuerReputation, 0)
+	for rows.Next() {
+		issuer := &IssuerReputation{}
+		// NaN scores are stored as null.
+		var normalizedScore sql.NullFloat64
+		err := rows.Scan(&issuer.Issuer, &issuer.IssuerSha256Fingerprint,
+			&normalizedScore, &issuer.NormalizedCount, &issuer.RawCount,
+			&issuer.BeginTime)
+		if err != nil {
+			return nil, err
+		}
+		issuer.NormalizedScore = float32(math.NaN())
+		if normalizedScore.Valid {
+			issuer.NormalizedScore = float32(normalizedScore.Float64)
+		}
+		issuers = append(issuers, issuer)
+	}
+	return issuers, rows.Err()
+}
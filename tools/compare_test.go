@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"database/sql"
+	. "github.com/mozkeeler/sunlight"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadIssuerReputations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ranked := NewIssuerReputation(pkix.Name{CommonName: "Ranked CA"}, 0)
+	ranked.Update(&CertSummary{MaxReputation: 0.5,
+		Violations: map[string]bool{KEY_TOO_SHORT: true}})
+	ranked.Update(&CertSummary{MaxReputation: 0.5,
+		Violations: map[string]bool{KEY_TOO_SHORT: false}})
+	ranked.Finish()
+	// With no certs for domains in Alexa, its normalized score is NaN.
+	unranked := NewIssuerReputation(pkix.Name{CommonName: "Unranked CA"}, 0)
+	unranked.Update(&CertSummary{MaxReputation: -1,
+		Violations: map[string]bool{KEY_TOO_SHORT: true}})
+	unranked.Finish()
+	issuers := []*IssuerReputation{ranked, unranked}
+
+	jsonFile := filepath.Join(dir, "issuers.json")
+	if err := writeIssuerJSON(jsonFile, issuers); err != nil {
+		t.Fatal("could not write issuer JSON", err)
+	}
+	dbFile := filepath.Join(dir, "BRs.db")
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatal("could not open DB", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(createTables); err != nil {
+		t.Fatal("could not create tables", err)
+	}
+	statement, err := db.Prepare(insertIssuer)
+	if err != nil {
+		t.Fatal("could not prepare statement", err)
+	}
+	defer statement.Close()
+	for _, issuer := range issuers {
+		if err := insertIssuerReputation(statement, issuer); err != nil {
+			t.Fatal("could not insert issuer reputation", err)
+		}
+	}
+
+	for _, name := range []string{jsonFile, dbFile} {
+		read, err := readIssuerReputations(name)
+		if err != nil {
+			t.Fatalf("could not read %s: %s", name, err)
+		}
+		// Compared with the reputations they were written from, nothing
+		// has changed.
+		comparison := CompareIssuers(issuers, read, 0)
+		if len(read) != 2 || len(comparison.Changed) != 0 ||
+			len(comparison.Appeared) != 0 || len(comparison.Disappeared) != 0 {
+			t.Errorf("%s: expected the same reputations back, got %+v and %+v",
+				name, read, comparison)
+		}
+	}
+
+	if _, err := readIssuerReputations(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("Expected an error for a missing DB")
+	}
+}
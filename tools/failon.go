@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/x509"
+	. "github.com/mozkeeler/sunlight"
+	"sync/atomic"
+)
+
+// The exit status when -fail_on matched a violation. It's distinct from the
+// status of 1 for errors, so CI can tell misissuance from a failed run.
+const EXIT_VIOLATIONS_FOUND = 3
+
+// A Sink that counts the certs with any of a set of violations, so that a run
+// can fail when there are some.
+type violationGate struct {
+	// nil means any violation.
+	checks  map[string]bool
+	Matched uint64
+}
+
+// Parses the value of -fail_on: a comma-separated list of violation names, or
+// "all" for any violation that isn't informational. Only certs that violate
+// the BRs are written to sinks, so an informational violation only matches
+// alongside another one.
+func newViolationGate(list string) (*violationGate, error) {
+	if list == "all" {
+		return &violationGate{}, nil
+	}
+	checks, err := ParseChecks(list)
+	if err != nil {
+		return nil, err
+	}
+	return &violationGate{checks: checks}, nil
+}
+
+func (g *violationGate) Write(summary *CertSummary, cert *x509.Certificate) error {
+	for name, violated := range summary.Violations {
+		if violated && (g.checks == nil && !IsInformational(name) || g.checks[name]) {
+			atomic.AddUint64(&g.Matched, 1)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (g *violationGate) Close() error { return nil }
+
+// Returns the status to exit with: EXIT_VIOLATIONS_FOUND if any cert matched,
+// and otherwise 0. A nil gate never fails.
+func (g *violationGate) exitStatus() int {
+	if g != nil && atomic.LoadUint64(&g.Matched) > 0 {
+		return EXIT_VIOLATIONS_FOUND
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	. "github.com/mozkeeler/sunlight"
+	"testing"
+)
+
+func TestViolationGate(t *testing.T) {
+	longValidity := &CertSummary{Violations: map[string]bool{
+		VALID_PERIOD_TOO_LONG: true,
+		KEY_TOO_SHORT:         false,
+	}}
+	shortKey := &CertSummary{Violations: map[string]bool{KEY_TOO_SHORT: true}}
+
+	var disabled *violationGate
+	if status := disabled.exitStatus(); status != 0 {
+		t.Errorf("Expected status 0 without fail_on, got %d", status)
+	}
+
+	gate, err := newViolationGate("KeyTooShort")
+	if err != nil {
+		t.Fatal("could not parse fail_on", err)
+	}
+	gate.Write(longValidity, nil)
+	if status := gate.exitStatus(); status != 0 {
+		t.Errorf("Expected status 0 for violations not in fail_on, got %d", status)
+	}
+	gate.Write(shortKey, nil)
+	if status := gate.exitStatus(); status != EXIT_VIOLATIONS_FOUND {
+		t.Errorf("Expected status %d, got %d", EXIT_VIOLATIONS_FOUND, status)
+	}
+
+	all, err := newViolationGate("all")
+	if err != nil {
+		t.Fatal("could not parse fail_on", err)
+	}
+	all.Write(longValidity, nil)
+	if status := all.exitStatus(); status != EXIT_VIOLATIONS_FOUND {
+		t.Errorf("Expected status %d for any violation, got %d",
+			EXIT_VIOLATIONS_FOUND, status)
+	}
+
+	// Informational violations only match if they're asked for.
+	missingOCSP := &CertSummary{Violations: map[string]bool{MISSING_OCSP: true}}
+	informational, err := newViolationGate("all")
+	if err != nil {
+		t.Fatal("could not parse fail_on", err)
+	}
+	informational.Write(missingOCSP, nil)
+	if status := informational.exitStatus(); status != 0 {
+		t.Errorf("Expected status 0 for an informational violation, got %d", status)
+	}
+	named, err := newViolationGate("MissingOCSP")
+	if err != nil {
+		t.Fatal("could not parse fail_on", err)
+	}
+	named.Write(missingOCSP, nil)
+	if status := named.exitStatus(); status != EXIT_VIOLATIONS_FOUND {
+		t.Errorf("Expected status %d for a named informational violation, got %d",
+			EXIT_VIOLATIONS_FOUND, status)
+	}
+
+	if _, err := newViolationGate("KeyTooShort,NoSuchViolation"); err == nil {
+		t.Error("Expected an error for an unknown violation")
+	}
+}
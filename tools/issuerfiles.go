@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	. "github.com/mozkeeler/sunlight"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The longest an issuer file name gets before its hash is added.
+const maxIssuerFileNameLength = 100
+
+// Collects violating certs by issuer so that each issuer's results can be
+// written to a file of their own, for sharing with that CA.
+type issuerFiles struct {
+	lock  sync.Mutex
+	certs map[string][]*CertSummary
+}
+
+func newIssuerFiles() *issuerFiles {
+	return &issuerFiles{certs: make(map[string][]*CertSummary)}
+}
+
+// Records a violating cert. It's safe to call concurrently, and does nothing
+// if f is nil, so it can be called whether or not output_dir is set.
+func (f *issuerFiles) add(summary *CertSummary) {
+	if f == nil {
+		return
+	}
+	f.lock.Lock()
+	f.certs[summary.Issuer] = append(f.certs[summary.Issuer], summary)
+	f.lock.Unlock()
+}
+
+// What's written to each issuer's file.
+type issuerFile struct {
+	Issuer      string
+	Reputations []*IssuerReputation
+	Certs       []*CertSummary
+}
+
+// Returns the name of the file for issuer's results. Issuer names come from
+// certs, so anyone can choose them: everything but letters, digits, dots,
+// hyphens and underscores is replaced, and a hash of the name is added so that
+// issuers that look the same once sanitized don't share a file.
+func issuerFileName(issuer string) string {
+	sanitized := []byte(issuer)
+	for i, c := range sanitized {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			sanitized[i] = '_'
+		}
+	}
+	name := strings.TrimLeft(string(sanitized), ".")
+	if len(name) > maxIssuerFileNameLength {
+		name = name[:maxIssuerFileNameLength]
+	}
+	hash := sha256.Sum256([]byte(issuer))
+	return name + "-" + hex.EncodeToString(hash[:4]) + ".json"
+}
+
+// Writes a file to dir for each issuer with a reputation, holding its
+// reputations (one per month and issuing cert) and violating certs.
+func (f *issuerFiles) write(dir string, issuers []*IssuerReputation) error {
+	byIssuer := make(map[string][]*IssuerReputation)
+	for _, issuer := range issuers {
+		byIssuer[issuer.Issuer] = append(byIssuer[issuer.Issuer], issuer)
+	}
+	for name, reputations := range byIssuer {
+		sort.Slice(reputations, func(i, j int) bool {
+			if reputations[i].BeginTime != reputations[j].BeginTime {
+				return reputations[i].BeginTime < reputations[j].BeginTime
+			}
+			return reputations[i].IssuerSha256Fingerprint <
+				reputations[j].IssuerSha256Fingerprint
+		})
+		f.lock.Lock()
+		certs := f.certs[name]
+		f.lock.Unlock()
+		sort.Slice(certs, func(i, j int) bool {
+			return certs[i].LogIndex < certs[j].LogIndex
+		})
+		if certs == nil {
+			certs = []*CertSummary{}
+		}
+		out, err := os.Create(filepath.Join(dir, issuerFileName(name)))
+		if err != nil {
+			return err
+		}
+		err = json.NewEncoder(out).Encode(issuerFile{name, reputations, certs})
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/json"
+	. "github.com/mozkeeler/sunlight"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIssuerFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := uint64(1402580730123)
+	files := newIssuerFiles()
+	var issuers []*IssuerReputation
+	for _, name := range []string{"First CA", "../../Second CA"} {
+		issuer := NewIssuerReputation(pkix.Name{CommonName: name}, ts)
+		summary := &CertSummary{
+			Issuer:        issuer.Issuer,
+			MaxReputation: -1,
+			Violations:    map[string]bool{KEY_TOO_SHORT: true},
+		}
+		issuer.Update(summary)
+		issuer.Finish()
+		issuers = append(issuers, issuer)
+		files.add(summary)
+	}
+	if err := files.write(dir, issuers); err != nil {
+		t.Fatal("could not write issuer files", err)
+	}
+
+	written, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(written) != 2 {
+		t.Fatalf("Expected 2 issuer files, got %v", written)
+	}
+	for _, issuer := range issuers {
+		name := issuerFileName(issuer.Issuer)
+		if strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+			t.Errorf("Unsafe file name %s for %s", name, issuer.Issuer)
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Missing file for %s: %s", issuer.Issuer, err)
+			continue
+		}
+		var decoded struct {
+			Issuer      string
+			Reputations []IssuerReputation
+			Certs       []CertSummary
+		}
+		if err := json.Unmarshal(contents, &decoded); err != nil {
+			t.Errorf("Invalid JSON for %s: %s", issuer.Issuer, err)
+			continue
+		}
+		if decoded.Issuer != issuer.Issuer || len(decoded.Reputations) != 1 ||
+			len(decoded.Certs) != 1 || decoded.Certs[0].Issuer != issuer.Issuer {
+			t.Errorf("Unexpected contents for %s:\n%s", issuer.Issuer, contents)
+		}
+	}
+}
+
+func TestIssuerFileNamesDontCollide(t *testing.T) {
+	if issuerFileName("CN=A/B") == issuerFileName("CN=A_B") {
+		t.Error("Issuers that sanitize the same way share a file name")
+	}
+}
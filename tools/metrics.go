@@ -0,0 +1,60 @@
+package main
+
+import (
+	. "github.com/mozkeeler/sunlight"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync/atomic"
+)
+
+// Prometheus metrics about an Analyzer's progress, for running as a
+// monitoring job.
+type metrics struct {
+	registry   *prometheus.Registry
+	violations *prometheus.CounterVec
+}
+
+func newMetrics(analyzer *Analyzer) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		violations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "violations_total",
+			Help: "Certs found violating the baseline requirements, by violation.",
+		}, []string{"type"}),
+	}
+	m.registry.MustRegister(m.violations)
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "certs_processed",
+		Help: "Certs that were summarized.",
+	}, func() float64 {
+		return float64(atomic.LoadUint64(&analyzer.Summarized))
+	}))
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "parse_errors",
+		Help: "Entries that couldn't be parsed.",
+	}, func() float64 {
+		return float64(atomic.LoadUint64(&analyzer.ParseErrors))
+	}))
+	return m
+}
+
+// Counts each of the violations in summary. Does nothing if m is nil, so it
+// can be called whether or not metrics are enabled.
+func (m *metrics) recordViolations(summary *CertSummary) {
+	if m == nil {
+		return
+	}
+	for name, violated := range summary.Violations {
+		if violated {
+			m.violations.WithLabelValues(name).Inc()
+		}
+	}
+}
+
+// Returns a handler serving the metrics at /metrics.
+func (m *metrics) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	return mux
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/monicachew/certificatetransparency"
+	. "github.com/mozkeeler/sunlight"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	now := time.Now()
+	var m *metrics
+	analyzer := NewAnalyzer(nil, nil, nil,
+		SinkFunc(func(summary *CertSummary, cert *x509.Certificate) error {
+			m.recordViolations(summary)
+			return nil
+		}))
+	m = newMetrics(analyzer)
+	// Two certs that are valid for too long, and one that's fine.
+	for i, years := range []int{6, 6, 1} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: "metrics.example.com"},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.AddDate(years, 0, 0),
+			DNSNames:     []string{"metrics.example.com"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template,
+			&key.PublicKey, key)
+		if err != nil {
+			t.Fatal("could not create cert", err)
+		}
+		analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(now.Unix()) * 1000,
+				X509Cert:  der,
+			},
+		}, nil)
+	}
+	analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+		Index: 3,
+		Entry: &certificatetransparency.Entry{X509Cert: []byte("corrupt")},
+	}, nil)
+
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal("could not scrape metrics", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("could not read metrics", err)
+	}
+	for _, line := range []string{
+		"certs_processed 3",
+		"parse_errors 1",
+		`violations_total{type="ValidPeriodTooLong"} 2`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Expected metrics to contain %q:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	. "github.com/mozkeeler/sunlight"
+	"os"
+	"sync"
+	"time"
+)
+
+// An output file, written through a buffer so that each record isn't a
+// syscall of its own. Writes and flushes are safe to call concurrently, so
+// the output can be flushed periodically while sinks are writing to it.
+type bufferedOutput struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	// If set, each flush also syncs the file to disk.
+	fsync bool
+}
+
+// Opens the file JSON output goes to, truncating it if it exists, or stdout
+// if name is "-".
+func openJSONOutput(name string) (*bufferedOutput, error) {
+	file := os.Stdout
+	if name != "-" {
+		var err error
+		file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &bufferedOutput{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (o *bufferedOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.writer.Write(p)
+}
+
+// Writes out everything buffered so far.
+func (o *bufferedOutput) Flush() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if err := o.writer.Flush(); err != nil {
+		return err
+	}
+	if o.fsync && o.file != os.Stdout {
+		return o.file.Sync()
+	}
+	return nil
+}
+
+// Flushes the output and closes the file, unless it's stdout.
+func (o *bufferedOutput) Close() error {
+	err := o.Flush()
+	if o.file == os.Stdout {
+		return err
+	}
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Reads back the JSON output of NewJSONSink from the file name and returns how
+// many summaries it has, or an error if it doesn't parse, as when a run
+// crashed partway through writing it.
+func validateJSONOutput(name string) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var output struct{ Certs []CertSummary }
+	if err := json.NewDecoder(file).Decode(&output); err != nil {
+		return 0, fmt.Errorf("invalid JSON output: %s", err)
+	}
+	if output.Certs == nil {
+		return 0, fmt.Errorf("invalid JSON output: no Certs array")
+	}
+	return len(output.Certs), nil
+}
+
+// Flushes outputs every interval, so that a long run's output can be followed
+// as it's written, until the returned function is called. Errors are left for
+// Close to report.
+func flushPeriodically(interval time.Duration,
+	outputs ...*bufferedOutput) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				for _, output := range outputs {
+					output.Flush()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	. "github.com/mozkeeler/sunlight"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONOutputTruncates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "certs.json")
+	// Left over from a previous, larger run.
+	if err := ioutil.WriteFile(name, []byte(strings.Repeat("x", 10000)), 0644); err != nil {
+		t.Fatal("could not write previous output", err)
+	}
+
+	out, err := openJSONOutput(name)
+	if err != nil {
+		t.Fatal("could not open JSON output", err)
+	}
+	out.fsync = true
+	if _, err := out.Write([]byte("{}\n")); err != nil {
+		t.Fatal("could not write JSON output", err)
+	}
+	if written, _ := ioutil.ReadFile(name); len(written) != 0 {
+		t.Errorf("Expected output to be buffered, got %q", written)
+	}
+	if err := out.Flush(); err != nil {
+		t.Fatal("could not flush JSON output", err)
+	}
+	if written, _ := ioutil.ReadFile(name); string(written) != "{}\n" {
+		t.Errorf("Expected flushed output, got %q", written)
+	}
+	if _, err := out.Write([]byte("[]\n")); err != nil {
+		t.Fatal("could not write JSON output", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal("could not close JSON output", err)
+	}
+	if written, _ := ioutil.ReadFile(name); string(written) != "{}\n[]\n" {
+		t.Errorf("Unexpected output %q", written)
+	}
+}
+
+func TestFlushPeriodically(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "certs.json")
+	out, err := openJSONOutput(name)
+	if err != nil {
+		t.Fatal("could not open JSON output", err)
+	}
+	defer out.Close()
+
+	stop := flushPeriodically(time.Millisecond, out)
+	out.Write([]byte("{}\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if written, _ := ioutil.ReadFile(name); string(written) == "{}\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Output wasn't flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+}
+
+func TestValidateJSONOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "certs.json")
+	out, err := openJSONOutput(name)
+	if err != nil {
+		t.Fatal("could not open JSON output", err)
+	}
+	sink := NewJSONSink(out)
+	for i := uint64(0); i < 3; i++ {
+		sink.Write(&CertSummary{CN: "example.com", LogIndex: i}, nil)
+	}
+	sink.Close()
+	if err := out.Close(); err != nil {
+		t.Fatal("could not close JSON output", err)
+	}
+	if count, err := validateJSONOutput(name); err != nil || count != 3 {
+		t.Errorf("Expected 3 valid summaries, got %d, %v", count, err)
+	}
+
+	// As if the run had crashed partway through.
+	contents, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal("could not read JSON output", err)
+	}
+	truncated := filepath.Join(dir, "truncated.json")
+	if err := ioutil.WriteFile(truncated, contents[:len(contents)/2], 0644); err != nil {
+		t.Fatal("could not write truncated output", err)
+	}
+	if _, err := validateJSONOutput(truncated); err == nil {
+		t.Error("Expected an error for truncated output")
+	}
+}
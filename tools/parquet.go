@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/x509"
+	. "github.com/mozkeeler/sunlight"
+	"github.com/parquet-go/parquet-go"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A row of the Parquet output, which has a column for each field. Violations
+// holds the names of the violations found, sorted, as in CSV output.
+type parquetRow struct {
+	LogIndex          uint64    `parquet:"logIndex"`
+	Timestamp         uint64    `parquet:"timestamp"`
+	CN                string    `parquet:"cn,dict"`
+	Issuer            string    `parquet:"issuer,dict"`
+	Sha256Fingerprint string    `parquet:"sha256Fingerprint"`
+	NotBefore         time.Time `parquet:"notBefore,timestamp"`
+	NotAfter          time.Time `parquet:"notAfter,timestamp"`
+	KeySize           int32     `parquet:"keySize"`
+	IsCA              bool      `parquet:"isCA"`
+	Precert           bool      `parquet:"precert"`
+	IssuerInMozillaDB bool      `parquet:"issuerInMozillaDB"`
+	MaxReputation     float32   `parquet:"maxReputation"`
+	DnsNames          []string  `parquet:"dnsNames,list"`
+	IpAddresses       []string  `parquet:"ipAddresses,list"`
+	Violations        []string  `parquet:"violations,list"`
+}
+
+func newParquetRow(summary *CertSummary) parquetRow {
+	var violations []string
+	for name, val := range summary.Violations {
+		if val {
+			violations = append(violations, name)
+		}
+	}
+	sort.Strings(violations)
+	return parquetRow{
+		LogIndex:          summary.LogIndex,
+		Timestamp:         summary.Timestamp,
+		CN:                summary.CN,
+		Issuer:            summary.Issuer,
+		Sha256Fingerprint: summary.Sha256Fingerprint,
+		NotBefore:         summary.NotBeforeTime,
+		NotAfter:          summary.NotAfterTime,
+		KeySize:           int32(summary.KeySize),
+		IsCA:              summary.IsCA,
+		Precert:           summary.Precert,
+		IssuerInMozillaDB: summary.IssuerInMozillaDB,
+		MaxReputation:     summary.MaxReputation,
+		DnsNames:          summary.DnsNames,
+		IpAddresses:       summary.IpAddresses,
+		Violations:        violations,
+	}
+}
+
+// Writes summaries to a writer as Parquet, for loading large numbers of them
+// into tools like Spark or DuckDB.
+type parquetSink struct {
+	out  *parquet.GenericWriter[parquetRow]
+	lock sync.Mutex
+}
+
+// Returns a Sink writing a Parquet row for each summary to out. Closing it
+// writes the file's footer, without which the output can't be read, but
+// doesn't close out.
+func newParquetSink(out io.Writer) Sink {
+	return &parquetSink{out: parquet.NewGenericWriter[parquetRow](out)}
+}
+
+func (w *parquetSink) Write(summary *CertSummary, cert *x509.Certificate) error {
+	row := newParquetRow(summary)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err := w.out.Write([]parquetRow{row})
+	return err
+}
+
+func (w *parquetSink) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.out.Close()
+}
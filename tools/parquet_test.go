@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/mozkeeler/sunlight"
+	"github.com/parquet-go/parquet-go"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParquetSink(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	summaries := []*CertSummary{
+		{
+			CN:                "www.example.com",
+			Issuer:            "CN=Test CA",
+			Sha256Fingerprint: "abcd",
+			NotBeforeTime:     notBefore,
+			NotAfterTime:      notBefore.AddDate(6, 0, 0),
+			KeySize:           2048,
+			DnsNames:          []string{"example.com", "www.example.com"},
+			Violations: map[string]bool{
+				VALID_PERIOD_TOO_LONG: true,
+				KEY_TOO_SHORT:         false,
+				MISSING_CN_IN_SAN:     true,
+			},
+			MaxReputation: 0.5,
+			Timestamp:     1402580730123,
+			LogIndex:      7,
+		},
+		{
+			CN:            "192.0.2.1",
+			Issuer:        "CN=Test CA",
+			NotBeforeTime: notBefore,
+			NotAfterTime:  notBefore.AddDate(1, 0, 0),
+			KeySize:       -1,
+			IsCA:          true,
+			IpAddresses:   []string{"192.0.2.1"},
+			Violations:    map[string]bool{KEY_TOO_SHORT: true},
+			MaxReputation: -1,
+			Precert:       true,
+			LogIndex:      8,
+		},
+	}
+	var out bytes.Buffer
+	sink := newParquetSink(&out)
+	for _, summary := range summaries {
+		if err := sink.Write(summary, nil); err != nil {
+			t.Fatal("could not write summary", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal("could not close sink", err)
+	}
+
+	rows, err := parquet.Read[parquetRow](bytes.NewReader(out.Bytes()),
+		int64(out.Len()))
+	if err != nil {
+		t.Fatal("could not read Parquet output", err)
+	}
+	if len(rows) != len(summaries) {
+		t.Fatalf("Expected %d rows, got %d", len(summaries), len(rows))
+	}
+	for i, summary := range summaries {
+		expected := newParquetRow(summary)
+		if !rows[i].NotBefore.Equal(expected.NotBefore) ||
+			!rows[i].NotAfter.Equal(expected.NotAfter) {
+			t.Errorf("Row %d: expected validity %s to %s, got %s to %s", i,
+				expected.NotBefore, expected.NotAfter, rows[i].NotBefore,
+				rows[i].NotAfter)
+		}
+		rows[i].NotBefore, rows[i].NotAfter = expected.NotBefore, expected.NotAfter
+		// Empty lists are read back as empty slices rather than nil ones,
+		// which print the same.
+		if fmt.Sprintf("%+v", rows[i]) != fmt.Sprintf("%+v", expected) {
+			t.Errorf("Row %d: expected %+v, got %+v", i, expected, rows[i])
+		}
+	}
+	if violations := rows[0].Violations; !reflect.DeepEqual(violations,
+		[]string{MISSING_CN_IN_SAN, VALID_PERIOD_TOO_LONG}) {
+		t.Errorf("Expected the violations found, sorted, got %v", violations)
+	}
+}
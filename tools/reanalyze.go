@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	. "github.com/mozkeeler/sunlight"
+)
+
+// The baselineRequirements column recording each violation.
+var violationColumns = map[string]string{
+	VALID_PERIOD_TOO_LONG:          "validPeriodTooLong",
+	DEPRECATED_SIGNATURE_ALGORITHM: "deprecatedSignatureAlgorithm",
+	DEPRECATED_VERSION:             "deprecatedVersion",
+	MISSING_CN_IN_SAN:              "missingCNinSAN",
+	KEY_TOO_SHORT:                  "keyTooShort",
+	EXP_TOO_SMALL:                  "expTooSmall",
+	FUTURE_NOT_BEFORE:              "futureNotBefore",
+	WEAK_RSA_MODULUS:               "weakRSAModulus",
+	SCT_SIGNATURE_INVALID:          "sctSignatureInvalid",
+	NO_SAN_EXTENSION:               "noSANExtension",
+	UNKNOWN_SIGNATURE_ALGORITHM:    "unknownSignatureAlgorithm",
+	POISON_ON_FINAL_CERT:           "poisonOnFinalCert",
+	KEY_IDENTIFIER_MISMATCH:        "keyIdentifierMismatch",
+	LEAF_OUTLIVES_ISSUER:           "leafOutlivesIssuer",
+	ILLEGAL_DNS_CHARACTER:          "illegalDNSCharacter",
+	MIXED_WILDCARD_AND_IP:          "mixedWildcardAndIP",
+	EMPTY_SUBJECT_NO_SAN:           "emptySubjectNoSAN",
+	PATHLEN_EXCEEDED:               "pathLenExceeded",
+	PUBLIC_SUFFIX_SAN:              "publicSuffixSAN",
+	EXTENSIONS_BEFORE_V3:           "extensionsBeforeV3",
+	MISSING_AKI:                    "missingAKI",
+	MULTIPLE_CN:                    "multipleCN",
+	MISSING_CERT_POLICY:            "missingCertPolicy",
+	SHA1_IN_CHAIN:                  "sha1InChain",
+	RESERVED_TLD:                   "reservedTLD",
+	LATE_LOGGING:                   "lateLogging",
+	NONSTANDARD_RSA_EXPONENT:       "nonstandardRSAExponent",
+	MISSING_OCSP:                   "missingOCSP",
+	MISSING_CRL:                    "missingCRL",
+	NAME_CONSTRAINT_VIOLATION:      "nameConstraintViolation",
+	MISPLACED_WILDCARD:             "misplacedWildcard",
+	FORBIDDEN_SAN_TYPE:             "forbiddenSANType",
+	UNPARSEABLE_KEY:                "unparseableKey",
+	SHA1_AFTER_SUNSET:              "sha1AfterSunset",
+	MALFORMED_VALIDITY_DATES:       "malformedValidityDates",
+	KEY_USAGE_MISMATCH:             "keyUsageMismatch",
+	INSUFFICIENT_SCTS:              "insufficientSCTs",
+	EMPTY_ORGANIZATION:             "emptyOrganization",
+	BROKEN_SIGNATURE_ALGORITHM:     "brokenSignatureAlgorithm",
+	ALGORITHM_MISMATCH:             "algorithmMismatch",
+	IP_AS_DNS_NAME:                 "ipAsDNSName",
+}
+
+// Violations that can only be found with the chain a cert was logged with.
+// The chain isn't stored, so reanalyzing leaves their columns as the original
+// run found them.
+var chainViolations = map[string]bool{
+	SCT_SIGNATURE_INVALID:     true,
+	KEY_IDENTIFIER_MISMATCH:   true,
+	LEAF_OUTLIVES_ISSUER:      true,
+	PATHLEN_EXCEEDED:          true,
+	SHA1_IN_CHAIN:             true,
+	NAME_CONSTRAINT_VIOLATION: true,
+}
+
+type storedCert struct {
+	rowid     int64
+	rawDer    []byte
+	timestamp uint64
+	logIndex  uint64
+	precert   bool
+}
+
+// Re-runs CalculateCertSummary with config on each cert stored in the
+// baselineRequirements table of db, updating its violation columns in place.
+// Only the violations config checks for are updated, and not those in
+// chainViolations, since the chain each cert was logged with isn't stored.
+// Returns the number of rows updated.
+func reanalyze(db *sql.DB, config *RuleConfig) (int, error) {
+	rows, err := db.Query(`select rowid, rawDer, timestamp, logIndex, precert
+		from baselineRequirements where rawDer is not null`)
+	if err != nil {
+		return 0, err
+	}
+	var stored []storedCert
+	for rows.Next() {
+		var row storedCert
+		err = rows.Scan(&row.rowid, &row.rawDer, &row.timestamp, &row.logIndex,
+			&row.precert)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stored = append(stored, row)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, row := range stored {
+		cert, err := ParseCertificate(row.rawDer)
+		if err != nil {
+			tx.Rollback()
+			return updated, fmt.Errorf("row %d: %s", row.rowid, err)
+		}
+		summary, err := CalculateCertSummary(cert, row.logIndex, row.timestamp,
+			row.precert, nil, nil, nil, config)
+		if err != nil {
+			tx.Rollback()
+			return updated, fmt.Errorf("row %d: %s", row.rowid, err)
+		}
+		query := "update baselineRequirements set"
+		args := make([]interface{}, 0, len(summary.Violations)+1)
+		for _, name := range ViolationNames {
+			violation, checked := summary.Violations[name]
+			if !checked || chainViolations[name] {
+				continue
+			}
+			if len(args) > 0 {
+				query += ","
+			}
+			query += fmt.Sprintf(" %s = ?", violationColumns[name])
+			args = append(args, violation)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		query += " where rowid = ?"
+		args = append(args, row.rowid)
+		if _, err = tx.Exec(query, args...); err != nil {
+			tx.Rollback()
+			return updated, fmt.Errorf("row %d: %s", row.rowid, err)
+		}
+		updated++
+	}
+	return updated, tx.Commit()
+}
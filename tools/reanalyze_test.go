@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	. "github.com/mozkeeler/sunlight"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReanalyze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "BRs.db"))
+	if err != nil {
+		t.Fatal("could not open DB", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(createTables); err != nil {
+		t.Fatal("could not create tables", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("could not generate RSA key", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rsa.example.com"},
+		NotBefore:    time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:     []string{"rsa.example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal("could not create cert", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("could not parse cert", err)
+	}
+	summary, _ := CalculateCertSummary(cert, 3, 0, false, nil, nil, nil, nil)
+	if summary.Violations[KEY_TOO_SHORT] {
+		t.Fatal("2048-bit key shouldn't be too short by default")
+	}
+	// As though the original run found it with the chain, which isn't
+	// stored.
+	summary.Violations[SHA1_IN_CHAIN] = true
+	stmt, err := db.Prepare(insertEntry)
+	if err != nil {
+		t.Fatal("could not prepare insert", err)
+	}
+	defer stmt.Close()
+	if err = insertSummary(stmt, summary, cert, false); err != nil {
+		t.Fatal("could not insert summary", err)
+	}
+
+	updated, err := reanalyze(db, &RuleConfig{ShortKeyBits: 2048})
+	if err != nil {
+		t.Fatal("could not re-analyze", err)
+	}
+	if updated != 1 {
+		t.Errorf("Expected 1 row to be updated, got %d", updated)
+	}
+	var keyTooShort, sha1InChain bool
+	var logIndex uint64
+	err = db.QueryRow(`select keyTooShort, sha1InChain, logIndex
+		from baselineRequirements`).Scan(&keyTooShort, &sha1InChain, &logIndex)
+	if err != nil {
+		t.Fatal("could not read back row", err)
+	}
+	if !keyTooShort || logIndex != 3 {
+		t.Errorf("Expected keyTooShort and logIndex 3, got %t and %d",
+			keyTooShort, logIndex)
+	}
+	if !sha1InChain {
+		t.Error("Expected sha1InChain, which needs the chain, to be kept")
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto"
 	"crypto/x509"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,60 +12,240 @@ import (
 	"github.com/monicachew/alexa"
 	"github.com/monicachew/certificatetransparency"
 	. "github.com/mozkeeler/sunlight"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"sync"
+	"sort"
+	"strings"
 	"time"
 )
 
+// The version recorded in runMetadata. Set it when building with
+// -ldflags "-X main.toolVersion=...".
+var toolVersion = "dev"
+
 // Flags
 var alexaFile string
 var dbFile string
 var ctLog string
+var ctLogDir string
+var certFile string
+var ctLogGlob string
+var ctURL string
+var pollInterval time.Duration
+var pollStateFile string
 var jsonFile string
 var maxEntries uint64
 var rootCAFile string
+var rankCountWeight float64
+var errorLogFile string
+var ctLogKeysFile string
+var checkList string
+var failOn string
+var issuersFile string
+var maxIssuers int
+var weightList string
+var logLevelName string
+var quiet bool
+var shortKeyBits int
+var reanalyzeDB bool
+var checkpointFile string
+var validityHistogram bool
+var strictCNInSAN bool
+var sha1Cutoff string
+var sanReputations bool
+var ctVersion int
+var metricsAddr string
+var apiAddr string
+var apiRecent int
+var apiWait bool
+var singleThreaded bool
+var workers int
+var sampleRate float64
+var sampleSeed int64
+var afterIndex uint64
+var beforeIndex uint64
+var issuerJSONFile string
+var timeSeriesFile string
+var rankFormat string
+var offendersFile string
+var offendersChecks string
+var offendersThreshold float64
+var outputDir string
+var keyReuseFile string
+var keyReuseIssuers int
+var domainsFile string
+var domainsMinCerts int
+var notBeforeCutoff string
+var includeExpired bool
+var excludeExpiredAt string
+var storePEM bool
+var validateOutput bool
+var rootProgramFiles repeatedFlag
+var excludeIssuersFile string
+var ndjsonFile string
+var flushInterval time.Duration
+var fsyncOutput bool
+var csvFile string
+var parquetFile string
 
 func init() {
 	flag.StringVar(&alexaFile, "alexa_file", "top-1m.csv",
 		"CSV containing <rank, domain>")
+	flag.StringVar(&rankFormat, "rank_format", RANK_FORMAT_ALEXA,
+		"Format of alexa_file: alexa, tranco or umbrella")
 	flag.StringVar(&dbFile, "db_file", "BRs.db", "File for creating sqlite DB")
 	flag.StringVar(&ctLog, "ct_log", "ct_entries.log", "File containing CT log")
-	flag.StringVar(&jsonFile, "json_file", "certs.json", "JSON summary output")
-	flag.Uint64Var(&maxEntries, "max_entries", 0, "Max entries (0 means all)")
+	flag.StringVar(&certFile, "cert_file", "",
+		"If set, analyze the certs in this PEM or DER file instead of a CT log. "+
+			"The first cert is the leaf and the rest are its chain")
+	flag.StringVar(&ctLogDir, "ct_log_dir", "",
+		"Directory of CT log files to process instead of ct_log")
+	flag.StringVar(&ctLogGlob, "ct_log_glob", "*",
+		"Only process files in ct_log_dir whose names match this pattern")
+	flag.StringVar(&ctURL, "ct_url", "",
+		"If set, fetch entries from the CT log at this URL (without /ct/v1/), "+
+			"starting at after_index, instead of reading ct_log")
+	flag.DurationVar(&pollInterval, "poll_interval", 0,
+		"If set, keep fetching entries from ct_url this often as the log grows, "+
+			"until interrupted, instead of stopping at its current size")
+	flag.StringVar(&pollStateFile, "poll_state_file", "",
+		"If set, where to record the index of the next entry to fetch from "+
+			"ct_url after each poll, and to carry on from if it exists")
+	flag.StringVar(&jsonFile, "json_file", "certs.json",
+		"JSON summary output (- for stdout)")
+	flag.StringVar(&ndjsonFile, "ndjson_file", "",
+		"If set, newline-delimited JSON summary output (- for stdout)")
+	flag.StringVar(&csvFile, "csv_file", "", "If set, CSV summary output")
+	flag.StringVar(&parquetFile, "parquet_file", "",
+		"If set, Parquet summary output, for loading into Spark, DuckDB and the like")
+	flag.DurationVar(&flushInterval, "flush_interval", 10*time.Second,
+		"How often buffered JSON output is written out (0 to only write it "+
+			"out when full and at the end)")
+	flag.BoolVar(&fsyncOutput, "fsync", false,
+		"Sync JSON output files to disk each time they're flushed")
+	flag.Uint64Var(&maxEntries, "max_entries", 0,
+		"Max entries per log file (0 means all)")
 	flag.StringVar(&rootCAFile, "rootCA_file", "rootCAList.txt", "list of root CA CNs")
+	flag.Var(&rootProgramFiles, "root_program",
+		"name=file of another root program's root CAs, in the format of "+
+			"rootCA_file, to record membership of besides Mozilla's (repeatable)")
+	flag.Float64Var(&rankCountWeight, "rank_count_weight", 0.5,
+		"How much issuance volume counts in the issuer ranking, in [0, 1]")
+	flag.StringVar(&errorLogFile, "error_log", "",
+		"File recording the index and error of entries that fail to parse")
+	flag.StringVar(&ctLogKeysFile, "ct_log_keys", "",
+		"PEM file of CT log public keys for verifying embedded SCTs, or a log "+
+			"list in the JSON format of Chrome's (ending in .json), which also "+
+			"gives the logs' operators")
+	flag.StringVar(&checkList, "checks", "",
+		"Comma-separated violations to check for (empty means all)")
+	flag.StringVar(&failOn, "fail_on", "",
+		"Comma-separated violations (or all) that make the run exit with status 3 "+
+			"if any cert has them, for gating issuance in CI")
+	flag.StringVar(&weightList, "weights", "",
+		"How much each violation counts towards issuer scores: \"default\" "+
+			"for the default weights, or comma-separated name=weight pairs, "+
+			"with unlisted violations weighing 1 (empty means all equal)")
+	flag.BoolVar(&quiet, "quiet", false,
+		"Only log errors, whatever log_level is")
+	flag.StringVar(&logLevelName, "log_level", "info",
+		"Least severe messages to log: debug, info, warn or error")
+	flag.IntVar(&shortKeyBits, "short_key_bits", DEFAULT_SHORT_KEY_BITS,
+		"RSA keys with at most this many bits are too short")
+	flag.BoolVar(&reanalyzeDB, "reanalyze", false,
+		"Re-check the certs already in db_file instead of reading a CT log "+
+			"(the same as the reanalyze command)")
+	flag.StringVar(&issuersFile, "issuers_file", "",
+		"If set, issuer reputations are loaded from this file if it exists and "+
+			"saved to it afterwards, so that they accumulate across runs")
+	flag.IntVar(&maxIssuers, "max_issuers", 0,
+		"If non-zero, the most issuer reputations (per issuer and month) to "+
+			"hold in memory. The oldest are recorded in the DB early to make "+
+			"room, and can't be updated afterwards or included in the ranking, "+
+			"issuer JSON, offenders or issuer files")
+	flag.StringVar(&checkpointFile, "checkpoint_file", "checkpoint.json",
+		"Where to record how far an interrupted run got")
+	flag.BoolVar(&validityHistogram, "validity_histogram", false,
+		"Record a histogram of leaf cert validity periods")
+	flag.BoolVar(&strictCNInSAN, "strict_cn_in_san", false,
+		"Don't count a CN covered only by a wildcard SAN as being in the SAN")
+	flag.StringVar(&sha1Cutoff, "sha1_cutoff",
+		DefaultSHA1Cutoff.Format("2006-01-02"),
+		"SHA-1 certs issued after this date (YYYY-MM-DD) are SHA1AfterSunset")
+	flag.BoolVar(&sanReputations, "san_reputations", false,
+		"Record the reputation of each ranked DNS name in summaries, not just "+
+			"the greatest, at the cost of much bigger output")
+	flag.IntVar(&ctVersion, "ct_version", 1,
+		"CT entry format to accept: 1 (RFC 6962) or 2 (also RFC 9162)")
+	flag.StringVar(&metricsAddr, "metrics_addr", "",
+		"If set, address to serve Prometheus metrics on at /metrics")
+	flag.StringVar(&apiAddr, "api_addr", "",
+		"If set, address to serve issuer reputations and recent violations on "+
+			"as JSON, at /issuers, /issuers/<issuer> and /violations/recent")
+	flag.IntVar(&apiRecent, "api_recent", 100,
+		"How many of the most recent violating certs the API serves")
+	flag.BoolVar(&apiWait, "api_wait", false,
+		"Once done, keep serving the API until interrupted")
+	flag.BoolVar(&singleThreaded, "single_threaded", false,
+		"Process entries one at a time in log order, for reproducible output")
+	flag.IntVar(&workers, "workers", 0,
+		"If positive, process at most this many entries at once, whatever "+
+			"GOMAXPROCS is (output order still varies unless -single_threaded)")
+	flag.Float64Var(&sampleRate, "sample_rate", 1,
+		"Fraction of entries to process, in (0, 1], for approximate statistics")
+	flag.Int64Var(&sampleSeed, "sample_seed", 1,
+		"Seed choosing which entries are sampled when sample_rate is below 1")
+	flag.Uint64Var(&afterIndex, "after_index", 0,
+		"Skip the entries of each log file before this index, to start partway in")
+	flag.Uint64Var(&beforeIndex, "before_index", 0,
+		"If non-zero, skip the entries of each log file from this index on, so "+
+			"that with after_index a log can be split into ranges for separate runs")
+	flag.StringVar(&issuerJSONFile, "issuer_json_file", "",
+		"If set, JSON output of the finished issuer reputations (- for stdout)")
+	flag.StringVar(&timeSeriesFile, "time_series_file", "",
+		"If set, JSON output of each issuer's normalized score by month (- for stdout)")
+	flag.StringVar(&offendersFile, "offenders_file", "",
+		"If set, JSON report of issuers over offenders_threshold (- for stdout)")
+	flag.StringVar(&offendersChecks, "offenders_checks", "",
+		"Comma-separated violations to report offenders for (empty means all)")
+	flag.Float64Var(&offendersThreshold, "offenders_threshold", 0.05,
+		"Fraction of an issuer's certs that may have a violation, in [0, 1]")
+	flag.StringVar(&outputDir, "output_dir", "",
+		"If set, directory to write a JSON file of results for each issuer to")
+	flag.StringVar(&keyReuseFile, "key_reuse_file", "",
+		"If set, JSON report of leaf cert keys used under several issuers (- for stdout)")
+	flag.IntVar(&keyReuseIssuers, "key_reuse_issuers", 1,
+		"Report keys used under more than this many distinct issuers")
+	flag.StringVar(&domainsFile, "domains_file", "",
+		"If set, JSON report of registrable domains covered by violating certs (- for stdout)")
+	flag.IntVar(&domainsMinCerts, "domains_min_certs", 1,
+		"Report domains covered by more than this many violating certs")
+	flag.StringVar(&notBeforeCutoff, "not_before_cutoff",
+		DefaultNotBeforeCutoff.Format("2006-01-02"),
+		"Leave out certs issued before this date (YYYY-MM-DD, empty for none)")
+	flag.StringVar(&excludeIssuersFile, "exclude_issuers_file", "",
+		"If set, file of issuer name substrings (one per line) whose certs are "+
+			"left out, such as those of test and staging CAs")
+	flag.BoolVar(&includeExpired, "include_expired", false,
+		"Include certs that have already expired")
+	flag.StringVar(&excludeExpiredAt, "exclude_expired_at", "",
+		"Leave out certs that had expired by this date (YYYY-MM-DD) rather "+
+			"than by now, so that a run can be reproduced later")
+	flag.BoolVar(&validateOutput, "validate_output", false,
+		"Once done, check that the JSON output parses, exiting with status 1 if "+
+			"it doesn't")
+	flag.BoolVar(&storePEM, "store_pem", false,
+		"Store the PEM of each violating cert in the certPem column of "+
+			"baselineRequirements")
 	runtime.GOMAXPROCS(runtime.NumCPU())
 }
 
-func certToString(cert *x509.Certificate) string {
-	if cert == nil {
-		return ""
-	}
-	b64 := base64.StdEncoding.EncodeToString(cert.Raw)
-	re := regexp.MustCompile(`(\S{64})`)
-	b64WithNewlines := re.ReplaceAllString(b64, "$1\r\n")
-	return "-----BEGIN CERTIFICATE-----\r\n" + b64WithNewlines + "\r\n-----END CERTIFICATE-----\r\n"
-}
-
-func main() {
-	flag.Parse()
-	if flag.NArg() != 0 {
-		flag.PrintDefaults()
-		os.Exit(1)
-	}
-
-	var ranker alexa.AlexaRank
-	ranker.Init(alexaFile)
-	db, err := sql.Open("sqlite3", dbFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open %s: %s\n", dbFile, err)
-		flag.PrintDefaults()
-		os.Exit(1)
-	}
-	defer db.Close()
-
-	createTables := `
+const createTables = `
 	drop table if exists baselineRequirements;
 	create table baselineRequirements(
 		cn text, issuer text,
@@ -78,10 +259,54 @@ func main() {
 		version integer, dnsNames string,
 		ipAddresses string, maxReputation float,
 		issuerInMozillaDB bool,
-		timestamp bigint);
+		timestamp bigint, futureNotBefore bool,
+		logIndex bigint,
+		weakRSAModulus bool,
+		sctSignatureInvalid bool,
+		embeddedSCTCount integer,
+		rawDer blob,
+		certPem text,
+		rootPrograms text,
+		precert bool,
+		subjectKeyId text,
+		authorityKeyId text,
+		validationLevel text,
+		noSANExtension bool,
+		unknownSignatureAlgorithm bool,
+		poisonOnFinalCert bool,
+		keyIdentifierMismatch bool,
+		leafOutlivesIssuer bool,
+		illegalDNSCharacter bool,
+		mixedWildcardAndIP bool,
+		emptySubjectNoSAN bool,
+		pathLenExceeded bool,
+		publicSuffixSAN bool,
+		extensionsBeforeV3 bool,
+		missingAKI bool,
+		multipleCN bool,
+		missingCertPolicy bool,
+		sha1InChain bool,
+		reservedTLD bool,
+		lateLogging bool,
+		nonstandardRSAExponent bool,
+		missingOCSP bool,
+		missingCRL bool,
+		nameConstraintViolation bool,
+		misplacedWildcard bool,
+		forbiddenSANType bool,
+		unparseableKey bool,
+		sha1AfterSunset bool,
+		malformedValidityDates bool,
+		keyUsageMismatch bool,
+		insufficientSCTs bool,
+		emptyOrganization bool,
+		brokenSignatureAlgorithm bool,
+		algorithmMismatch bool,
+		ipAsDNSName bool);
 	drop table if exists issuerReputation;
 	create table issuerReputation(
 		issuer text,
+		issuerSha256Fingerprint text,
 		issuerInMozillaDB bool,
 		validPeriodTooLongNormalizedScore float,
 		validPeriodTooLongRawScore float,
@@ -95,41 +320,116 @@ func main() {
 		keyTooShortRawScore float,
 		expTooSmallNormalizedScore float,
 		expTooSmallRawScore float,
+		futureNotBeforeNormalizedScore float,
+		futureNotBeforeRawScore float,
+		weakRSAModulusNormalizedScore float,
+		weakRSAModulusRawScore float,
+		sctSignatureInvalidNormalizedScore float,
+		sctSignatureInvalidRawScore float,
+		noSANExtensionNormalizedScore float,
+		noSANExtensionRawScore float,
+		unknownSignatureAlgorithmNormalizedScore float,
+		unknownSignatureAlgorithmRawScore float,
+		poisonOnFinalCertNormalizedScore float,
+		poisonOnFinalCertRawScore float,
+		keyIdentifierMismatchNormalizedScore float,
+		keyIdentifierMismatchRawScore float,
+		leafOutlivesIssuerNormalizedScore float,
+		leafOutlivesIssuerRawScore float,
+		illegalDNSCharacterNormalizedScore float,
+		illegalDNSCharacterRawScore float,
+		mixedWildcardAndIPNormalizedScore float,
+		mixedWildcardAndIPRawScore float,
+		emptySubjectNoSANNormalizedScore float,
+		emptySubjectNoSANRawScore float,
+		pathLenExceededNormalizedScore float,
+		pathLenExceededRawScore float,
+		publicSuffixSANNormalizedScore float,
+		publicSuffixSANRawScore float,
+		extensionsBeforeV3NormalizedScore float,
+		extensionsBeforeV3RawScore float,
+		missingAKINormalizedScore float,
+		missingAKIRawScore float,
+		multipleCNNormalizedScore float,
+		multipleCNRawScore float,
+		missingCertPolicyNormalizedScore float,
+		missingCertPolicyRawScore float,
+		sha1InChainNormalizedScore float,
+		sha1InChainRawScore float,
+		reservedTLDNormalizedScore float,
+		reservedTLDRawScore float,
+		lateLoggingNormalizedScore float,
+		lateLoggingRawScore float,
+		nonstandardRSAExponentNormalizedScore float,
+		nonstandardRSAExponentRawScore float,
+		missingOCSPNormalizedScore float,
+		missingOCSPRawScore float,
+		missingCRLNormalizedScore float,
+		missingCRLRawScore float,
+		nameConstraintViolationNormalizedScore float,
+		nameConstraintViolationRawScore float,
+		misplacedWildcardNormalizedScore float,
+		misplacedWildcardRawScore float,
+		forbiddenSANTypeNormalizedScore float,
+		forbiddenSANTypeRawScore float,
+		unparseableKeyNormalizedScore float,
+		unparseableKeyRawScore float,
+		sha1AfterSunsetNormalizedScore float,
+		sha1AfterSunsetRawScore float,
+		malformedValidityDatesNormalizedScore float,
+		malformedValidityDatesRawScore float,
+		keyUsageMismatchNormalizedScore float,
+		keyUsageMismatchRawScore float,
+		insufficientSCTsNormalizedScore float,
+		insufficientSCTsRawScore float,
+		emptyOrganizationNormalizedScore float,
+		emptyOrganizationRawScore float,
+		brokenSignatureAlgorithmNormalizedScore float,
+		brokenSignatureAlgorithmRawScore float,
+		algorithmMismatchNormalizedScore float,
+		algorithmMismatchRawScore float,
+		ipAsDNSNameNormalizedScore float,
+		ipAsDNSNameRawScore float,
 		normalizedScore float,
 		rawScore float,
 		normalizedCount integer,
 		rawCount integer,
+		registrableDomains integer,
 		beginTime bigint);
 	drop table if exists examples;
 	create table examples(
 		issuer text,
-		validPeriodTooLongExample text,
-		validPeriodTooLongLastSeen bigint,
-		deprecatedVersionExample text,
-		deprecatedVersionLastSeen bigint,
-		deprecatedSignatureAlgorithmExample text,
-		deprecatedSignatureAlgorithmLastSeen bigint,
-		missingCNinSANExample text,
-		missingCNinSANLastSeen bigint,
-		keyTooShortExample text,
-		keyTooShortLastSeen bigint,
-		expTooSmallExample text,
-		expTooSmallLastSeen bigint);
-	`
-
-	_, err = db.Exec(createTables)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create table: %s\n", err)
-		os.Exit(1)
-	}
-
-	tx, err := db.Begin()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to begin using DB: %s\n", err)
-		os.Exit(1)
-	}
+		violation text,
+		certPem text,
+		lastSeen bigint);
+	drop table if exists issuerRanking;
+	create table issuerRanking(
+		rank integer,
+		issuer text,
+		issuerSha256Fingerprint text,
+		beginTime bigint,
+		rankScore float,
+		normalizedScore float,
+		normalizedCount integer);
+	drop table if exists runMetadata;
+	create table runMetadata(
+		startTime datetime,
+		endTime datetime,
+		sourceFiles text,
+		toolVersion text,
+		maxEntries bigint,
+		ruleConfig text,
+		sampleRate float,
+		sampleSeed bigint,
+		afterIndex bigint,
+		beforeIndex bigint);
+	drop table if exists validityHistogram;
+	create table validityHistogram(
+		bucket text,
+		count integer);
+`
 
-	insertEntry := `
+const insertEntry = `
 	insert into baselineRequirements(
 		cn, issuer, sha256Fingerprint, notBefore,
 		notAfter, validPeriodTooLong,
@@ -138,19 +438,57 @@ func main() {
 		keyTooShort, keySize, expTooSmall, exp,
 		signatureAlgorithm, version, dnsNames,
 		ipAddresses, maxReputation,
-		issuerInMozillaDB, timestamp)
-		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	insertEntryStatement, err := tx.Prepare(insertEntry)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create prepared statement: %s\n", err)
-		os.Exit(1)
-	}
-	defer insertEntryStatement.Close()
+		issuerInMozillaDB, timestamp, futureNotBefore,
+		logIndex,
+		weakRSAModulus,
+		sctSignatureInvalid,
+		embeddedSCTCount,
+		rawDer,
+		certPem,
+		rootPrograms,
+		precert,
+		subjectKeyId,
+		authorityKeyId,
+		validationLevel,
+		noSANExtension,
+		unknownSignatureAlgorithm,
+		poisonOnFinalCert,
+		keyIdentifierMismatch,
+		leafOutlivesIssuer,
+		illegalDNSCharacter,
+		mixedWildcardAndIP,
+		emptySubjectNoSAN,
+		pathLenExceeded,
+		publicSuffixSAN,
+		extensionsBeforeV3,
+		missingAKI,
+		multipleCN,
+		missingCertPolicy,
+		sha1InChain,
+		reservedTLD,
+		lateLogging,
+		nonstandardRSAExponent,
+		missingOCSP,
+		missingCRL,
+		nameConstraintViolation,
+		misplacedWildcard,
+		forbiddenSANType,
+		unparseableKey,
+		sha1AfterSunset,
+		malformedValidityDates,
+		keyUsageMismatch,
+		insufficientSCTs,
+		emptyOrganization,
+		brokenSignatureAlgorithm,
+		algorithmMismatch,
+		ipAsDNSName)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
 
-	insertIssuer := `
-	 insert into issuerReputation(
+const insertIssuer = `
+	insert into issuerReputation(
 		issuer,
+		issuerSha256Fingerprint,
 		issuerInMozillaDB,
 		validPeriodTooLongNormalizedScore, validPeriodTooLongRawScore,
 		deprecatedVersionNormalizedScore, deprecatedVersionRawScore,
@@ -159,228 +497,1197 @@ func main() {
 		missingCNinSANNormalizedScore, missingCNinSANRawScore,
 		keyTooShortNormalizedScore, keyTooShortRawScore,
 		expTooSmallNormalizedScore, expTooSmallRawScore,
+		futureNotBeforeNormalizedScore, futureNotBeforeRawScore,
+		weakRSAModulusNormalizedScore, weakRSAModulusRawScore,
+		sctSignatureInvalidNormalizedScore, sctSignatureInvalidRawScore,
+		noSANExtensionNormalizedScore, noSANExtensionRawScore,
+		unknownSignatureAlgorithmNormalizedScore, unknownSignatureAlgorithmRawScore,
+		poisonOnFinalCertNormalizedScore, poisonOnFinalCertRawScore,
+		keyIdentifierMismatchNormalizedScore, keyIdentifierMismatchRawScore,
+		leafOutlivesIssuerNormalizedScore, leafOutlivesIssuerRawScore,
+		illegalDNSCharacterNormalizedScore, illegalDNSCharacterRawScore,
+		mixedWildcardAndIPNormalizedScore, mixedWildcardAndIPRawScore,
+		emptySubjectNoSANNormalizedScore, emptySubjectNoSANRawScore,
+		pathLenExceededNormalizedScore, pathLenExceededRawScore,
+		publicSuffixSANNormalizedScore, publicSuffixSANRawScore,
+		extensionsBeforeV3NormalizedScore, extensionsBeforeV3RawScore,
+		missingAKINormalizedScore, missingAKIRawScore,
+		multipleCNNormalizedScore, multipleCNRawScore,
+		missingCertPolicyNormalizedScore, missingCertPolicyRawScore,
+		sha1InChainNormalizedScore, sha1InChainRawScore,
+		reservedTLDNormalizedScore, reservedTLDRawScore,
+		lateLoggingNormalizedScore, lateLoggingRawScore,
+		nonstandardRSAExponentNormalizedScore, nonstandardRSAExponentRawScore,
+		missingOCSPNormalizedScore, missingOCSPRawScore,
+		missingCRLNormalizedScore, missingCRLRawScore,
+		nameConstraintViolationNormalizedScore, nameConstraintViolationRawScore,
+		misplacedWildcardNormalizedScore, misplacedWildcardRawScore,
+		forbiddenSANTypeNormalizedScore, forbiddenSANTypeRawScore,
+		unparseableKeyNormalizedScore, unparseableKeyRawScore,
+		sha1AfterSunsetNormalizedScore, sha1AfterSunsetRawScore,
+		malformedValidityDatesNormalizedScore, malformedValidityDatesRawScore,
+		keyUsageMismatchNormalizedScore, keyUsageMismatchRawScore,
+		insufficientSCTsNormalizedScore, insufficientSCTsRawScore,
+		emptyOrganizationNormalizedScore, emptyOrganizationRawScore,
+		brokenSignatureAlgorithmNormalizedScore, brokenSignatureAlgorithmRawScore,
+		algorithmMismatchNormalizedScore, algorithmMismatchRawScore,
+		ipAsDNSNameNormalizedScore, ipAsDNSNameRawScore,
 		normalizedScore, rawScore,
-		normalizedCount, rawCount, beginTime)
-	values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	insertIssuerStatement, err := tx.Prepare(insertIssuer)
+		normalizedCount, rawCount, registrableDomains, beginTime)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+const insertRank = `
+	insert into issuerRanking(
+		rank, issuer, issuerSha256Fingerprint, beginTime,
+		rankScore, normalizedScore, normalizedCount)
+		values(?, ?, ?, ?, ?, ?, ?)
+`
+
+const insertExample = `
+	insert into examples(issuer, violation, certPem, lastSeen)
+		values(?, ?, ?, ?)
+`
+
+// Records a violating cert's summary in baselineRequirements using the
+// prepared insertEntry statement. Its PEM is only stored if storePEM is true,
+// and is otherwise null.
+func insertSummary(insertEntryStatement *sql.Stmt, summary *CertSummary,
+	cert *x509.Certificate, storePEM bool) error {
+	dnsNamesAsString, err := json.Marshal(summary.DnsNames)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create prepared statement: %s\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to convert to JSON: %s", err)
 	}
-	defer insertIssuerStatement.Close()
+	ipAddressesAsString, err := json.Marshal(summary.IpAddresses)
+	if err != nil {
+		return fmt.Errorf("failed to convert to JSON: %s", err)
+	}
+	rootProgramsAsString, err := json.Marshal(summary.RootPrograms)
+	if err != nil {
+		return fmt.Errorf("failed to convert to JSON: %s", err)
+	}
+	certPem := sql.NullString{Valid: storePEM}
+	if storePEM {
+		certPem.String = CertToPEM(cert)
+	}
+	_, err = insertEntryStatement.Exec(summary.CN, summary.Issuer,
+		summary.Sha256Fingerprint,
+		cert.NotBefore, cert.NotAfter,
+		summary.Violations[VALID_PERIOD_TOO_LONG],
+		summary.Violations[DEPRECATED_SIGNATURE_ALGORITHM],
+		summary.Violations[DEPRECATED_VERSION],
+		summary.Violations[MISSING_CN_IN_SAN],
+		summary.Violations[KEY_TOO_SHORT], summary.KeySize,
+		summary.Violations[EXP_TOO_SMALL], summary.Exp,
+		summary.SignatureAlgorithm,
+		summary.Version, dnsNamesAsString,
+		ipAddressesAsString,
+		summary.MaxReputation,
+		summary.IssuerInMozillaDB,
+		summary.Timestamp,
+		summary.Violations[FUTURE_NOT_BEFORE],
+		summary.LogIndex,
+		summary.Violations[WEAK_RSA_MODULUS],
+		summary.Violations[SCT_SIGNATURE_INVALID],
+		summary.EmbeddedSCTCount,
+		cert.Raw,
+		certPem,
+		rootProgramsAsString,
+		summary.Precert,
+		summary.SubjectKeyId,
+		summary.AuthorityKeyId,
+		summary.ValidationLevel,
+		summary.Violations[NO_SAN_EXTENSION],
+		summary.Violations[UNKNOWN_SIGNATURE_ALGORITHM],
+		summary.Violations[POISON_ON_FINAL_CERT],
+		summary.Violations[KEY_IDENTIFIER_MISMATCH],
+		summary.Violations[LEAF_OUTLIVES_ISSUER],
+		summary.Violations[ILLEGAL_DNS_CHARACTER],
+		summary.Violations[MIXED_WILDCARD_AND_IP],
+		summary.Violations[EMPTY_SUBJECT_NO_SAN],
+		summary.Violations[PATHLEN_EXCEEDED],
+		summary.Violations[PUBLIC_SUFFIX_SAN],
+		summary.Violations[EXTENSIONS_BEFORE_V3],
+		summary.Violations[MISSING_AKI],
+		summary.Violations[MULTIPLE_CN],
+		summary.Violations[MISSING_CERT_POLICY],
+		summary.Violations[SHA1_IN_CHAIN],
+		summary.Violations[RESERVED_TLD],
+		summary.Violations[LATE_LOGGING],
+		summary.Violations[NONSTANDARD_RSA_EXPONENT],
+		summary.Violations[MISSING_OCSP],
+		summary.Violations[MISSING_CRL],
+		summary.Violations[NAME_CONSTRAINT_VIOLATION],
+		summary.Violations[MISPLACED_WILDCARD],
+		summary.Violations[FORBIDDEN_SAN_TYPE],
+		summary.Violations[UNPARSEABLE_KEY],
+		summary.Violations[SHA1_AFTER_SUNSET],
+		summary.Violations[MALFORMED_VALIDITY_DATES],
+		summary.Violations[KEY_USAGE_MISMATCH],
+		summary.Violations[INSUFFICIENT_SCTS],
+		summary.Violations[EMPTY_ORGANIZATION],
+		summary.Violations[BROKEN_SIGNATURE_ALGORITHM],
+		summary.Violations[ALGORITHM_MISMATCH],
+		summary.Violations[IP_AS_DNS_NAME])
+	if err != nil {
+		return fmt.Errorf("failed to insert entry: %s", err)
+	}
+	return nil
+}
 
-	insertExample := `
-		insert into examples(
-			issuer,
-			validPeriodTooLongExample,
-			validPeriodTooLongLastSeen,
-			deprecatedVersionExample,
-			deprecatedVersionLastSeen,
-			deprecatedSignatureAlgorithmExample,
-			deprecatedSignatureAlgorithmLastSeen,
-			missingCNinSANExample,
-			missingCNinSANLastSeen,
-			keyTooShortExample,
-			keyTooShortLastSeen,
-			expTooSmallExample,
-			expTooSmallLastSeen)
-		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	insertExampleStatement, err := tx.Prepare(insertExample)
+// A Sink recording violating certs in baselineRequirements with the prepared
+// insertEntry statement. Closing it leaves the statement open.
+type sqlSink struct {
+	insertEntryStatement *sql.Stmt
+	storePEM             bool
+}
+
+func (s sqlSink) Write(summary *CertSummary, cert *x509.Certificate) error {
+	return insertSummary(s.insertEntryStatement, summary, cert, s.storePEM)
+}
+
+func (s sqlSink) Close() error { return nil }
+
+// Records a finished issuer reputation in issuerReputation using the prepared
+// insertIssuer statement.
+func insertIssuerReputation(insertIssuerStatement *sql.Stmt,
+	issuer *IssuerReputation) error {
+	_, err := insertIssuerStatement.Exec(issuer.Issuer,
+		issuer.IssuerSha256Fingerprint,
+		issuer.IssuerInMozillaDB,
+		issuer.Score(VALID_PERIOD_TOO_LONG).NormalizedScore,
+		issuer.Score(VALID_PERIOD_TOO_LONG).RawScore,
+		issuer.Score(DEPRECATED_VERSION).NormalizedScore,
+		issuer.Score(DEPRECATED_VERSION).RawScore,
+		issuer.Score(DEPRECATED_SIGNATURE_ALGORITHM).NormalizedScore,
+		issuer.Score(DEPRECATED_SIGNATURE_ALGORITHM).RawScore,
+		issuer.Score(MISSING_CN_IN_SAN).NormalizedScore,
+		issuer.Score(MISSING_CN_IN_SAN).RawScore,
+		issuer.Score(KEY_TOO_SHORT).NormalizedScore,
+		issuer.Score(KEY_TOO_SHORT).RawScore,
+		issuer.Score(EXP_TOO_SMALL).NormalizedScore,
+		issuer.Score(EXP_TOO_SMALL).RawScore,
+		issuer.Score(FUTURE_NOT_BEFORE).NormalizedScore,
+		issuer.Score(FUTURE_NOT_BEFORE).RawScore,
+		issuer.Score(WEAK_RSA_MODULUS).NormalizedScore,
+		issuer.Score(WEAK_RSA_MODULUS).RawScore,
+		issuer.Score(SCT_SIGNATURE_INVALID).NormalizedScore,
+		issuer.Score(SCT_SIGNATURE_INVALID).RawScore,
+		issuer.Score(NO_SAN_EXTENSION).NormalizedScore,
+		issuer.Score(NO_SAN_EXTENSION).RawScore,
+		issuer.Score(UNKNOWN_SIGNATURE_ALGORITHM).NormalizedScore,
+		issuer.Score(UNKNOWN_SIGNATURE_ALGORITHM).RawScore,
+		issuer.Score(POISON_ON_FINAL_CERT).NormalizedScore,
+		issuer.Score(POISON_ON_FINAL_CERT).RawScore,
+		issuer.Score(KEY_IDENTIFIER_MISMATCH).NormalizedScore,
+		issuer.Score(KEY_IDENTIFIER_MISMATCH).RawScore,
+		issuer.Score(LEAF_OUTLIVES_ISSUER).NormalizedScore,
+		issuer.Score(LEAF_OUTLIVES_ISSUER).RawScore,
+		issuer.Score(ILLEGAL_DNS_CHARACTER).NormalizedScore,
+		issuer.Score(ILLEGAL_DNS_CHARACTER).RawScore,
+		issuer.Score(MIXED_WILDCARD_AND_IP).NormalizedScore,
+		issuer.Score(MIXED_WILDCARD_AND_IP).RawScore,
+		issuer.Score(EMPTY_SUBJECT_NO_SAN).NormalizedScore,
+		issuer.Score(EMPTY_SUBJECT_NO_SAN).RawScore,
+		issuer.Score(PATHLEN_EXCEEDED).NormalizedScore,
+		issuer.Score(PATHLEN_EXCEEDED).RawScore,
+		issuer.Score(PUBLIC_SUFFIX_SAN).NormalizedScore,
+		issuer.Score(PUBLIC_SUFFIX_SAN).RawScore,
+		issuer.Score(EXTENSIONS_BEFORE_V3).NormalizedScore,
+		issuer.Score(EXTENSIONS_BEFORE_V3).RawScore,
+		issuer.Score(MISSING_AKI).NormalizedScore,
+		issuer.Score(MISSING_AKI).RawScore,
+		issuer.Score(MULTIPLE_CN).NormalizedScore,
+		issuer.Score(MULTIPLE_CN).RawScore,
+		issuer.Score(MISSING_CERT_POLICY).NormalizedScore,
+		issuer.Score(MISSING_CERT_POLICY).RawScore,
+		issuer.Score(SHA1_IN_CHAIN).NormalizedScore,
+		issuer.Score(SHA1_IN_CHAIN).RawScore,
+		issuer.Score(RESERVED_TLD).NormalizedScore,
+		issuer.Score(RESERVED_TLD).RawScore,
+		issuer.Score(LATE_LOGGING).NormalizedScore,
+		issuer.Score(LATE_LOGGING).RawScore,
+		issuer.Score(NONSTANDARD_RSA_EXPONENT).NormalizedScore,
+		issuer.Score(NONSTANDARD_RSA_EXPONENT).RawScore,
+		issuer.Score(MISSING_OCSP).NormalizedScore,
+		issuer.Score(MISSING_OCSP).RawScore,
+		issuer.Score(MISSING_CRL).NormalizedScore,
+		issuer.Score(MISSING_CRL).RawScore,
+		issuer.Score(NAME_CONSTRAINT_VIOLATION).NormalizedScore,
+		issuer.Score(NAME_CONSTRAINT_VIOLATION).RawScore,
+		issuer.Score(MISPLACED_WILDCARD).NormalizedScore,
+		issuer.Score(MISPLACED_WILDCARD).RawScore,
+		issuer.Score(FORBIDDEN_SAN_TYPE).NormalizedScore,
+		issuer.Score(FORBIDDEN_SAN_TYPE).RawScore,
+		issuer.Score(UNPARSEABLE_KEY).NormalizedScore,
+		issuer.Score(UNPARSEABLE_KEY).RawScore,
+		issuer.Score(SHA1_AFTER_SUNSET).NormalizedScore,
+		issuer.Score(SHA1_AFTER_SUNSET).RawScore,
+		issuer.Score(MALFORMED_VALIDITY_DATES).NormalizedScore,
+		issuer.Score(MALFORMED_VALIDITY_DATES).RawScore,
+		issuer.Score(KEY_USAGE_MISMATCH).NormalizedScore,
+		issuer.Score(KEY_USAGE_MISMATCH).RawScore,
+		issuer.Score(INSUFFICIENT_SCTS).NormalizedScore,
+		issuer.Score(INSUFFICIENT_SCTS).RawScore,
+		issuer.Score(EMPTY_ORGANIZATION).NormalizedScore,
+		issuer.Score(EMPTY_ORGANIZATION).RawScore,
+		issuer.Score(BROKEN_SIGNATURE_ALGORITHM).NormalizedScore,
+		issuer.Score(BROKEN_SIGNATURE_ALGORITHM).RawScore,
+		issuer.Score(ALGORITHM_MISMATCH).NormalizedScore,
+		issuer.Score(ALGORITHM_MISMATCH).RawScore,
+		issuer.Score(IP_AS_DNS_NAME).NormalizedScore,
+		issuer.Score(IP_AS_DNS_NAME).RawScore,
+		issuer.NormalizedScore,
+		issuer.RawScore,
+		issuer.NormalizedCount,
+		issuer.RawCount,
+		issuer.RegistrableDomains,
+		issuer.BeginTime)
+	return err
+}
+
+// Records an issuer's example cert for each violation, one row per violation,
+// using the prepared insertExample statement.
+func insertExamples(insertExampleStatement *sql.Stmt, issuer string,
+	examples map[string]*x509.Certificate, lastSeen map[string]uint64) error {
+	violations := make([]string, 0, len(examples))
+	for violation := range examples {
+		violations = append(violations, violation)
+	}
+	sort.Strings(violations)
+	for _, violation := range violations {
+		_, err := insertExampleStatement.Exec(issuer, violation,
+			CertToPEM(examples[violation]), lastSeen[violation])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// What a run was over and how, so that old DBs aren't ambiguous.
+type runMetadata struct {
+	StartTime   time.Time
+	EndTime     time.Time
+	SourceFiles []string
+	MaxEntries  uint64
+	Config      *RuleConfig
+	// Scores are only over a sample of the entries if SampleRate is below 1.
+	SampleRate float64
+	SampleSeed int64
+	// The range of entry indices processed, as for Analyzer.
+	AfterIndex  uint64
+	BeforeIndex uint64
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertRunMetadata(db execer, metadata runMetadata) error {
+	sourceFiles, err := json.Marshal(metadata.SourceFiles)
+	if err != nil {
+		return err
+	}
+	config, err := json.Marshal(metadata.Config)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create prepared statement: %s\n", err)
+		return err
+	}
+	_, err = db.Exec(`insert into runMetadata(startTime, endTime, sourceFiles,
+		toolVersion, maxEntries, ruleConfig, sampleRate, sampleSeed, afterIndex,
+		beforeIndex)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		metadata.StartTime, metadata.EndTime, string(sourceFiles), toolVersion,
+		metadata.MaxEntries, string(config), metadata.SampleRate,
+		metadata.SampleSeed, metadata.AfterIndex, metadata.BeforeIndex)
+	return err
+}
+
+// Loads the domain rankings in filename, which is in the given format.
+func loadRanker(filename string, format string) (Ranker, error) {
+	if format == RANK_FORMAT_ALEXA {
+		ranker := new(alexa.AlexaRank)
+		ranker.Init(filename)
+		return ranker, nil
+	}
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return ReadRankList(in, format)
+}
+
+// Writes finished issuer reputations to the file name (or stdout if it's "-"),
+// as WriteIssuerJSON does.
+func writeIssuerJSON(name string, issuers []*IssuerReputation) error {
+	out, err := openJSONOutput(name)
+	if err != nil {
+		return err
+	}
+	if err := WriteIssuerJSON(out, issuers); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Writes v as JSON to the file name, or stdout if it's "-".
+func writeJSONFile(name string, v interface{}) error {
+	out, err := openJSONOutput(name)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(out).Encode(v); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Where an interrupted run stopped: the log file it was processing, the index
+// of the first entry in it that wasn't processed, and the log files it didn't
+// get to.
+type checkpoint struct {
+	LogFile     string
+	ResumeIndex uint64
+	Remaining   []string
+}
+
+func writeCheckpoint(filename string, c checkpoint) error {
+	marshalled, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, marshalled, 0644)
+}
+
+// Fetches entries from the CT log at ct_url for analyzer to process, once up
+// to the log's current size or, with poll_interval, until ctx is done.
+func followLog(ctx context.Context, logger *Logger, analyzer *Analyzer) {
+	monitor := &LogMonitor{Client: NewLogClient(ctURL), Next: afterIndex,
+		Log: logger}
+	if pollStateFile != "" {
+		next, err := readPollState(pollStateFile)
+		if err == nil {
+			monitor.Next = next
+		} else if !os.IsNotExist(err) {
+			logger.Errorf("Failed to read poll state %s: %s", pollStateFile, err)
+			os.Exit(1)
+		}
+	}
+	savePollState := func(next uint64) {
+		if pollStateFile == "" {
+			return
+		}
+		if err := writePollState(pollStateFile, next); err != nil {
+			logger.Errorf("Failed to write poll state %s: %s", pollStateFile, err)
+		}
+	}
+	logger.Infof("Fetching entries from %s from index %d", ctURL, monitor.Next)
+	if pollInterval > 0 {
+		monitor.Poll(ctx, pollInterval, analyzer.ProcessEntry, savePollState)
+		return
+	}
+	if _, err := monitor.PollOnce(ctx, analyzer.ProcessEntry); err != nil {
+		logger.Errorf("Failed to fetch entries from %s: %s", ctURL, err)
+	}
+	savePollState(monitor.Next)
+}
+
+// What's recorded in poll_state_file.
+type pollState struct {
+	Next uint64
+}
+
+func readPollState(filename string) (uint64, error) {
+	marshalled, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	var state pollState
+	if err := json.Unmarshal(marshalled, &state); err != nil {
+		return 0, err
+	}
+	return state.Next, nil
+}
+
+func writePollState(filename string, next uint64) error {
+	marshalled, err := json.Marshal(pollState{next})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, marshalled, 0644)
+}
+
+// Returns a logger writing to out at the named level, or only logging errors
+// if quiet is set.
+func newLogger(out io.Writer, levelName string, quiet bool) (*Logger, error) {
+	level, err := ParseLogLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+	if quiet {
+		level = LOG_ERROR
+	}
+	return NewLogger(out, level), nil
+}
+
+// Returns the config for the rule flags: the checks and weights lists, as
+// for ParseChecks and ParseWeights (or "default" for the default weights),
+// and the file of CT log keys or JSON log list, if any.
+func newRuleConfig(checkList string, weightList string, shortKeyBits int,
+	strictCNInSAN bool, sha1Cutoff string, ctLogKeysFile string) (*RuleConfig, error) {
+	config := &RuleConfig{
+		ShortKeyBits:  shortKeyBits,
+		StrictCNInSAN: strictCNInSAN,
+	}
+	var err error
+	if sha1Cutoff != "" {
+		config.SHA1Cutoff, err = time.Parse("2006-01-02", sha1Cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sha1_cutoff: %s", err)
+		}
+	}
+	if checkList != "" {
+		config.Checks, err = ParseChecks(checkList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checks: %s", err)
+		}
+	}
+	if weightList == "default" {
+		config.Weights = DefaultViolationWeights
+	} else if weightList != "" {
+		config.Weights, err = ParseWeights(weightList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weights: %s", err)
+		}
+	}
+	if strings.HasSuffix(ctLogKeysFile, ".json") {
+		config.CTLogKeys, config.CTLogOperators, err = readCTLogList(ctLogKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CT log list from %s: %s",
+				ctLogKeysFile, err)
+		}
+	} else if ctLogKeysFile != "" {
+		config.CTLogKeys, err = ReadCTLogKeys(ctLogKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CT log keys from %s: %s",
+				ctLogKeysFile, err)
+		}
+	}
+	return config, nil
+}
+
+func readCTLogList(filename string) (map[[32]byte]crypto.PublicKey,
+	map[[32]byte]string, error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer in.Close()
+	return ReadCTLogList(in)
+}
+
+// The values of a flag that may be given more than once.
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Reads the root CA maps of the root programs given as name=file, along with
+// Mozilla's, for Analyzer.RootPrograms.
+func readRootPrograms(mozilla map[string]bool,
+	files []string) (map[string]map[string]bool, error) {
+	programs := map[string]map[string]bool{"Mozilla": mozilla}
+	for _, value := range files {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected name=file, got %q", value)
+		}
+		if programs[parts[0]] != nil {
+			return nil, fmt.Errorf("root program %s given twice", parts[0])
+		}
+		programs[parts[0]] = ReadRootCAMap(parts[1])
+	}
+	return programs, nil
+}
+
+// Loads the issuer reputations saved in filename into analyzer. It's not an
+// error for the file not to exist, since there's nothing to load on the first
+// run.
+func loadIssuers(analyzer *Analyzer, filename string) error {
+	in, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return analyzer.LoadIssuers(in)
+}
+
+// Returns finished copies of the analyzer's issuer reputations once
+// processing is done, and has server, if there is one, serve them from then
+// on. The API may be snapshotting the originals until then, so they're left
+// unfinished rather than changed under it.
+func finishIssuers(analyzer *Analyzer, server *api) []*IssuerReputation {
+	finished := analyzer.IssuerSnapshot()
+	if server != nil {
+		server.finish(finished)
+	}
+	return finished
+}
+
+// Saves analyzer's issuer reputations to filename, replacing it only once
+// they're all written so that a failed save doesn't lose the previous runs'.
+func saveIssuers(analyzer *Analyzer, filename string) error {
+	out, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	err = analyzer.SaveIssuers(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), filename)
+}
+
+// Reads a PEM or DER file of certs into an entry, with the first cert as the
+// leaf and the rest as its chain. The certs weren't read from a log, so the
+// entry's timestamp is 0, which skips the checks against when a cert was
+// logged.
+func certFileEntry(name string) (*certificatetransparency.EntryAndPosition, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certs in %s", name)
+	}
+	entry := &certificatetransparency.Entry{
+		Type:     certificatetransparency.X509Entry,
+		X509Cert: certs[0].Raw,
+	}
+	for _, cert := range certs[1:] {
+		entry.ExtraCerts = append(entry.ExtraCerts, cert.Raw)
+	}
+	return &certificatetransparency.EntryAndPosition{Entry: entry}, nil
+}
+
+// Returns the paths of the regular files in dir whose names match pattern,
+// sorted by name so that runs over the same directory are reproducible.
+func listLogFiles(dir string, pattern string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		matched, err := filepath.Match(pattern, info.Name())
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	return files, nil
+}
+
+func main() {
+	command, args := findCommand(os.Args[1:])
+	os.Exit(command.run(args))
+}
+
+// Parses the analyze subcommand's args into the flag variables.
+func parseAnalyzeArgs(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if flag.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %q", flag.Args())
+	}
+	return nil
+}
+
+// Runs the analyze subcommand, returning the status to exit with once the
+// outputs are closed. Errors still exit right away with status 1.
+func runAnalyze(args []string) int {
+	if err := parseAnalyzeArgs(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
 		os.Exit(1)
 	}
-	defer insertExampleStatement.Close()
+	logger, err := newLogger(os.Stderr, logLevelName, quiet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log_level: %s\n", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if singleThreaded {
+		runtime.GOMAXPROCS(1)
+	}
+	if rankCountWeight < 0 || rankCountWeight > 1 {
+		logger.Errorf("rank_count_weight must be in [0, 1]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if ctVersion != 1 && ctVersion != 2 {
+		logger.Errorf("ct_version must be 1 or 2")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if workers < 0 {
+		logger.Errorf("workers must not be negative")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if sampleRate <= 0 || sampleRate > 1 {
+		logger.Errorf("sample_rate must be in (0, 1]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if beforeIndex != 0 && beforeIndex <= afterIndex {
+		logger.Errorf("before_index must be after after_index")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	var cutoff time.Time
+	if notBeforeCutoff != "" {
+		cutoff, err = time.Parse("2006-01-02", notBeforeCutoff)
+		if err != nil {
+			logger.Errorf("Invalid not_before_cutoff: %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	var expiryReference time.Time
+	if excludeExpiredAt != "" {
+		expiryReference, err = time.Parse("2006-01-02", excludeExpiredAt)
+		if err != nil {
+			logger.Errorf("Invalid exclude_expired_at: %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	var excludedIssuers []string
+	if excludeIssuersFile != "" {
+		excludeIn, err := os.Open(excludeIssuersFile)
+		if err != nil {
+			logger.Errorf("Failed to open exclude_issuers_file %s: %s",
+				excludeIssuersFile, err)
+			os.Exit(1)
+		}
+		excludedIssuers, err = ReadExcludedIssuers(excludeIn)
+		excludeIn.Close()
+		if err != nil {
+			logger.Errorf("Failed to read exclude_issuers_file %s: %s",
+				excludeIssuersFile, err)
+			os.Exit(1)
+		}
+	}
+	if offendersThreshold < 0 || offendersThreshold > 1 {
+		logger.Errorf("offenders_threshold must be in [0, 1]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	var offendersCheckSet map[string]bool
+	if offendersChecks != "" {
+		offendersCheckSet, err = ParseChecks(offendersChecks)
+		if err != nil {
+			logger.Errorf("Invalid offenders_checks: %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	var gate *violationGate
+	if failOn != "" {
+		gate, err = newViolationGate(failOn)
+		if err != nil {
+			logger.Errorf("Invalid fail_on: %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	config, err := newRuleConfig(checkList, weightList, shortKeyBits,
+		strictCNInSAN, sha1Cutoff, ctLogKeysFile)
+	if err != nil {
+		logger.Errorf("%s", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	config.RecordSANReputations = sanReputations
+
+	if reanalyzeDB {
+		return reanalyzeFile(logger, dbFile, config)
+	}
 
-	fmt.Fprintf(os.Stderr, "Starting %s\n", time.Now())
-	in, err := os.Open(ctLog)
+	db, err := sql.Open("sqlite3", dbFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open entries file: %s\n", err)
+		logger.Errorf("Failed to open %s: %s", dbFile, err)
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
-	defer in.Close()
+	defer db.Close()
 
-	entriesFile := certificatetransparency.EntriesFile{in}
-	fmt.Fprintf(os.Stderr, "Initialized entries %s\n", time.Now())
-	out, err := os.OpenFile(jsonFile, os.O_RDWR|os.O_CREATE, 0666)
+	ranker, err := loadRanker(alexaFile, rankFormat)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open JSON output file %s: %s\n",
-			jsonFile, err)
+		logger.Errorf("Failed to load rankings from %s: %s", alexaFile, err)
 		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	_, err = db.Exec(createTables)
+	if err != nil {
+		logger.Errorf("Failed to create table: %s", err)
+		os.Exit(1)
 	}
 
-	fmt.Fprintf(out, "{\"Certs\":[")
-	firstOutLock := new(sync.Mutex)
-	firstOut := true
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Errorf("Failed to begin using DB: %s", err)
+		os.Exit(1)
+	}
 
-	rootCAMap := ReadRootCAMap(rootCAFile)
+	insertEntryStatement, err := tx.Prepare(insertEntry)
+	if err != nil {
+		logger.Errorf("Failed to create prepared statement: %s", err)
+		os.Exit(1)
+	}
+	defer insertEntryStatement.Close()
+
+	insertIssuerStatement, err := tx.Prepare(insertIssuer)
+	if err != nil {
+		logger.Errorf("Failed to create prepared statement: %s", err)
+		os.Exit(1)
+	}
+	defer insertIssuerStatement.Close()
 
-	issuersLock := new(sync.Mutex)
-	issuers := make(map[string]*IssuerReputation)
+	insertExampleStatement, err := tx.Prepare(insertExample)
+	if err != nil {
+		logger.Errorf("Failed to create prepared statement: %s", err)
+		os.Exit(1)
+	}
+	defer insertExampleStatement.Close()
 
-	exampleMapLock := new(sync.Mutex)
-	exampleMap := make(map[string]map[string]*x509.Certificate)
-	exampleMapLastSeen := make(map[string]map[string]uint64)
+	insertRankStatement, err := tx.Prepare(insertRank)
+	if err != nil {
+		logger.Errorf("Failed to create prepared statement: %s", err)
+		os.Exit(1)
+	}
+	defer insertRankStatement.Close()
 
-	entriesFile.Map(func(ent *certificatetransparency.EntryAndPosition, err error) {
+	startTime := time.Now()
+	logger.Infof("Starting")
+	logFiles := []string{ctLog}
+	sources := logFiles
+	if ctURL != "" {
+		logFiles = nil
+		sources = []string{ctURL}
+	} else if ctLogDir != "" {
+		logFiles, err = listLogFiles(ctLogDir, ctLogGlob)
 		if err != nil {
-			return
+			logger.Errorf("Failed to list entries files in %s: %s",
+				ctLogDir, err)
+			flag.PrintDefaults()
+			os.Exit(1)
 		}
+		sources = logFiles
+	}
+
+	out, err := openJSONOutput(jsonFile)
+	if err != nil {
+		logger.Errorf("Failed to open JSON output file %s: %s",
+			jsonFile, err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	defer out.Close()
+	out.fsync = fsyncOutput
+	outputs := []*bufferedOutput{out}
 
-		cert, err := x509.ParseCertificate(ent.Entry.X509Cert)
+	// Violating certs are written to each of these.
+	var m *metrics
+	sinks := []Sink{
+		SinkFunc(func(summary *CertSummary, cert *x509.Certificate) error {
+			m.recordViolations(summary)
+			return nil
+		}),
+		sqlSink{insertEntryStatement, storePEM},
+		NewJSONSink(out),
+	}
+	if ndjsonFile != "" {
+		ndjsonOut, err := openJSONOutput(ndjsonFile)
 		if err != nil {
-			return
+			logger.Errorf("Failed to open NDJSON output file %s: %s",
+				ndjsonFile, err)
+			os.Exit(1)
 		}
-
-		// Filter out certs issued before 2013 or that have already
-		// expired.
-		now := time.Now()
-		if cert.NotBefore.Before(time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)) ||
-			cert.NotAfter.Before(now) {
-			return
+		defer ndjsonOut.Close()
+		ndjsonOut.fsync = fsyncOutput
+		outputs = append(outputs, ndjsonOut)
+		sinks = append(sinks, NewNDJSONSink(ndjsonOut))
+	}
+	if csvFile != "" {
+		csvOut, err := os.Create(csvFile)
+		if err != nil {
+			logger.Errorf("Failed to open CSV output file %s: %s", csvFile, err)
+			os.Exit(1)
+		}
+		defer csvOut.Close()
+		csvSink, err := NewCSVSink(csvOut)
+		if err != nil {
+			logger.Errorf("Failed to write to CSV output file %s: %s", csvFile, err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, csvSink)
+	}
+	if parquetFile != "" {
+		parquetOut, err := os.Create(parquetFile)
+		if err != nil {
+			logger.Errorf("Failed to open Parquet output file %s: %s", parquetFile, err)
+			os.Exit(1)
 		}
+		defer parquetOut.Close()
+		sinks = append(sinks, newParquetSink(parquetOut))
+	}
+	var perIssuer *issuerFiles
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			logger.Errorf("Failed to create output directory %s: %s", outputDir, err)
+			os.Exit(1)
+		}
+		perIssuer = newIssuerFiles()
+		sinks = append(sinks, SinkFunc(
+			func(summary *CertSummary, cert *x509.Certificate) error {
+				perIssuer.add(summary)
+				return nil
+			}))
+	}
+	if gate != nil {
+		sinks = append(sinks, gate)
+	}
+	var domains *DomainReport
+	if domainsFile != "" {
+		domains = NewDomainReport()
+		sinks = append(sinks, domains)
+	}
+	var recent *recentViolations
+	if apiAddr != "" {
+		recent = newRecentViolations(apiRecent)
+		sinks = append(sinks, recent)
+	}
+	sink := NewMultiSink(sinks...)
+	stopFlushing := flushPeriodically(flushInterval, outputs...)
+	defer stopFlushing()
 
-		certList := make([]*x509.Certificate, 0)
-		for _, certBytes := range ent.Entry.ExtraCerts {
-			nextCert, err := x509.ParseCertificate(certBytes)
+	rootCAMap := ReadRootCAMap(rootCAFile)
+	rootPrograms, err := readRootPrograms(rootCAMap, rootProgramFiles)
+	if err != nil {
+		logger.Errorf("Invalid root_program: %s", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	analyzer := NewAnalyzer(NewCachingRanker(ranker), rootCAMap, config, sink)
+	analyzer.Log = logger
+	analyzer.AcceptV2 = ctVersion == 2
+	analyzer.RootPrograms = rootPrograms
+	analyzer.SampleRate = sampleRate
+	analyzer.SampleSeed = sampleSeed
+	analyzer.MinIndex = afterIndex
+	analyzer.MaxIndex = beforeIndex
+	analyzer.TrackKeyReuse = keyReuseFile != ""
+	analyzer.NotBeforeCutoff = cutoff
+	analyzer.IncludeExpired = includeExpired
+	analyzer.ExpiryReference = expiryReference
+	analyzer.ExcludedIssuers = excludedIssuers
+	analyzer.MaxIssuers = maxIssuers
+	// Evicted issuer reputations are gone by the end, so their points in the
+	// time series are kept as they're evicted.
+	evictedPoints := make([]*IssuerMonthScore, 0)
+	analyzer.Evict = func(issuer *IssuerReputation,
+		examples map[string]*x509.Certificate, lastSeen map[string]uint64) {
+		issuer.FinishWeighted(config)
+		if timeSeriesFile != "" {
+			evictedPoints = append(evictedPoints,
+				IssuerTimeSeries([]*IssuerReputation{issuer})...)
+		}
+		if err := insertIssuerReputation(insertIssuerStatement, issuer); err != nil {
+			logger.Errorf("Failed to insert issuer %s: %s", issuer.Issuer, err)
+		}
+		if examples != nil {
+			err := insertExamples(insertExampleStatement, issuer.Issuer, examples,
+				lastSeen)
 			if err != nil {
-				continue
+				logger.Errorf("Failed to insert examples for issuer %s: %s",
+					issuer.Issuer, err)
 			}
-			certList = append(certList, nextCert)
 		}
+	}
+	if issuersFile != "" {
+		if err := loadIssuers(analyzer, issuersFile); err != nil {
+			logger.Errorf("Failed to load issuer reputations from %s: %s",
+				issuersFile, err)
+			os.Exit(1)
+		}
+	}
+	if metricsAddr != "" {
+		m = newMetrics(analyzer)
+		go func() {
+			err := http.ListenAndServe(metricsAddr, m.handler())
+			logger.Errorf("Metrics server on %s stopped: %s", metricsAddr, err)
+		}()
+	}
+	var server *api
+	if apiAddr != "" {
+		server = newAPI(analyzer, recent)
+		go func() {
+			err := http.ListenAndServe(apiAddr, server.handler())
+			logger.Errorf("API server on %s stopped: %s", apiAddr, err)
+		}()
+	}
+	if errorLogFile != "" {
+		errorLog, err := os.Create(errorLogFile)
+		if err != nil {
+			logger.Errorf("Failed to open error log %s: %s",
+				errorLogFile, err)
+			os.Exit(1)
+		}
+		defer errorLog.Close()
+		analyzer.ErrorLog = errorLog
+	}
+
+	// On SIGINT, stop processing entries but still record everything
+	// processed so far.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		logger.Warnf("Interrupted, finishing up")
+		signal.Stop(interrupts)
+		cancel()
+	}()
 
-		summary, err := CalculateCertSummary(cert, ent.Entry.Timestamp, &ranker, certList, rootCAMap)
+	if certFile != "" {
+		ent, err := certFileEntry(certFile)
 		if err != nil {
-			return
+			logger.Errorf("Failed to read certs from %s: %s", certFile, err)
+			os.Exit(1)
 		}
-		if summary == nil {
-			fmt.Fprintf(os.Stderr, "Couldn't allocate new cert summary\n")
+		if err := analyzer.ProcessUnlogged(ent); err != nil {
+			logger.Errorf("Failed to analyze %s: %s", certFile, err)
 			os.Exit(1)
 		}
-		certIssuerDN := DistinguishedNameToString(cert.Issuer)
-		key := fmt.Sprintf("%s:%d", certIssuerDN, TruncateMonth(ent.Entry.Timestamp))
-		issuersLock.Lock()
-		if issuers[key] == nil {
-			issuers[key] = NewIssuerReputation(cert.Issuer, ent.Entry.Timestamp)
+		logFiles = nil
+	}
+
+	// Issuer reputations and examples accumulate across all of the files.
+	var interruptedAt *checkpoint
+	for i, logFile := range logFiles {
+		if ctx.Err() != nil {
+			interruptedAt = &checkpoint{LogFile: logFile, Remaining: logFiles[i+1:]}
+			break
 		}
-		if issuers[key] == nil {
-			fmt.Fprintf(os.Stderr, "Couldn't allocate new issuer reputation\n")
+		in, err := os.Open(logFile)
+		if err != nil {
+			logger.Errorf("Failed to open entries file: %s", err)
+			flag.PrintDefaults()
 			os.Exit(1)
 		}
-		// Update issuer reputation whether or not the cert violates baseline
-		// requirements.
-		issuers[key].Update(summary)
-		issuersLock.Unlock()
-		if summary.ViolatesBR() {
-			dnsNamesAsString, err := json.Marshal(summary.DnsNames)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to convert to JSON: %s\n", err)
-				os.Exit(1)
-			}
-			ipAddressesAsString, err := json.Marshal(summary.IpAddresses)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to convert to JSON: %s\n", err)
-				os.Exit(1)
-			}
-			_, err = insertEntryStatement.Exec(summary.CN, summary.Issuer,
-				summary.Sha256Fingerprint,
-				cert.NotBefore, cert.NotAfter,
-				summary.Violations[VALID_PERIOD_TOO_LONG],
-				summary.Violations[DEPRECATED_SIGNATURE_ALGORITHM],
-				summary.Violations[DEPRECATED_VERSION],
-				summary.Violations[MISSING_CN_IN_SAN],
-				summary.Violations[KEY_TOO_SHORT], summary.KeySize,
-				summary.Violations[EXP_TOO_SMALL], summary.Exp,
-				summary.SignatureAlgorithm,
-				summary.Version, dnsNamesAsString,
-				ipAddressesAsString,
-				summary.MaxReputation,
-				summary.IssuerInMozillaDB,
-				summary.Timestamp)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to insert entry: %s\n", err)
-				os.Exit(1)
-			}
-			marshalled, err := json.Marshal(summary)
-			if err == nil {
-				separator := ",\n"
-				firstOutLock.Lock()
-				if firstOut {
-					separator = "\n"
-				}
-				fmt.Fprintf(out, "%s", separator)
-				out.Write(marshalled)
-				firstOut = false
-				firstOutLock.Unlock()
-			} else {
-				fmt.Fprintf(os.Stderr, "Couldn't write json: %s\n", err)
-				os.Exit(1)
-			}
-
-			exampleMapLock.Lock()
-			if exampleMap[certIssuerDN] == nil {
-				exampleMap[certIssuerDN] = make(map[string]*x509.Certificate)
-				exampleMapLastSeen[certIssuerDN] = make(map[string]uint64)
-			}
-			for violation, isViolation := range summary.Violations {
-				if isViolation {
-					exampleMap[certIssuerDN][violation] = cert
-					exampleMapLastSeen[certIssuerDN][violation] = ent.Entry.Timestamp
-				}
+		// Map reads the file as a stream, so only the entries being processed
+		// are in memory, however large the file is.
+		entriesFile := certificatetransparency.EntriesFile{in}
+		logger.Infof("Initialized entries %s", logFile)
+		if singleThreaded {
+			reorderer := NewEntryReorderer(0, analyzer.EntryCallback(ctx))
+			entriesFile.Map(reorderer.Add, maxEntries)
+			reorderer.Flush()
+			logger.Debugf("Held at most %d entries to put them in order",
+				reorderer.MaxPending)
+		} else {
+			entriesFile.Map(LimitWorkers(analyzer.EntryCallback(ctx), workers),
+				maxEntries)
+		}
+		in.Close()
+		if analyzer.Skipped > 0 {
+			interruptedAt = &checkpoint{
+				LogFile:     logFile,
+				ResumeIndex: analyzer.FirstSkipped,
+				Remaining:   logFiles[i+1:],
 			}
-			exampleMapLock.Unlock()
+			break
+		}
+	}
+	if ctURL != "" {
+		followLog(ctx, logger, analyzer)
+	}
+	if interruptedAt != nil {
+		if err := writeCheckpoint(checkpointFile, *interruptedAt); err != nil {
+			logger.Errorf("Failed to write checkpoint %s: %s", checkpointFile, err)
+		} else {
+			logger.Infof("Wrote checkpoint %s", checkpointFile)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		logger.Errorf("Failed to finish output: %s", err)
+	}
+	logger.Infof("Processed %d entries: %d summarized, "+
+		"%d skipped due to parse errors, %d filtered out, "+
+		"%d failed to be written, %d skipped after an interrupt, "+
+		"%d left out of the sample, %d from excluded issuers, "+
+		"%d outside the index range, "+
+		"%d too late for their evicted issuer reputations",
+		analyzer.Summarized+analyzer.ParseErrors+analyzer.Filtered+
+			analyzer.Skipped+analyzer.Unsampled+analyzer.Excluded+
+			analyzer.OutOfRange,
+		analyzer.Summarized, analyzer.ParseErrors, analyzer.Filtered,
+		analyzer.WriteErrors, analyzer.Skipped, analyzer.Unsampled,
+		analyzer.Excluded, analyzer.OutOfRange, analyzer.EvictedUpdates)
+	if issuersFile != "" {
+		if err := saveIssuers(analyzer, issuersFile); err != nil {
+			logger.Errorf("Failed to save issuer reputations to %s: %s",
+				issuersFile, err)
 		}
-	}, maxEntries)
-	fmt.Fprintf(out, "]}\n")
+	}
+	exampleMap := analyzer.ExampleMap
+	exampleMapLastSeen := analyzer.ExampleMapLastSeen
 	// Normalize all our scores
-	for _, issuer := range issuers {
-		issuer.Finish()
-		_, err = insertIssuerStatement.Exec(issuer.Issuer,
-			issuer.IssuerInMozillaDB,
-			issuer.Scores[VALID_PERIOD_TOO_LONG].NormalizedScore,
-			issuer.Scores[VALID_PERIOD_TOO_LONG].RawScore,
-			issuer.Scores[DEPRECATED_VERSION].NormalizedScore,
-			issuer.Scores[DEPRECATED_VERSION].RawScore,
-			issuer.Scores[DEPRECATED_SIGNATURE_ALGORITHM].NormalizedScore,
-			issuer.Scores[DEPRECATED_SIGNATURE_ALGORITHM].RawScore,
-			issuer.Scores[MISSING_CN_IN_SAN].NormalizedScore,
-			issuer.Scores[MISSING_CN_IN_SAN].RawScore,
-			issuer.Scores[KEY_TOO_SHORT].NormalizedScore,
-			issuer.Scores[KEY_TOO_SHORT].RawScore,
-			issuer.Scores[EXP_TOO_SMALL].NormalizedScore,
-			issuer.Scores[EXP_TOO_SMALL].RawScore,
-			issuer.NormalizedScore,
-			issuer.RawScore,
-			issuer.NormalizedCount,
-			issuer.RawCount,
-			issuer.BeginTime)
+	finishedIssuers := finishIssuers(analyzer, server)
+	for _, issuer := range finishedIssuers {
+		err = insertIssuerReputation(insertIssuerStatement, issuer)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to insert entry: %s\n", err)
-			os.Exit(1)
+			logger.Errorf("Failed to insert issuer %s: %s", issuer.Issuer, err)
+		}
+	}
+
+	if issuerJSONFile != "" {
+		if err := writeIssuerJSON(issuerJSONFile, finishedIssuers); err != nil {
+			logger.Errorf("Failed to write issuer reputations to %s: %s",
+				issuerJSONFile, err)
+		}
+	}
+
+	if timeSeriesFile != "" {
+		points := append(IssuerTimeSeries(finishedIssuers), evictedPoints...)
+		SortTimeSeries(points)
+		if err := writeJSONFile(timeSeriesFile, points); err != nil {
+			logger.Errorf("Failed to write time series to %s: %s", timeSeriesFile, err)
+		}
+	}
+
+	if perIssuer != nil {
+		if err := perIssuer.write(outputDir, finishedIssuers); err != nil {
+			logger.Errorf("Failed to write issuer files to %s: %s", outputDir, err)
+		}
+	}
+
+	if keyReuseFile != "" {
+		if err := writeJSONFile(keyReuseFile, analyzer.KeyReuse(keyReuseIssuers)); err != nil {
+			logger.Errorf("Failed to write key reuse to %s: %s", keyReuseFile, err)
+		}
+	}
+
+	if domains != nil {
+		if err := writeJSONFile(domainsFile, domains.Groups(domainsMinCerts)); err != nil {
+			logger.Errorf("Failed to write domains to %s: %s", domainsFile, err)
+		}
+	}
+
+	if offendersFile != "" {
+		offenders := FindOffenders(finishedIssuers, offendersCheckSet,
+			offendersThreshold)
+		if err := writeJSONFile(offendersFile, offenders); err != nil {
+			logger.Errorf("Failed to write offenders to %s: %s", offendersFile, err)
+		}
+	}
+
+	for _, rank := range RankIssuers(finishedIssuers, float32(rankCountWeight)) {
+		_, err = insertRankStatement.Exec(rank.Rank,
+			rank.Reputation.Issuer,
+			rank.Reputation.IssuerSha256Fingerprint,
+			rank.Reputation.BeginTime,
+			rank.RankScore,
+			rank.Reputation.NormalizedScore,
+			rank.Reputation.NormalizedCount)
+		if err != nil {
+			logger.Errorf("Failed to insert rank of issuer %s: %s",
+				rank.Reputation.Issuer, err)
 		}
 	}
 
 	for issuer, examples := range exampleMap {
-		_, err = insertExampleStatement.Exec(issuer,
-			certToString(examples[VALID_PERIOD_TOO_LONG]),
-			exampleMapLastSeen[issuer][VALID_PERIOD_TOO_LONG],
-			certToString(examples[DEPRECATED_VERSION]),
-			exampleMapLastSeen[issuer][DEPRECATED_VERSION],
-			certToString(examples[DEPRECATED_SIGNATURE_ALGORITHM]),
-			exampleMapLastSeen[issuer][DEPRECATED_SIGNATURE_ALGORITHM],
-			certToString(examples[MISSING_CN_IN_SAN]),
-			exampleMapLastSeen[issuer][MISSING_CN_IN_SAN],
-			certToString(examples[KEY_TOO_SHORT]),
-			exampleMapLastSeen[issuer][KEY_TOO_SHORT],
-			certToString(examples[EXP_TOO_SMALL]),
-			exampleMapLastSeen[issuer][EXP_TOO_SMALL])
+		err = insertExamples(insertExampleStatement, issuer, examples,
+			exampleMapLastSeen[issuer])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to insert entry: %s\n", err)
-			os.Exit(1)
+			logger.Errorf("Failed to insert examples for issuer %s: %s",
+				issuer, err)
 		}
 	}
+	if validityHistogram {
+		for i, name := range ValidityBucketNames {
+			_, err = tx.Exec("insert into validityHistogram(bucket, count) values(?, ?)",
+				name, analyzer.Validity.Counts[i])
+			if err != nil {
+				logger.Errorf("Failed to insert validity histogram: %s", err)
+			}
+		}
+	}
+	err = insertRunMetadata(tx, runMetadata{
+		StartTime:   startTime,
+		EndTime:     time.Now(),
+		SourceFiles: sources,
+		MaxEntries:  maxEntries,
+		Config:      config,
+		SampleRate:  sampleRate,
+		SampleSeed:  sampleSeed,
+		AfterIndex:  afterIndex,
+		BeforeIndex: beforeIndex,
+	})
+	if err != nil {
+		logger.Errorf("Failed to insert run metadata: %s", err)
+	}
 	tx.Commit()
+	if validateOutput && jsonFile != "-" {
+		if err := out.Close(); err != nil {
+			logger.Errorf("Failed to write JSON output to %s: %s", jsonFile, err)
+			return 1
+		}
+		count, err := validateJSONOutput(jsonFile)
+		if err != nil {
+			logger.Errorf("JSON output %s is invalid: %s", jsonFile, err)
+			return 1
+		}
+		logger.Infof("JSON output %s has %d certs", jsonFile, count)
+	}
+	if server != nil && apiWait {
+		logger.Infof("Serving the API on %s until interrupted", apiAddr)
+		<-ctx.Done()
+	}
+	if status := gate.exitStatus(); status != 0 {
+		logger.Errorf("%d certs had violations in fail_on", gate.Matched)
+		return status
+	}
+	return 0
 }
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/monicachew/certificatetransparency"
+	. "github.com/mozkeeler/sunlight"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListLogFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	// Written out of order to make sure the listing is sorted.
+	for _, name := range []string{"shard-1.log", "shard-0.log", "notes.txt"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("entries"), 0644)
+		if err != nil {
+			t.Fatal("could not write entries file", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "shard-2.log"), 0755); err != nil {
+		t.Fatal("could not create subdirectory", err)
+	}
+
+	files, err := listLogFiles(dir, "*.log")
+	if err != nil {
+		t.Fatal("could not list log files", err)
+	}
+	expected := []string{
+		filepath.Join(dir, "shard-0.log"),
+		filepath.Join(dir, "shard-1.log"),
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, files)
+	}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, files)
+		}
+	}
+
+	files, err = listLogFiles(dir, "*")
+	if err != nil {
+		t.Fatal("could not list log files", err)
+	}
+	if len(files) != 3 {
+		t.Errorf("Expected all 3 regular files, got %v", files)
+	}
+}
+
+func TestJSONOutputToStdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal("could not create pipe", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out, err := openJSONOutput("-")
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal("could not open stdout for JSON output", err)
+	}
+	summaries := NewJSONSink(out)
+	for _, cn := range []string{"a.example.com", "b.example.com"} {
+		if err := summaries.Write(&CertSummary{CN: cn}, nil); err != nil {
+			t.Fatal("could not write summary", err)
+		}
+	}
+	summaries.Close()
+	out.Close()
+	w.Close()
+
+	written, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("could not read JSON output", err)
+	}
+	var decoded struct {
+		Certs []CertSummary
+	}
+	if err := json.Unmarshal(written, &decoded); err != nil {
+		t.Fatalf("Output isn't valid JSON: %s\n%s", err, written)
+	}
+	if len(decoded.Certs) != 2 || decoded.Certs[1].CN != "b.example.com" {
+		t.Errorf("Unexpected output:\n%s", written)
+	}
+}
+
+func TestQuietLogging(t *testing.T) {
+	var stderr bytes.Buffer
+	logger, err := newLogger(&stderr, "debug", true)
+	if err != nil {
+		t.Fatal("could not create logger", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "quiet.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(6, 0, 0),
+		DNSNames:     []string{"quiet.example.com"},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal("could not create cert", err)
+	}
+
+	// A run like main's, with a violating cert but no errors.
+	logger.Infof("Starting")
+	analyzer := NewAnalyzer(nil, nil, nil, NewJSONSink(ioutil.Discard))
+	analyzer.Log = logger
+	analyzer.ProcessEntry(&certificatetransparency.EntryAndPosition{
+		Entry: &certificatetransparency.Entry{
+			Timestamp: uint64(now.Unix()) * 1000,
+			X509Cert:  der,
+		},
+	}, nil)
+	logger.Warnf("Processed %d entries", analyzer.Summarized)
+	if analyzer.Summarized != 1 || stderr.Len() != 0 {
+		t.Errorf("Expected no output for a clean run, got %q", stderr.String())
+	}
+
+	logger.Errorf("Failed")
+	if stderr.Len() == 0 {
+		t.Error("Expected errors to be logged in quiet mode")
+	}
+	if _, err := newLogger(&stderr, "loud", true); err == nil {
+		t.Error("Expected an invalid log level to be an error in quiet mode")
+	}
+}
+
+func TestWriteIssuerJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	ts := uint64(time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC).Unix()) * 1000
+	var issuers []*IssuerReputation
+	for _, name := range []string{"Second CA", "First CA"} {
+		issuer := NewIssuerReputation(pkix.Name{CommonName: name}, ts)
+		issuer.Update(&CertSummary{
+			MaxReputation: -1,
+			Violations:    map[string]bool{KEY_TOO_SHORT: true},
+		})
+		issuer.Finish()
+		issuers = append(issuers, issuer)
+	}
+	filename := filepath.Join(dir, "issuers.json")
+	if err := writeIssuerJSON(filename, issuers); err != nil {
+		t.Fatal("could not write issuer JSON", err)
+	}
+
+	written, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal("could not read issuer JSON", err)
+	}
+	var decoded []IssuerReputation
+	if err := json.Unmarshal(written, &decoded); err != nil {
+		t.Fatalf("Output isn't a valid JSON array: %s\n%s", err, written)
+	}
+	if len(decoded) != 2 || decoded[0].Issuer != "CN=First CA" ||
+		decoded[1].Issuer != "CN=Second CA" {
+		t.Fatalf("Unexpected issuers:\n%s", written)
+	}
+	score := decoded[0].Scores[KEY_TOO_SHORT]
+	if score == nil || score.RawScore != 0 || decoded[0].RawCount != 1 ||
+		decoded[0].BeginTime != ts {
+		t.Errorf("Unexpected reputation:\n%s", written)
+	}
+	// None of the certs are for domains in Alexa, so there are no normalized
+	// scores.
+	if !bytes.Contains(written, []byte(`"NormalizedScore":null`)) {
+		t.Errorf("Expected null normalized scores:\n%s", written)
+	}
+}
+
+func TestIssuersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "issuers.json")
+
+	// There's nothing to load before the first run.
+	first := NewAnalyzer(nil, nil, nil, nil)
+	if err := loadIssuers(first, name); err != nil {
+		t.Fatal("could not load missing issuers file", err)
+	}
+	issuer := NewIssuerReputation(pkix.Name{CommonName: "Example CA"}, 1402580730123)
+	issuer.Update(&CertSummary{MaxReputation: -1})
+	first.Issuers["example"] = issuer
+	if err := saveIssuers(first, name); err != nil {
+		t.Fatal("could not save issuers", err)
+	}
+
+	second := NewAnalyzer(nil, nil, nil, nil)
+	if err := loadIssuers(second, name); err != nil {
+		t.Fatal("could not load issuers", err)
+	}
+	if len(second.Issuers) != 1 {
+		t.Fatalf("Expected 1 issuer, got %d", len(second.Issuers))
+	}
+	for _, loaded := range second.Issuers {
+		if loaded.Issuer != "CN=Example CA" || loaded.RawCount != 1 {
+			t.Errorf("Unexpected issuer %+v", loaded)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("Expected only the issuers file, got %v", files)
+	}
+}
+
+func TestInsertStatementsMatchTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "BRs.db"))
+	if err != nil {
+		t.Fatal("could not open DB", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(createTables); err != nil {
+		t.Fatal("could not create tables", err)
+	}
+	// SQLite refuses to prepare an insert with more or fewer values than
+	// columns, or into columns that don't exist.
+	for _, statement := range []string{insertEntry, insertIssuer, insertRank,
+		insertExample} {
+		stmt, err := db.Prepare(statement)
+		if err != nil {
+			t.Errorf("could not prepare %s: %s", statement, err)
+			continue
+		}
+		stmt.Close()
+	}
+}
+
+func TestRunMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "BRs.db"))
+	if err != nil {
+		t.Fatal("could not open DB", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(createTables); err != nil {
+		t.Fatal("could not create tables", err)
+	}
+
+	start := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	err = insertRunMetadata(db, runMetadata{
+		StartTime:   start,
+		EndTime:     start.Add(time.Hour),
+		SourceFiles: []string{"ct_entries.log"},
+		MaxEntries:  100,
+		Config:      &RuleConfig{ShortKeyBits: 2048},
+		SampleRate:  0.5,
+		SampleSeed:  7,
+		AfterIndex:  1000,
+		BeforeIndex: 2000,
+	})
+	if err != nil {
+		t.Fatal("could not insert run metadata", err)
+	}
+
+	var sourceFiles, version, config string
+	var maxEntries uint64
+	var sampleRate float64
+	var sampleSeed int64
+	var afterIndex, beforeIndex uint64
+	err = db.QueryRow(`select sourceFiles, toolVersion, maxEntries, ruleConfig,
+		sampleRate, sampleSeed, afterIndex, beforeIndex from runMetadata`).Scan(
+		&sourceFiles, &version, &maxEntries, &config, &sampleRate, &sampleSeed,
+		&afterIndex, &beforeIndex)
+	if err != nil {
+		t.Fatal("could not read run metadata", err)
+	}
+	if sourceFiles != `["ct_entries.log"]` || version != toolVersion ||
+		maxEntries != 100 || sampleRate != 0.5 || sampleSeed != 7 ||
+		afterIndex != 1000 || beforeIndex != 2000 {
+		t.Errorf("Unexpected run metadata: %s, %s, %d, %f, %d, [%d, %d)",
+			sourceFiles, version, maxEntries, sampleRate, sampleSeed, afterIndex,
+			beforeIndex)
+	}
+	var decoded struct {
+		ShortKeyBits int
+		CTLogKeys    []string
+	}
+	if err := json.Unmarshal([]byte(config), &decoded); err != nil ||
+		decoded.ShortKeyBits != 2048 || len(decoded.CTLogKeys) != 0 {
+		t.Errorf("Unexpected rule config %s", config)
+	}
+}
+
+func TestInsertExamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "BRs.db"))
+	if err != nil {
+		t.Fatal("could not open DB", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(createTables); err != nil {
+		t.Fatal("could not create tables", err)
+	}
+	stmt, err := db.Prepare(insertExample)
+	if err != nil {
+		t.Fatal("could not prepare insert", err)
+	}
+	defer stmt.Close()
+
+	cert := &x509.Certificate{Raw: []byte("example")}
+	examples := map[string]*x509.Certificate{
+		KEY_TOO_SHORT:         cert,
+		VALID_PERIOD_TOO_LONG: cert,
+	}
+	lastSeen := map[string]uint64{KEY_TOO_SHORT: 1, VALID_PERIOD_TOO_LONG: 2}
+	if err = insertExamples(stmt, "CN=Test CA", examples, lastSeen); err != nil {
+		t.Fatal("could not insert examples", err)
+	}
+
+	var count int
+	err = db.QueryRow("select count(*) from examples where issuer = ?",
+		"CN=Test CA").Scan(&count)
+	if err != nil {
+		t.Fatal("could not count examples", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 example rows, got %d", count)
+	}
+	var pem string
+	err = db.QueryRow("select certPem from examples where violation = ?",
+		KEY_TOO_SHORT).Scan(&pem)
+	if err != nil || pem != CertToPEM(cert) {
+		t.Errorf("Unexpected example for %s: %q, %v", KEY_TOO_SHORT, pem, err)
+	}
+}
+
+func TestStorePEM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "BRs.db"))
+	if err != nil {
+		t.Fatal("could not open DB", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec(createTables); err != nil {
+		t.Fatal("could not create tables", err)
+	}
+	stmt, err := db.Prepare(insertEntry)
+	if err != nil {
+		t.Fatal("could not prepare insert", err)
+	}
+	defer stmt.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pem.example.com"},
+		NotBefore:    time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:     []string{"pem.example.com"},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal("could not create cert", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("could not parse cert", err)
+	}
+	summary, _ := CalculateCertSummary(cert, 1, 0, false, nil, nil, nil, nil)
+	stored := sqlSink{stmt, true}
+	if err = stored.Write(summary, cert); err != nil {
+		t.Fatal("could not insert summary", err)
+	}
+	summary.LogIndex = 2
+	if err = (sqlSink{stmt, false}).Write(summary, cert); err != nil {
+		t.Fatal("could not insert summary", err)
+	}
+
+	var certPem sql.NullString
+	err = db.QueryRow("select certPem from baselineRequirements where logIndex = 1").
+		Scan(&certPem)
+	if err != nil || !certPem.Valid {
+		t.Fatalf("Expected a stored PEM, got %v, %v", certPem, err)
+	}
+	parsed, err := ParseCertificates([]byte(certPem.String))
+	if err != nil || len(parsed) != 1 || !parsed[0].Equal(cert) {
+		t.Errorf("Stored PEM doesn't parse to the cert: %v", err)
+	}
+	err = db.QueryRow("select certPem from baselineRequirements where logIndex = 2").
+		Scan(&certPem)
+	if err != nil || certPem.Valid {
+		t.Errorf("Expected no PEM without store_pem, got %v, %v", certPem, err)
+	}
+}
+
+// Runs entries through an EntryReorderer in a random order, as Map would,
+// and returns the JSON output.
+func singleThreadedOutput(t *testing.T,
+	entries []*certificatetransparency.EntryAndPosition) []byte {
+	var out bytes.Buffer
+	summaries := NewJSONSink(&out)
+	analyzer := NewAnalyzer(nil, nil, nil, summaries)
+	reorderer := NewEntryReorderer(0, analyzer.ProcessEntry)
+	var wg sync.WaitGroup
+	for _, i := range rand.Perm(len(entries)) {
+		wg.Add(1)
+		go func(ent *certificatetransparency.EntryAndPosition) {
+			defer wg.Done()
+			reorderer.Add(ent, nil)
+		}(entries[i])
+	}
+	wg.Wait()
+	reorderer.Flush()
+	summaries.Close()
+	return out.Bytes()
+}
+
+func TestSingleThreadedOutputIsReproducible(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	now := time.Now()
+	var entries []*certificatetransparency.EntryAndPosition
+	for i := 0; i < 20; i++ {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 1)),
+			Subject:      pkix.Name{CommonName: fmt.Sprintf("%d.example.com", i)},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     now.AddDate(6, 0, 0),
+		}
+		der, err := x509.CreateCertificate(crand.Reader, template, template,
+			&key.PublicKey, key)
+		if err != nil {
+			t.Fatal("could not create cert", err)
+		}
+		entries = append(entries, &certificatetransparency.EntryAndPosition{
+			Index: uint64(i),
+			Entry: &certificatetransparency.Entry{
+				Timestamp: uint64(now.Unix()) * 1000,
+				X509Cert:  der,
+			},
+		})
+	}
+
+	first := singleThreadedOutput(t, entries)
+	second := singleThreadedOutput(t, entries)
+	if !bytes.Equal(first, second) {
+		t.Errorf("Single-threaded runs differ:\n%s\n!=\n%s", first, second)
+	}
+	if !bytes.Contains(first, []byte(`"CN":"0.example.com"`)) {
+		t.Errorf("Expected the output to contain the certs:\n%s", first)
+	}
+}
+
+// Certs read from a file have no log time, so they aren't judged against one.
+func TestCertFileEntryIsNotLateLogged(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key", err)
+	}
+	notBefore := time.Now().AddDate(-1, 0, 0)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "file.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.AddDate(6, 0, 0),
+		DNSNames:     []string{"file.example.com"},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal("could not create cert", err)
+	}
+	dir, err := ioutil.TempDir("", "sunlight")
+	if err != nil {
+		t.Fatal("could not create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "cert.der")
+	if err := ioutil.WriteFile(name, der, 0644); err != nil {
+		t.Fatal("could not write cert file", err)
+	}
+
+	ent, err := certFileEntry(name)
+	if err != nil {
+		t.Fatal("could not read cert file", err)
+	}
+	var summaries []*CertSummary
+	analyzer := NewAnalyzer(nil, nil, nil,
+		SinkFunc(func(summary *CertSummary, cert *x509.Certificate) error {
+			summaries = append(summaries, summary)
+			return nil
+		}))
+	if err := analyzer.ProcessUnlogged(ent); err != nil {
+		t.Fatal("could not analyze cert file", err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("Expected the cert to be recorded, got %d summaries", len(summaries))
+	}
+	if summaries[0].Violations[LATE_LOGGING] || summaries[0].LoggingDelay != 0 {
+		t.Errorf("Expected no LateLogging, got a logging delay of %d ms",
+			summaries[0].LoggingDelay)
+	}
+}
@@ -0,0 +1,51 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"sync/atomic"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// The upper bounds (inclusive) of the buckets of a ValidityHistogram, apart
+// from the last, which has none.
+var validityBucketBounds = []time.Duration{
+	90 * day,
+	365 * day,
+	398 * day,
+	2 * 365 * day,
+	5 * 365 * day,
+}
+
+// The names of the buckets of a ValidityHistogram, in order.
+var ValidityBucketNames = []string{
+	"<=90d",
+	"<=1y",
+	"<=398d",
+	"<=2y",
+	"<=5y",
+	">5y",
+}
+
+// Counts of certs by the length of their validity period. It's safe to Add
+// to concurrently.
+type ValidityHistogram struct {
+	Counts [6]uint64
+}
+
+// Returns the index of the bucket a cert valid from notBefore to notAfter
+// falls in.
+func validityBucket(notBefore time.Time, notAfter time.Time) int {
+	validity := notAfter.Sub(notBefore)
+	for i, bound := range validityBucketBounds {
+		if validity <= bound {
+			return i
+		}
+	}
+	return len(validityBucketBounds)
+}
+
+func (h *ValidityHistogram) Add(cert *x509.Certificate) {
+	atomic.AddUint64(&h.Counts[validityBucket(cert.NotBefore, cert.NotAfter)], 1)
+}
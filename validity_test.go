@@ -0,0 +1,34 @@
+package sunlight
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestValidityHistogram(t *testing.T) {
+	notBefore := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	lifetimes := []time.Duration{
+		30 * day,
+		90 * day,
+		180 * day,
+		398 * day,
+		2 * 365 * day,
+		3 * 365 * day,
+		10 * 365 * day,
+	}
+	var histogram ValidityHistogram
+	for _, lifetime := range lifetimes {
+		histogram.Add(&x509.Certificate{
+			NotBefore: notBefore,
+			NotAfter:  notBefore.Add(lifetime),
+		})
+	}
+	expected := [6]uint64{2, 1, 1, 1, 1, 1}
+	if histogram.Counts != expected {
+		for i, name := range ValidityBucketNames {
+			t.Errorf("%s: expected %d, got %d", name, expected[i],
+				histogram.Counts[i])
+		}
+	}
+}